@@ -5,6 +5,7 @@ go 1.19
 require (
 	cloud.google.com/go/spanner v1.47.0
 	github.com/gin-gonic/gin v1.9.1
+	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/google/uuid v1.3.0
 	github.com/spf13/viper v1.16.0
 	github.com/stretchr/testify v1.8.4
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-item-service/auth"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-item-service/config"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-item-service/models"
 	"github.com/gin-gonic/gin"
@@ -116,6 +117,7 @@ func getItem(c *gin.Context) {
 
 // updatePlayerBalance responds to the PUT /players/balance endpoint
 // Update a player balance with a provided amount. Result is a JSON object that contains PlayerUUID and AccountBalance
+// Requires an access token issued to the player in the ledger entry.
 // TODO: fix code to update a player's balance, not a ledger balance
 func updatePlayerBalance(c *gin.Context) {
 	var player models.Player
@@ -129,6 +131,10 @@ func updatePlayerBalance(c *gin.Context) {
 		return
 	}
 
+	if !auth.Authorize(c, ledger.PlayerUUID) {
+		return
+	}
+
 	ctx, client := getSpannerConnection(c)
 	if err := player.UpdateBalance(ctx, client, ledger); err != nil {
 		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
@@ -162,6 +168,7 @@ func getPlayer(c *gin.Context) {
 
 // addPlayerItem responds to the POST /players/items endpoint
 // Adds an item to the player's list of items when provided a valid game itemUUID.
+// Requires an access token issued to the player.
 // TODO: ensure only private access from valid game servers
 func addPlayerItem(c *gin.Context) {
 	var playerItem models.PlayerItem
@@ -173,6 +180,10 @@ func addPlayerItem(c *gin.Context) {
 		return
 	}
 
+	if !auth.Authorize(c, playerItem.PlayerUUID) {
+		return
+	}
+
 	ctx, client := getSpannerConnection(c)
 	if err := playerItem.Add(ctx, client); err != nil {
 		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
@@ -197,12 +208,14 @@ func main() {
 
 	router.Use(setSpannerConnection(configuration))
 
+	requireToken := auth.RequireToken(configuration.Auth)
+
 	router.GET("/items", getItemUUIDs)
 	router.POST("/items", createItem)
 	router.GET("/items/:id", getItem)
-	router.PUT("/players/balance", updatePlayerBalance) // TODO: leverage profile service instead
+	router.PUT("/players/balance", requireToken, updatePlayerBalance) // TODO: leverage profile service instead
 	router.GET("/players", getPlayer)
-	router.POST("/players/items", addPlayerItem)
+	router.POST("/players/items", requireToken, addPlayerItem)
 
 	if err := router.Run(configuration.Server.URL()); err != nil {
 		fmt.Printf("could not run gin router: %s", err)
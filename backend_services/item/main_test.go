@@ -29,6 +29,7 @@ import (
 	"embed"
 	"fmt"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-item-service/models"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -49,6 +50,8 @@ var SCHEMAFILE embed.FS
 
 var TESTNETWORK = "game-sample-test"
 
+var TESTSECRET = "integration-test-secret"
+
 // These integration tests run against the Spanner emulator. The emulator
 // must be running and accessible prior to integration tests running.
 
@@ -253,6 +256,7 @@ func setupService(ctx context.Context, ec *Emulator) (*Service, error) {
 			"SERVICE_HOST":          "0.0.0.0",
 			"SERVICE_PORT":          "80",
 			"SPANNER_EMULATOR_HOST": ec.Endpoint,
+			"AUTH_SECRET":           TESTSECRET,
 		},
 		WaitingFor: wait.ForLog("Listening and serving HTTP on 0.0.0.0:80"),
 	}
@@ -309,13 +313,20 @@ func TestMain(m *testing.M) {
 }
 
 func httpPUT(url string, data io.Reader) (*http.Response, error) {
+	return httpRequest(http.MethodPut, url, data, "")
+}
+
+func httpRequest(method string, url string, data io.Reader, token string) (*http.Response, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPut, url, data)
+	req, err := http.NewRequest(method, url, data)
 	if err != nil {
 		return nil, err
 	}
 	// set the request header Content-Type for json
 	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -324,6 +335,23 @@ func httpPUT(url string, data io.Reader) (*http.Response, error) {
 	return response, nil
 }
 
+// testToken signs an access token for the player the same way the profile service does
+func testToken(t *testing.T, playerUUID string) string {
+	claims := jwt.RegisteredClaims{
+		Issuer:    "profile-service",
+		Subject:   playerUUID,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(TESTSECRET))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return token
+}
+
 func TestCreateGameItems(t *testing.T) {
 	response, err := http.Post("http://localhost/items", "application/json", bytes.NewBuffer([]byte("{\"item_name\": \"test item\",\"item_value\": \"3.14\"}")))
 	if err != nil {
@@ -364,10 +392,23 @@ func TestPlayerAcquireItemsAndMoney(t *testing.T) {
 	testPB := PlayerBalanceRequest{PlayerUUID: pData.PlayerUUID, Source: "loot", Amount: r.FloatString(2)}
 	pbJSON, _ := json.Marshal(testPB)
 
+	// Updating a balance requires the player's access token
 	response, err = httpPUT("http://localhost/players/balance", bytes.NewBuffer(pbJSON))
 	if err != nil {
 		t.Fatal(err.Error())
 	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPut, "http://localhost/players/balance", bytes.NewBuffer(pbJSON), testToken(t, uuid.NewString()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPut, "http://localhost/players/balance", bytes.NewBuffer(pbJSON), testToken(t, pData.PlayerUUID))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
 	assert.Equal(t, 200, response.StatusCode)
 
 	body, err = ioutil.ReadAll(response.Body)
@@ -425,7 +466,7 @@ func TestPlayerAcquireItemsAndMoney(t *testing.T) {
 		testPI := PlayerItemRequest{PlayerUUID: pData.PlayerUUID, ItemUUID: giData.ItemUUID, Source: "loot"}
 		piJSON, _ := json.Marshal(testPI)
 
-		response, err = http.Post("http://localhost/players/items", "application/json", bytes.NewBuffer(piJSON))
+		response, err = httpRequest(http.MethodPost, "http://localhost/players/items", bytes.NewBuffer(piJSON), testToken(t, pData.PlayerUUID))
 		if err != nil {
 			t.Fatal(err.Error())
 		}
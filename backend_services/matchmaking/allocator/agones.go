@@ -0,0 +1,257 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+)
+
+// ErrNoGameServer is returned when Agones has no ready game server to allocate
+var ErrNoGameServer = errors.New("no game server available")
+
+// ErrReleaseUnavailable is returned when releasing a game server without a Kubernetes API to release it through
+var ErrReleaseUnavailable = errors.New("no kubernetes api to release game servers through")
+
+// fleetLabel is the label Agones sets on every game server with the name of its fleet
+const fleetLabel = "agones.dev/fleet"
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod for its service account
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Agones allocates game servers through the REST API of the Agones allocator service, and releases them
+// by deleting the GameServer through the Kubernetes API, after which its fleet replaces it with a ready one.
+type Agones struct {
+	endpoint    string
+	namespace   string
+	fleet       string
+	regionLabel string
+	client      *http.Client
+
+	api       string
+	apiToken  string
+	apiClient *http.Client
+}
+
+// agonesSelector selects game servers by their labels
+type agonesSelector struct {
+	MatchLabels map[string]string `json:"matchLabels"`
+}
+
+// agonesMetadata holds the labels applied to the allocated game server
+type agonesMetadata struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// agonesRequest is the body of a GameServerAllocation request
+type agonesRequest struct {
+	Namespace           string           `json:"namespace"`
+	GameServerSelectors []agonesSelector `json:"gameServerSelectors"`
+	Metadata            agonesMetadata   `json:"metadata"`
+}
+
+// agonesResponse is the allocated game server
+type agonesResponse struct {
+	GameServerName string `json:"gameServerName"`
+	Address        string `json:"address"`
+	Ports          []struct {
+		Name string `json:"name"`
+		Port int    `json:"port"`
+	} `json:"ports"`
+}
+
+// NewAgones returns an allocator for the Agones allocator service at the configured endpoint.
+// When the client certificate is set, requests use mTLS, and the CA certificate verifies the service.
+// Game servers are released through the Kubernetes API at Agones_api_endpoint, or through the cluster's
+// API with the pod's service account when running in Kubernetes.
+func NewAgones(c config.AllocatorConfig) (*Agones, error) {
+	if c.Agones_endpoint == "" {
+		return nil, errors.New("the agones allocator needs an endpoint")
+	}
+
+	tlsConfig := &tls.Config{}
+	if c.Agones_client_cert != "" {
+		cert, err := tls.LoadX509KeyPair(c.Agones_client_cert, c.Agones_client_key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.Agones_ca_cert != "" {
+		ca, err := os.ReadFile(c.Agones_ca_cert)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA certificate: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("could not parse CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	a := &Agones{
+		endpoint:    strings.TrimSuffix(c.Agones_endpoint, "/"),
+		namespace:   c.Agones_namespace,
+		fleet:       c.Agones_fleet,
+		regionLabel: c.Agones_region_label,
+		client: &http.Client{
+			Timeout:   c.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		api:       strings.TrimSuffix(c.Agones_api_endpoint, "/"),
+		apiClient: &http.Client{Timeout: c.Timeout},
+	}
+
+	if host := os.Getenv("KUBERNETES_SERVICE_HOST"); a.api == "" && host != "" {
+		token, err := os.ReadFile(serviceAccountDir + "/token")
+		if err != nil {
+			return nil, fmt.Errorf("could not read service account token: %s", err)
+		}
+
+		ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+		if err != nil {
+			return nil, fmt.Errorf("could not read service account CA certificate: %s", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("could not parse service account CA certificate")
+		}
+
+		a.api = "https://" + net.JoinHostPort(host, os.Getenv("KUBERNETES_SERVICE_PORT"))
+		a.apiToken = strings.TrimSpace(string(token))
+		a.apiClient.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	return a, nil
+}
+
+// Allocate requests a ready game server from the fleet, in the game's region when region labels are used.
+// The game server is labeled with the game it was allocated for.
+// Returns ErrNoGameServer if no game server is ready.
+func (a *Agones) Allocate(ctx context.Context, r Request) (Endpoint, error) {
+	labels := map[string]string{}
+	if a.fleet != "" {
+		labels[fleetLabel] = a.fleet
+	}
+	if a.regionLabel != "" && r.Region != "" {
+		labels[a.regionLabel] = r.Region
+	}
+
+	body, err := json.Marshal(agonesRequest{
+		Namespace:           a.namespace,
+		GameServerSelectors: []agonesSelector{{MatchLabels: labels}},
+		Metadata: agonesMetadata{Labels: map[string]string{
+			"game": r.GameUUID,
+			"mode": r.Mode,
+		}},
+	})
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint+"/gameserverallocation", bytes.NewBuffer(body))
+	if err != nil {
+		return Endpoint{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := a.client.Do(req)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return Endpoint{}, err
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		return Endpoint{}, ErrNoGameServer
+	}
+	if response.StatusCode != http.StatusOK {
+		return Endpoint{}, fmt.Errorf("allocation failed with status %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	var allocation agonesResponse
+	if err := json.Unmarshal(data, &allocation); err != nil {
+		return Endpoint{}, fmt.Errorf("could not unmarshal json: %s", err)
+	}
+
+	if allocation.Address == "" || len(allocation.Ports) == 0 {
+		return Endpoint{}, ErrNoGameServer
+	}
+
+	return Endpoint{
+		Server:  allocation.GameServerName,
+		Address: allocation.Address,
+		Port:    allocation.Ports[0].Port,
+	}, nil
+}
+
+// Release deletes the allocated GameServer, so a game that couldn't be started doesn't hold on to it.
+// A game server that no longer exists is already released.
+// Returns ErrReleaseUnavailable if no Kubernetes API is configured.
+func (a *Agones) Release(ctx context.Context, e Endpoint) error {
+	if e.Server == "" {
+		return nil
+	}
+	if a.api == "" {
+		return ErrReleaseUnavailable
+	}
+
+	path := fmt.Sprintf("%s/apis/agones.dev/v1/namespaces/%s/gameservers/%s", a.api, url.PathEscape(a.namespace), url.PathEscape(e.Server))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, path, nil)
+	if err != nil {
+		return err
+	}
+	if a.apiToken != "" {
+		req.Header.Set("Authorization", "Bearer "+a.apiToken)
+	}
+
+	response, err := a.apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusNotFound:
+		return nil
+	}
+
+	return fmt.Errorf("release failed with status %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,68 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package allocator reserves a game server for each new game, so its players
+// know where to connect.
+//
+// Game servers are allocated through Agones, or through an in-process fake in tests.
+package allocator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+)
+
+// Request describes the game a server is allocated for
+type Request struct {
+	GameUUID string
+	Mode     string
+	Region   string
+	Players  []string
+}
+
+// Endpoint is where the game's players connect to its game server
+type Endpoint struct {
+	Server  string
+	Address string
+	Port    int
+}
+
+// String returns the endpoint in format 'address:port'
+func (e Endpoint) String() string {
+	return net.JoinHostPort(e.Address, strconv.Itoa(e.Port))
+}
+
+// Allocator reserves a game server for a new game, and releases it when the game can't use it
+type Allocator interface {
+	Allocate(ctx context.Context, r Request) (Endpoint, error)
+	Release(ctx context.Context, e Endpoint) error
+}
+
+// New returns the allocator for the configured type, or nil when allocation is turned off
+func New(c config.AllocatorConfig) (Allocator, error) {
+	switch c.Type {
+	case "":
+		return nil, nil
+	case "fake":
+		return NewFake(), nil
+	case "agones":
+		return NewAgones(c)
+	default:
+		return nil, fmt.Errorf("unknown allocator type '%s'", c.Type)
+	}
+}
@@ -0,0 +1,152 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	a, err := New(config.AllocatorConfig{})
+	assert.Nil(t, err)
+	assert.Nil(t, a)
+
+	a, err = New(config.AllocatorConfig{Type: "fake"})
+	assert.Nil(t, err)
+	assert.IsType(t, &Fake{}, a)
+
+	_, err = New(config.AllocatorConfig{Type: "agones"})
+	assert.NotNil(t, err)
+
+	_, err = New(config.AllocatorConfig{Type: "kubernetes"})
+	assert.NotNil(t, err)
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake()
+
+	first, err := f.Allocate(context.Background(), Request{GameUUID: "1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:7000", first.String())
+
+	second, err := f.Allocate(context.Background(), Request{GameUUID: "2"})
+	assert.Nil(t, err)
+	assert.Equal(t, 7001, second.Port)
+
+	f.Err = errors.New("no servers")
+	_, err = f.Allocate(context.Background(), Request{GameUUID: "3"})
+	assert.NotNil(t, err)
+
+	allocations := f.Allocations()
+	assert.Len(t, allocations, 2)
+	assert.Equal(t, "2", allocations[1].GameUUID)
+
+	assert.Nil(t, f.Release(context.Background(), second))
+	assert.Equal(t, []Endpoint{second}, f.Released())
+}
+
+func TestAgones(t *testing.T) {
+	var received agonesRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/gameserverallocation", r.URL.Path)
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if received.Metadata.Labels["game"] == "full" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write([]byte(`{"gameServerName": "fleet-abc", "address": "10.0.0.1", "ports": [{"name": "default", "port": 7654}]}`))
+	}))
+	defer server.Close()
+
+	a, err := NewAgones(config.AllocatorConfig{
+		Timeout:             time.Second,
+		Agones_endpoint:     server.URL,
+		Agones_namespace:    "games",
+		Agones_fleet:        "arena",
+		Agones_region_label: "region",
+	})
+	assert.Nil(t, err)
+
+	endpoint, err := a.Allocate(context.Background(), Request{GameUUID: "1", Mode: "5v5", Region: "us-east1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "fleet-abc", endpoint.Server)
+	assert.Equal(t, "10.0.0.1:7654", endpoint.String())
+
+	assert.Equal(t, "games", received.Namespace)
+	assert.Equal(t, map[string]string{fleetLabel: "arena", "region": "us-east1"}, received.GameServerSelectors[0].MatchLabels)
+	assert.Equal(t, "1", received.Metadata.Labels["game"])
+
+	_, err = a.Allocate(context.Background(), Request{GameUUID: "full"})
+	assert.ErrorIs(t, err, ErrNoGameServer)
+}
+
+func TestAgonesRelease(t *testing.T) {
+	var deleted []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodDelete, r.Method)
+		deleted = append(deleted, r.URL.Path)
+
+		switch r.URL.Path {
+		case "/apis/agones.dev/v1/namespaces/games/gameservers/gone":
+			w.WriteHeader(http.StatusNotFound)
+		case "/apis/agones.dev/v1/namespaces/games/gameservers/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer api.Close()
+
+	a, err := NewAgones(config.AllocatorConfig{
+		Timeout:             time.Second,
+		Agones_endpoint:     "https://allocator",
+		Agones_namespace:    "games",
+		Agones_api_endpoint: api.URL,
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, a.Release(context.Background(), Endpoint{Server: "fleet-abc"}))
+	assert.Equal(t, []string{"/apis/agones.dev/v1/namespaces/games/gameservers/fleet-abc"}, deleted)
+
+	// A game server that no longer exists is already released
+	assert.Nil(t, a.Release(context.Background(), Endpoint{Server: "gone"}))
+	assert.NotNil(t, a.Release(context.Background(), Endpoint{Server: "forbidden"}))
+
+	// Nothing is released without a game server
+	assert.Nil(t, a.Release(context.Background(), Endpoint{}))
+	assert.Len(t, deleted, 3)
+}
+
+func TestAgonesReleaseUnavailable(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	a, err := NewAgones(config.AllocatorConfig{Agones_endpoint: "https://allocator"})
+	assert.Nil(t, err)
+
+	assert.ErrorIs(t, a.Release(context.Background(), Endpoint{Server: "fleet-abc"}), ErrReleaseUnavailable)
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package allocator
+
+import (
+	"context"
+	"sync"
+)
+
+// fakeBasePort is the port of the first game server allocated by the fake
+const fakeBasePort = 7000
+
+// Fake allocates game servers in-process, giving each game its own port on Address.
+// Setting Err makes allocations fail, to test how failures are handled.
+type Fake struct {
+	Address string
+	Err     error
+
+	mu          sync.Mutex
+	allocations []Request
+	released    []Endpoint
+}
+
+// NewFake returns a fake allocator for game servers on localhost
+func NewFake() *Fake {
+	return &Fake{Address: "127.0.0.1"}
+}
+
+// Allocate records the request and returns the next port, or Err when it is set
+func (f *Fake) Allocate(ctx context.Context, r Request) (Endpoint, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.Err != nil {
+		return Endpoint{}, f.Err
+	}
+
+	f.allocations = append(f.allocations, r)
+
+	return Endpoint{
+		Server:  "fake-" + r.GameUUID,
+		Address: f.Address,
+		Port:    fakeBasePort + len(f.allocations) - 1,
+	}, nil
+}
+
+// Release records the game server as released
+func (f *Fake) Release(ctx context.Context, e Endpoint) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.released = append(f.released, e)
+
+	return nil
+}
+
+// Allocations returns the requests that were allocated a game server, in order
+func (f *Fake) Allocations() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Request{}, f.allocations...)
+}
+
+// Released returns the game servers that were released, in order
+func (f *Fake) Released() []Endpoint {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Endpoint{}, f.released...)
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth verifies the signed access tokens issued by the profile service
+// when a player logs in.
+//
+// Tokens are HMAC signed JWTs whose subject is the playerUUID. The secret must
+// match the one configured for the profile service.
+//
+// Endpoints called by game servers instead require a secret shared with the
+// game servers, sent the same way as an access token.
+//
+// Each backend service is built from its own module, so this package is
+// copied into every service. ValidateToken, RequireToken and Authorize, and
+// their tests, must stay identical in all of the copies.
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	issuer = "profile-service"
+
+	// playerKey is the gin context key holding the authenticated playerUUID
+	playerKey = "auth_player"
+
+	// disabledKey is the gin context key set when token checks are turned off
+	disabledKey = "auth_disabled"
+)
+
+// ErrMissingSecret is returned when tokens are enabled without a signing secret
+var ErrMissingSecret = errors.New("no access token secret configured")
+
+// ErrMissingGameServerSecret is reported when tokens are enabled without a game server secret
+var ErrMissingGameServerSecret = errors.New("no game server secret configured")
+
+// ValidateToken verifies the signature and expiry of an access token and returns
+// the playerUUID it was issued to.
+func ValidateToken(c config.AuthConfig, token string) (string, error) {
+	if c.Secret == "" {
+		return "", ErrMissingSecret
+	}
+
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(c.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if claims.Subject == "" {
+		return "", errors.New("token has no subject")
+	}
+
+	return claims.Subject, nil
+}
+
+// bearerToken is a private helper to read the token from an 'Authorization: Bearer' header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
+
+// RequireToken is a middleware that rejects requests without a valid access token.
+// The authenticated playerUUID is stored in the gin context for Authorize.
+func RequireToken(c config.AuthConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.Enabled {
+			ctx.Set(disabledKey, true)
+			ctx.Next()
+			return
+		}
+
+		token := bearerToken(ctx)
+		if token == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "missing access token"})
+			return
+		}
+
+		playerUUID, err := ValidateToken(c, token)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid access token"})
+			return
+		}
+
+		ctx.Set(playerKey, playerUUID)
+		ctx.Next()
+	}
+}
+
+// Authorize reports whether the request's access token belongs to the provided player.
+// If it does not, the request is aborted with a 403 response.
+func Authorize(ctx *gin.Context, playerUUID string) bool {
+	if ctx.GetBool(disabledKey) {
+		return true
+	}
+
+	if authenticated := ctx.GetString(playerKey); authenticated != "" && authenticated == playerUUID {
+		return true
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "access token does not belong to player"})
+	return false
+}
+
+// IsPlayer reports whether the request's access token belongs to the provided player, without aborting
+// the request. It is always true when token checks are turned off.
+func IsPlayer(ctx *gin.Context, playerUUID string) bool {
+	if ctx.GetBool(disabledKey) {
+		return true
+	}
+
+	authenticated := ctx.GetString(playerKey)
+	return authenticated != "" && authenticated == playerUUID
+}
+
+// RequireGameServer is a middleware that rejects requests without the secret shared with the game servers,
+// sent in an 'Authorization: Bearer' header. Every request is rejected until a secret is configured.
+func RequireGameServer(c config.AuthConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.Enabled {
+			ctx.Next()
+			return
+		}
+
+		if c.Game_server_secret == "" {
+			fmt.Printf("Error: %s\n", ErrMissingGameServerSecret)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid game server credentials"})
+			return
+		}
+
+		token := bearerToken(ctx)
+		if token == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "missing game server credentials"})
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.Game_server_secret)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid game server credentials"})
+			return
+		}
+
+		ctx.Next()
+	}
+}
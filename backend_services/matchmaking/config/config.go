@@ -20,15 +20,26 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // Config contains all of the available configurations for the matchmaking service
 type Config struct {
-	Server  ServerConfig
-	Spanner SpannerConfig
+	Server    ServerConfig
+	Spanner   SpannerConfig
+	Auth      AuthConfig
+	Rating    RatingConfig
+	Queue     QueueConfig
+	Game      GameConfig
+	Reaper    ReaperConfig
+	Party     PartyConfig
+	Region    RegionConfig
+	Allocator AllocatorConfig
+	Bot       BotConfig
 }
 
 // ServerConfig contains the information to expose the matchmaking service as a server
@@ -45,6 +56,105 @@ type SpannerConfig struct {
 	CredentialsFile string `mapstructure:"CREDENTIALS_FILE" yaml:"credentials_file,omitempty"`
 }
 
+// AuthConfig contains the information to verify player access tokens issued by the profile service.
+// Game_server_secret is shared with the game servers, which send it to close, leave and backfill games.
+type AuthConfig struct {
+	Enabled            bool
+	Secret             string
+	Game_server_secret string
+}
+
+// RatingConfig contains the rating window used to match players of similar skill.
+// Players are matched with others within Window_initial rating points, and the window widens
+// by Window_growth points for every second they have been waiting, up to Window_max.
+type RatingConfig struct {
+	Window_initial float64
+	Window_growth  float64
+	Window_max     float64
+}
+
+// QueueConfig contains the settings for the worker that forms games from queued players.
+// The worker runs every Worker_interval, and setting it to 0 turns it off. A game is formed as soon as
+// it is full. Once the oldest ticket for a game has waited Fill_timeout, it is formed with the mode's minimum players.
+// Tickets that are still queued after Ticket_ttl are expired, and setting it to 0 keeps them queued.
+type QueueConfig struct {
+	Worker_interval time.Duration
+	Fill_timeout    time.Duration
+	Ticket_ttl      time.Duration
+}
+
+// GameConfig contains the game modes players can be matched into, keyed by mode name.
+// Default_mode is used when a game is created without a mode. Simulate_results has a random winner chosen
+// for every closed game instead of the reported result, which is only meant for load tests without game servers.
+type GameConfig struct {
+	Default_mode     string
+	Modes            map[string]GameModeConfig
+	Simulate_results bool
+}
+
+// GameModeConfig describes how many players a game mode holds and how they are split into teams.
+// A game can start with between Min_players and Max_players, and Max_players can't be more than Teams * Team_size.
+type GameModeConfig struct {
+	Min_players int
+	Max_players int
+	Teams       int
+	Team_size   int
+}
+
+// ReaperConfig contains the settings for the worker that abandons games that were never closed.
+// Every Interval, games older than Max_game_duration are marked abandoned and their players released,
+// Batch_size games per transaction. Setting Max_game_duration to 0 turns the reaper off.
+// Players still in an abandoned game lose Abandon_penalty rating points.
+type ReaperConfig struct {
+	Interval          time.Duration
+	Max_game_duration time.Duration
+	Batch_size        int
+	Abandon_penalty   float64
+}
+
+// PartyConfig contains the settings for parties of players that are matched together.
+// A party holds at most Max_size players, including its leader.
+type PartyConfig struct {
+	Max_size int
+}
+
+// RegionConfig contains the regions games are hosted in. Players submit their ping to each region when they queue,
+// and are only matched into games in regions where their ping is within Max_latency.
+// Without Names, region matching is turned off and games have no region.
+type RegionConfig struct {
+	Names       []string
+	Max_latency time.Duration
+}
+
+// AllocatorConfig contains the settings for allocating a game server to each new game.
+// Type is "agones" to allocate through the Agones allocator service at Agones_endpoint, "fake" for the in-process
+// fake used in tests, or empty to turn allocation off. Agones game servers are selected from Agones_fleet, and also by
+// the game's region through Agones_region_label when it is set. The client certificate and CA files are used for mTLS.
+// Game servers that can't be used are released through the Kubernetes API at Agones_api_endpoint, which defaults to
+// the API of the cluster the service runs in.
+type AllocatorConfig struct {
+	Type                string
+	Timeout             time.Duration
+	Agones_endpoint     string
+	Agones_namespace    string
+	Agones_fleet        string
+	Agones_region_label string
+	Agones_client_cert  string
+	Agones_client_key   string
+	Agones_ca_cert      string
+	Agones_api_endpoint string
+}
+
+// BotConfig contains the policy for filling queued games with bot slots when too few players are queued.
+// Once the oldest ticket for a game has waited Fill_after, the game is formed with at least Min_players players
+// and its remaining slots are filled with bots. Setting Fill_after to 0 turns bots off. Players' rating changes from
+// games with bots are scaled by Rating_weight, between 0, which skips rating updates, and 1.
+type BotConfig struct {
+	Fill_after    time.Duration
+	Min_players   int
+	Rating_weight float64
+}
+
 // NewConfig initializes the configuration with default values and binds
 // environment variables and reads from any supplied config.yml file
 func NewConfig() (Config, error) {
@@ -58,6 +168,51 @@ func NewConfig() (Config, error) {
 	viper.SetDefault("server.host", "localhost")
 	viper.SetDefault("server.port", 8081)
 
+	// Auth defaults
+	viper.SetDefault("auth.enabled", true)
+
+	// Rating defaults
+	viper.SetDefault("rating.window_initial", 100)
+	viper.SetDefault("rating.window_growth", 5)
+	viper.SetDefault("rating.window_max", 600)
+
+	// Queue defaults
+	viper.SetDefault("queue.worker_interval", "1s")
+	viper.SetDefault("queue.fill_timeout", "30s")
+	viper.SetDefault("queue.ticket_ttl", "10m")
+
+	// Reaper defaults
+	viper.SetDefault("reaper.interval", "1m")
+	viper.SetDefault("reaper.max_game_duration", "2h")
+	viper.SetDefault("reaper.batch_size", 50)
+	viper.SetDefault("reaper.abandon_penalty", 0)
+
+	// Party defaults
+	viper.SetDefault("party.max_size", 5)
+
+	// Region defaults
+	viper.SetDefault("region.names", []string{})
+	viper.SetDefault("region.max_latency", "150ms")
+
+	// Allocator defaults
+	viper.SetDefault("allocator.type", "")
+	viper.SetDefault("allocator.timeout", "10s")
+	viper.SetDefault("allocator.agones_namespace", "default")
+
+	// Bot defaults
+	viper.SetDefault("bot.fill_after", "0s")
+	viper.SetDefault("bot.min_players", 1)
+	viper.SetDefault("bot.rating_weight", 0.5)
+
+	// Game mode defaults
+	viper.SetDefault("game.default_mode", "5v5")
+	viper.SetDefault("game.simulate_results", false)
+	viper.SetDefault("game.modes", map[string]interface{}{
+		"1v1":           map[string]interface{}{"min_players": 2, "max_players": 2, "teams": 2, "team_size": 1},
+		"5v5":           map[string]interface{}{"min_players": 2, "max_players": 10, "teams": 2, "team_size": 5},
+		"battle_royale": map[string]interface{}{"min_players": 2, "max_players": 50, "teams": 50, "team_size": 1},
+	})
+
 	// Bind environment variable override
 	if err := viper.BindEnv("server.host", "SERVICE_HOST"); err != nil {
 		return Config{}, fmt.Errorf("could not set environment variable 'server.host': %s", err)
@@ -75,6 +230,40 @@ func NewConfig() (Config, error) {
 		return Config{}, fmt.Errorf("could not set environment variable 'spanner.database_id': %s", err)
 	}
 
+	if err := viper.BindEnv("auth.enabled", "AUTH_ENABLED"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.enabled': %s", err)
+	}
+	if err := viper.BindEnv("auth.secret", "AUTH_SECRET"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.secret': %s", err)
+	}
+	if err := viper.BindEnv("auth.game_server_secret", "GAME_SERVER_SECRET"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.game_server_secret': %s", err)
+	}
+
+	if err := viper.BindEnv("game.simulate_results", "GAME_SIMULATE_RESULTS"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'game.simulate_results': %s", err)
+	}
+
+	if err := viper.BindEnv("queue.worker_interval", "QUEUE_WORKER_INTERVAL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'queue.worker_interval': %s", err)
+	}
+
+	if err := viper.BindEnv("queue.ticket_ttl", "QUEUE_TICKET_TTL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'queue.ticket_ttl': %s", err)
+	}
+
+	if err := viper.BindEnv("reaper.max_game_duration", "REAPER_MAX_GAME_DURATION"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'reaper.max_game_duration': %s", err)
+	}
+
+	if err := viper.BindEnv("allocator.type", "ALLOCATOR_TYPE"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'allocator.type': %s", err)
+	}
+
+	if err := viper.BindEnv("bot.fill_after", "BOT_FILL_AFTER"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'bot.fill_after': %s", err)
+	}
+
 	if err := viper.ReadInConfig(); err != nil {
 		fmt.Printf("[WARNING] could not read config %s\n", err.Error())
 	}
@@ -98,6 +287,32 @@ func (c *SpannerConfig) DB() string {
 	)
 }
 
+// Mode returns the configuration for a game mode, or for the default mode when name is empty.
+// Returns false if the mode isn't configured.
+func (c *GameConfig) Mode(name string) (string, GameModeConfig, bool) {
+	if name == "" {
+		name = c.Default_mode
+	}
+
+	mode, ok := c.Modes[name]
+	return name, mode, ok
+}
+
+// Validate returns an error if the mode's player counts don't fit its teams
+func (m GameModeConfig) Validate() error {
+	if m.Teams < 1 || m.Team_size < 1 {
+		return errors.New("a game mode needs at least one team of one player")
+	}
+	if m.Min_players < 1 || m.Min_players > m.Max_players {
+		return errors.New("a game mode's min_players must be between 1 and max_players")
+	}
+	if m.Max_players > m.Teams*m.Team_size {
+		return errors.New("a game mode's max_players can't be more than teams * team_size")
+	}
+
+	return nil
+}
+
 // URL returns the formatted endpoint string in format 'host:port'
 func (c *ServerConfig) URL() string {
 	return fmt.Sprintf(
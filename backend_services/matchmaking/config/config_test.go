@@ -21,6 +21,7 @@ import (
 	"os"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -79,3 +80,91 @@ func TestEnvironmentVariables(t *testing.T) {
 
 	assert.Equal(t, "projects/test-project/instances/test-instance/databases/test-database", c.Spanner.DB())
 }
+
+func TestRatingDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 100.0, c.Rating.Window_initial)
+	assert.Equal(t, 5.0, c.Rating.Window_growth)
+	assert.Equal(t, 600.0, c.Rating.Window_max)
+}
+
+func TestQueueDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, time.Second, c.Queue.Worker_interval)
+	assert.Equal(t, 30*time.Second, c.Queue.Fill_timeout)
+	assert.Equal(t, 10*time.Minute, c.Queue.Ticket_ttl)
+}
+
+func TestGameModeDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	name, mode, ok := c.Game.Mode("")
+	assert.True(t, ok)
+	assert.Equal(t, "5v5", name)
+	assert.Equal(t, GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}, mode)
+
+	_, mode, ok = c.Game.Mode("battle_royale")
+	assert.True(t, ok)
+	assert.Equal(t, 50, mode.Max_players)
+
+	_, _, ok = c.Game.Mode("capture_the_flag")
+	assert.False(t, ok)
+
+	assert.False(t, c.Game.Simulate_results)
+}
+
+func TestGameModeValidate(t *testing.T) {
+	assert.Nil(t, GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}.Validate())
+	assert.NotNil(t, GameModeConfig{Min_players: 2, Max_players: 11, Teams: 2, Team_size: 5}.Validate())
+	assert.NotNil(t, GameModeConfig{Min_players: 3, Max_players: 2, Teams: 2, Team_size: 1}.Validate())
+	assert.NotNil(t, GameModeConfig{Min_players: 0, Max_players: 2, Teams: 2, Team_size: 1}.Validate())
+	assert.NotNil(t, GameModeConfig{Min_players: 1, Max_players: 1, Teams: 0, Team_size: 1}.Validate())
+}
+
+func TestReaperDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, time.Minute, c.Reaper.Interval)
+	assert.Equal(t, 2*time.Hour, c.Reaper.Max_game_duration)
+	assert.Equal(t, 50, c.Reaper.Batch_size)
+	assert.Equal(t, 0.0, c.Reaper.Abandon_penalty)
+}
+
+func TestPartyDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, 5, c.Party.Max_size)
+}
+
+func TestRegionDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Empty(t, c.Region.Names)
+	assert.Equal(t, 150*time.Millisecond, c.Region.Max_latency)
+}
+
+func TestAllocatorDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, "", c.Allocator.Type)
+	assert.Equal(t, 10*time.Second, c.Allocator.Timeout)
+	assert.Equal(t, "default", c.Allocator.Agones_namespace)
+}
+
+func TestBotDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, time.Duration(0), c.Bot.Fill_after)
+	assert.Equal(t, 1, c.Bot.Min_players)
+	assert.Equal(t, 0.5, c.Bot.Rating_weight)
+}
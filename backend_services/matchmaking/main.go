@@ -17,11 +17,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/allocator"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/auth"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/models"
 	"github.com/gin-gonic/gin"
@@ -49,13 +55,61 @@ func getSpannerConnection(c *gin.Context) (context.Context, spanner.Client) {
 		c.MustGet("spanner_client").(spanner.Client)
 }
 
+// setConfiguration is a mutator to make the service configuration available in gin
+func setConfiguration(c config.Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("configuration", c)
+		ctx.Next()
+	}
+}
+
+// getConfiguration is a helper function to retrieve the service configuration
+func getConfiguration(c *gin.Context) config.Config {
+	return c.MustGet("configuration").(config.Config)
+}
+
+// setAllocator is a mutator to make the game server allocator available in gin
+func setAllocator(a allocator.Allocator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("allocator", a)
+		ctx.Next()
+	}
+}
+
+// getAllocator is a helper function to retrieve the game server allocator, which is nil when allocation is turned off
+func getAllocator(c *gin.Context) allocator.Allocator {
+	a, _ := c.MustGet("allocator").(allocator.Allocator)
+	return a
+}
+
 // createGame responds to the POST /games/create endpoint
-// Creating a game assigns a list of players not currently playing a game
+// Creating a game assigns a list of players with similar ratings not currently playing a game.
+// The body can provide the game's mode, otherwise the default mode is used.
+// Requires the game server secret, since a game server is allocated for the game.
 func createGame(c *gin.Context) {
 	var game models.Game
+	var request struct {
+		Mode string `json:"mode"`
+	}
+
+	// The body is optional
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
 
 	ctx, client := getSpannerConnection(c)
-	err := game.CreateGame(ctx, client)
+	err := game.CreateGame(ctx, client, getConfiguration(c), request.Mode, getAllocator(c))
+	if errors.Is(err, models.ErrUnknownMode) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if errors.Is(err, models.ErrAllocationFailed) {
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
 	if err != nil {
 		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
 			fmt.Printf("could not abort: %s", err)
@@ -67,7 +121,9 @@ func createGame(c *gin.Context) {
 }
 
 // closeGame responds to the PUT /games/close endpoint
-// Closing a game selects a winner and updates the players' stats before setting the game's finish time.
+// Closing a game records the result reported by the game server, and updates the players' stats and ratings
+// before setting the game's finish time. Requires the game server secret.
+// When the service is configured to simulate results, a random winner is chosen instead.
 func closeGame(c *gin.Context) {
 	var game models.Game
 
@@ -79,7 +135,13 @@ func closeGame(c *gin.Context) {
 	}
 
 	ctx, client := getSpannerConnection(c)
-	if err := game.CloseGame(ctx, client); err != nil {
+	configuration := getConfiguration(c)
+	err := game.CloseGame(ctx, client, configuration.Bot, configuration.Game.Simulate_results)
+	if errors.Is(err, models.ErrInvalidResult) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
 		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
 			fmt.Printf("could not abort: %s", err)
 		}
@@ -104,6 +166,622 @@ func getOpenGame(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, game)
 }
 
+// getGame responds to the GET /games/:id endpoint
+// Requires an access token. Returns the game with its participants, their results, its timestamps and its winner.
+// The endpoint and name of the game's server are only returned to the players in the game.
+func getGame(c *gin.Context) {
+	ctx, client := getSpannerConnection(c)
+	game, err := models.GetGame(ctx, client, c.Param("id"))
+	if errors.Is(err, models.ErrGameNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	inGame := false
+	for _, p := range game.Players {
+		if auth.IsPlayer(c, p) {
+			inGame = true
+			break
+		}
+	}
+
+	if !inGame {
+		game.Endpoint = ""
+		game.Game_server = ""
+	}
+
+	c.IndentedJSON(http.StatusOK, game)
+}
+
+// respondGameError is a private helper that responds with the status for errors from changing a game's players
+func respondGameError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrGameNotFound), errors.Is(err, models.ErrNotParticipant):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrGameFinished), errors.Is(err, models.ErrAlreadyLeft):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrUnknownMode):
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	default:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+	}
+}
+
+// leaveGame responds to the POST /games/:id/leave endpoint
+// Issued by the game's server to record that the player left the running game, and frees them to be matched again.
+// Requires the game server secret.
+func leaveGame(c *gin.Context) {
+	var request struct {
+		PlayerUUID string `json:"playerUUID" binding:"required"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	if err := models.LeaveGame(ctx, client, c.Param("id"), request.PlayerUUID); err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"gameUUID": c.Param("id"), "playerUUID": request.PlayerUUID})
+}
+
+// backfillGame responds to the POST /games/:id/backfill endpoint
+// Issued by the game's server to fill the slots of players that left with queued or idle players.
+// Returns the new participants, which is empty if no compatible players were found. Requires the game server secret.
+func backfillGame(c *gin.Context) {
+	ctx, client := getSpannerConnection(c)
+	participants, err := models.BackfillGame(ctx, client, getConfiguration(c), c.Param("id"))
+	if err != nil {
+		respondGameError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, participants)
+}
+
+// getPlayerGames responds to the GET /players/:id/games endpoint
+// Requires an access token. Returns a page of the player's finished games, newest first by when the player
+// joined them. The page_token from a response continues with the next page, and page_size sets how many
+// games are returned.
+func getPlayerGames(c *gin.Context) {
+	pageSize := models.DefaultPageSize
+	if size := c.Query("page_size"); size != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(size); err != nil || pageSize < 1 || pageSize > models.MaxPageSize {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("page_size must be between 1 and %d", models.MaxPageSize)})
+			return
+		}
+	}
+
+	ctx, client := getSpannerConnection(c)
+	page, err := models.GetPlayerGames(ctx, client, c.Param("id"), c.Query("page_token"), pageSize)
+	if errors.Is(err, models.ErrInvalidPageToken) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, page)
+}
+
+// queuePlayer responds to the POST /queue endpoint
+// Requires an access token issued to the player being queued. A party is queued by its leader.
+// The body holds the optional mode, and the player's pings to each region in milliseconds.
+// Returns the new ticket, which the matchmaking worker matches into a game.
+func queuePlayer(c *gin.Context) {
+	var request struct {
+		PlayerUUID string           `json:"playerUUID" binding:"required"`
+		Mode       string           `json:"mode"`
+		Pings      map[string]int64 `json:"pings"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	ticket, err := models.Enqueue(ctx, client, getConfiguration(c), request.PlayerUUID, request.Mode, request.Pings)
+	switch {
+	case errors.Is(err, models.ErrUnknownMode), errors.Is(err, models.ErrPartyTooLarge), errors.Is(err, models.ErrNoRegion):
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrPlayerNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrNotPartyLeader):
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrPlayerInGame), errors.Is(err, models.ErrAlreadyQueued):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, ticket)
+}
+
+// getQueueTicket responds to the GET /queue/:player endpoint
+// Requires an access token issued to the same player.
+// Returns the player's most recent ticket. Once it is matched, the ticket holds the player's gameUUID.
+func getQueueTicket(c *gin.Context) {
+	var playerUUID = c.Param("player")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	ticket, err := models.GetTicket(ctx, client, playerUUID)
+	if errors.Is(err, models.ErrTicketNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, ticket)
+}
+
+// cancelQueueTicket responds to the DELETE /queue/:player endpoint
+// Requires an access token issued to the same player. Cancels the player's queued ticket.
+func cancelQueueTicket(c *gin.Context) {
+	var playerUUID = c.Param("player")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.CancelTicket(ctx, client, playerUUID)
+	if errors.Is(err, models.ErrTicketNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "ticket cancelled"})
+}
+
+// respondPartyError is a helper that responds with the status matching a party error
+func respondPartyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrPartyNotFound), errors.Is(err, models.ErrPlayerNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrNotPartyLeader), errors.Is(err, models.ErrNotInvited):
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrAlreadyInParty), errors.Is(err, models.ErrNotInParty), errors.Is(err, models.ErrPartyFull),
+		errors.Is(err, models.ErrPartyQueued), errors.Is(err, models.ErrAlreadyQueued):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+	default:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+	}
+}
+
+// partyRequest is the body of the party endpoints, holding the player acting on the party
+type partyRequest struct {
+	PlayerUUID string `json:"playerUUID" binding:"required"`
+}
+
+// createParty responds to the POST /parties endpoint
+// Requires an access token issued to the player, who leads the new party.
+// Returns the new party.
+func createParty(c *gin.Context) {
+	var request partyRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	party, err := models.CreateParty(ctx, client, request.PlayerUUID)
+	if err != nil {
+		respondPartyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, party)
+}
+
+// getParty responds to the GET /parties/:id endpoint
+// Returns the party's leader, members and pending invites.
+func getParty(c *gin.Context) {
+	var partyUUID = c.Param("id")
+
+	ctx, client := getSpannerConnection(c)
+	party, err := models.GetParty(ctx, client, partyUUID)
+	if err != nil {
+		respondPartyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, party)
+}
+
+// inviteToParty responds to the POST /parties/:id/invite endpoint
+// Requires an access token issued to the party's leader, who invites the player in the invitee field.
+func inviteToParty(c *gin.Context) {
+	var request struct {
+		partyRequest
+		Invitee string `json:"invitee" binding:"required"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.InviteToParty(ctx, client, getConfiguration(c).Party, c.Param("id"), request.PlayerUUID, request.Invitee)
+	if err != nil {
+		respondPartyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "player invited"})
+}
+
+// joinParty responds to the POST /parties/:id/join endpoint
+// Requires an access token issued to the invited player.
+func joinParty(c *gin.Context) {
+	var request partyRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.JoinParty(ctx, client, getConfiguration(c).Party, c.Param("id"), request.PlayerUUID)
+	if err != nil {
+		respondPartyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "joined party"})
+}
+
+// leaveParty responds to the POST /parties/:id/leave endpoint
+// Requires an access token issued to the leaving player.
+func leaveParty(c *gin.Context) {
+	var request partyRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.LeaveParty(ctx, client, c.Param("id"), request.PlayerUUID)
+	if err != nil {
+		respondPartyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "left party"})
+}
+
+// disbandParty responds to the POST /parties/:id/disband endpoint
+// Requires an access token issued to the party's leader.
+func disbandParty(c *gin.Context) {
+	var request partyRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.DisbandParty(ctx, client, c.Param("id"), request.PlayerUUID)
+	if err != nil {
+		respondPartyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "party disbanded"})
+}
+
+// respondLobbyError is a helper that responds with the status matching a lobby error
+func respondLobbyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrLobbyNotFound), errors.Is(err, models.ErrPlayerNotFound), errors.Is(err, models.ErrNotLobbyMember):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrNotLobbyHost), errors.Is(err, models.ErrKickedFromLobby):
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrLobbyStarted), errors.Is(err, models.ErrLobbyFull), errors.Is(err, models.ErrPlayerInGame),
+		errors.Is(err, models.ErrAlreadyQueued), errors.Is(err, models.ErrAlreadyInParty):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrUnknownMode), errors.Is(err, models.ErrNotEnoughPlayers), errors.Is(err, models.ErrKickHost):
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	case errors.Is(err, models.ErrAllocationFailed):
+		c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+	default:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+	}
+}
+
+// createLobby responds to the POST /lobbies endpoint
+// Requires an access token issued to the player, who hosts the lobby. The mode is optional.
+// Returns the new lobby, with the invite code other players join it with.
+func createLobby(c *gin.Context) {
+	var request struct {
+		PlayerUUID string `json:"playerUUID" binding:"required"`
+		Mode       string `json:"mode"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	lobby, err := models.CreateLobby(ctx, client, getConfiguration(c).Game, request.PlayerUUID, request.Mode)
+	if err != nil {
+		respondLobbyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, lobby)
+}
+
+// getLobby responds to the GET /lobbies/:code endpoint
+// Returns the lobby and its players. Once it is started, the lobby holds the gameUUID.
+func getLobby(c *gin.Context) {
+	var code = c.Param("code")
+
+	ctx, client := getSpannerConnection(c)
+	lobby, err := models.GetLobby(ctx, client, code)
+	if err != nil {
+		respondLobbyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, lobby)
+}
+
+// joinLobby responds to the POST /lobbies/:code/join endpoint
+// Requires an access token issued to the joining player, who can't be in a game, queued or in a party.
+// Returns the lobby.
+func joinLobby(c *gin.Context) {
+	var request struct {
+		PlayerUUID string `json:"playerUUID" binding:"required"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	lobby, err := models.JoinLobby(ctx, client, getConfiguration(c).Game, c.Param("code"), request.PlayerUUID)
+	if err != nil {
+		respondLobbyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, lobby)
+}
+
+// kickFromLobby responds to the POST /lobbies/:code/kick endpoint
+// Requires an access token issued to the lobby's host, who kicks the player in the target field.
+func kickFromLobby(c *gin.Context) {
+	var request struct {
+		PlayerUUID string `json:"playerUUID" binding:"required"`
+		Target     string `json:"target" binding:"required"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.KickFromLobby(ctx, client, c.Param("code"), request.PlayerUUID, request.Target)
+	if err != nil {
+		respondLobbyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "player kicked"})
+}
+
+// startLobby responds to the POST /lobbies/:code/start endpoint
+// Requires an access token issued to the lobby's host.
+// Returns the game the lobby was turned into.
+func startLobby(c *gin.Context) {
+	var request struct {
+		PlayerUUID string `json:"playerUUID" binding:"required"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if !auth.Authorize(c, request.PlayerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	game, err := models.StartLobby(ctx, client, getConfiguration(c).Game, c.Param("code"), request.PlayerUUID, getAllocator(c))
+	if err != nil {
+		respondLobbyError(c, err)
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, game)
+}
+
+// runMatchmaker forms games from queued tickets, every worker interval until ctx is done.
+// Every replica runs its own worker. Each game is claimed in its own transaction, so workers never match
+// the same ticket twice. Game servers for the formed games are allocated with a.
+func runMatchmaker(ctx context.Context, c config.Config, a allocator.Allocator) {
+	if c.Queue.Worker_interval <= 0 {
+		fmt.Println("matchmaking worker is disabled")
+		return
+	}
+
+	client, err := spanner.NewClient(ctx, c.Spanner.DB())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	ticker := time.NewTicker(c.Queue.Worker_interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := models.MatchTickets(ctx, *client, c, a)
+			if err != nil {
+				fmt.Printf("could not match tickets: %s\n", err)
+			}
+
+			if report.Formed > 0 {
+				fmt.Printf("formed %d games from the queue\n", report.Formed)
+			}
+
+			if report.Cancelled > 0 {
+				fmt.Printf("cancelled %d tickets for unknown modes\n", report.Cancelled)
+			}
+
+			if report.Expired > 0 {
+				fmt.Printf("expired %d tickets\n", report.Expired)
+			}
+		}
+	}
+}
+
+// runReaper abandons games that were never closed, every reaper interval until ctx is done.
+// Every replica runs its own reaper. Games are abandoned in transactions, so a game is only abandoned once.
+func runReaper(ctx context.Context, c config.Config) {
+	if c.Reaper.Max_game_duration <= 0 || c.Reaper.Interval <= 0 || c.Reaper.Batch_size <= 0 {
+		fmt.Println("game reaper is disabled")
+		return
+	}
+
+	client, err := spanner.NewClient(ctx, c.Spanner.DB())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	ticker := time.NewTicker(c.Reaper.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := models.AbandonStaleGames(ctx, *client, c.Reaper)
+			if err != nil {
+				fmt.Printf("could not abandon stale games: %s\n", err)
+			}
+
+			if count > 0 {
+				fmt.Printf("abandoned %d stale games\n", count)
+			}
+		}
+	}
+}
+
 // main initializes the gin router and configures the endpoints
 func main() {
 	configuration, _ := config.NewConfig()
@@ -115,11 +793,43 @@ func main() {
 		return
 	}
 
+	gameServers, err := allocator.New(configuration.Allocator)
+	if err != nil {
+		fmt.Printf("could not create game server allocator: %s", err)
+		return
+	}
+
 	router.Use(setSpannerConnection(configuration))
+	router.Use(setConfiguration(configuration))
+	router.Use(setAllocator(gameServers))
 
+	requireGameServer := auth.RequireGameServer(configuration.Auth)
+	requireToken := auth.RequireToken(configuration.Auth)
 	router.GET("/games/open", getOpenGame)
-	router.POST("/games/create", createGame)
-	router.PUT("/games/close", closeGame)
+	router.POST("/games/create", requireGameServer, createGame)
+	router.PUT("/games/close", requireGameServer, closeGame)
+	router.GET("/games/:id", requireToken, getGame)
+	router.POST("/games/:id/leave", requireGameServer, leaveGame)
+	router.POST("/games/:id/backfill", requireGameServer, backfillGame)
+	router.GET("/players/:id/games", requireToken, getPlayerGames)
+
+	router.POST("/queue", requireToken, queuePlayer)
+	router.GET("/queue/:player", requireToken, getQueueTicket)
+	router.DELETE("/queue/:player", requireToken, cancelQueueTicket)
+	router.POST("/parties", requireToken, createParty)
+	router.GET("/parties/:id", getParty)
+	router.POST("/parties/:id/invite", requireToken, inviteToParty)
+	router.POST("/parties/:id/join", requireToken, joinParty)
+	router.POST("/parties/:id/leave", requireToken, leaveParty)
+	router.POST("/parties/:id/disband", requireToken, disbandParty)
+	router.POST("/lobbies", requireToken, createLobby)
+	router.GET("/lobbies/:code", getLobby)
+	router.POST("/lobbies/:code/join", requireToken, joinLobby)
+	router.POST("/lobbies/:code/kick", requireToken, kickFromLobby)
+	router.POST("/lobbies/:code/start", requireToken, startLobby)
+
+	go runMatchmaker(context.Background(), configuration, gameServers)
+	go runReaper(context.Background(), configuration)
 
 	if err := router.Run(configuration.Server.URL()); err != nil {
 		fmt.Printf("could not run gin router: %s", err)
@@ -28,16 +28,19 @@ import (
 	"embed"
 	"fmt"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/models"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	databasepb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
 	instancepb "google.golang.org/genproto/googleapis/spanner/admin/instance/v1"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed test_data/schema.sql
@@ -45,6 +48,9 @@ var SCHEMAFILE embed.FS
 
 var TESTNETWORK = "game-sample-test"
 
+var TESTSECRET = "integration-test-secret"
+var TESTGAMESERVERSECRET = "integration-test-game-server-secret"
+
 // These integration tests run against the Spanner emulator. The emulator
 // must be running and accessible prior to integration tests running.
 
@@ -249,6 +255,10 @@ func setupService(ctx context.Context, ec *Emulator) (*Service, error) {
 			"SERVICE_HOST":          "0.0.0.0",
 			"SERVICE_PORT":          "80",
 			"SPANNER_EMULATOR_HOST": ec.Endpoint,
+			"AUTH_SECRET":           TESTSECRET,
+			"GAME_SERVER_SECRET":    TESTGAMESERVERSECRET,
+			"QUEUE_WORKER_INTERVAL": "1s",
+			"ALLOCATOR_TYPE":        "fake",
 		},
 		WaitingFor: wait.ForLog("Listening and serving HTTP on 0.0.0.0:80"),
 	}
@@ -305,12 +315,35 @@ func TestMain(m *testing.M) {
 }
 
 func TestCreateGames(t *testing.T) {
+	// Creating games allocates game servers, so it requires the game server secret
 	response, err := http.Post("http://localhost/games/create", "application/json", bytes.NewBuffer([]byte{}))
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, "http://localhost/games/create", bytes.NewBuffer([]byte{}), testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, "http://localhost/games/create", bytes.NewBuffer([]byte{}), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
 	assert.Equal(t, 201, response.StatusCode)
+
+	// Unknown modes are rejected
+	response, err = httpRequest(http.MethodPost, "http://localhost/games/create", bytes.NewBuffer([]byte(`{"mode": "capture_the_flag"}`)), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, 400, response.StatusCode)
 }
 
 func TestModifyGames(t *testing.T) {
@@ -331,19 +364,581 @@ func TestModifyGames(t *testing.T) {
 
 	// Test close game
 	if gameData.GameUUID != "" {
+		// Only game servers close games
 		gameJson, _ := json.Marshal(gameData)
+		response, err := httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(gameJson), "")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 401, response.StatusCode)
 
-		client := &http.Client{}
-		req, err := http.NewRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(gameJson))
+		response, err = httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(gameJson), testToken(t, "1"))
 		if err != nil {
 			t.Fatal(err.Error())
 		}
-		// set the request header Content-Type for json
-		req.Header.Set("Content-Type", "application/json")
-		response, err := client.Do(req)
+		assert.Equal(t, 401, response.StatusCode)
+
+		// Closing without a result is rejected
+		response, err = httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(gameJson), TESTGAMESERVERSECRET)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 400, response.StatusCode)
+
+		// A winner who didn't play is rejected
+		gameJson, _ = json.Marshal(map[string]interface{}{"gameUUID": gameData.GameUUID, "winner": "not-a-player"})
+		response, err = httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(gameJson), TESTGAMESERVERSECRET)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 400, response.StatusCode)
+
+		// Any player of the game can win
+		response, err = httpRequest(http.MethodGet, "http://localhost/games/"+gameData.GameUUID, nil, testToken(t, "1"))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		body, err = ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		json.Unmarshal(body, &gameData)
+		if !assert.NotEmpty(t, gameData.Players) {
+			return
+		}
+
+		gameJson, _ = json.Marshal(map[string]interface{}{"gameUUID": gameData.GameUUID, "winner": gameData.Players[0]})
+		response, err = httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(gameJson), TESTGAMESERVERSECRET)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
 		assert.Equal(t, 200, response.StatusCode)
 	}
 }
+
+func TestGameHistory(t *testing.T) {
+	// Reading match history requires an access token
+	response, err := http.Get("http://localhost/players/1/games")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	// The game closed by TestModifyGames is the only finished game
+	response, err = httpRequest(http.MethodGet, "http://localhost/players/1/games?page_size=1", nil, testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var page struct {
+		Games []models.PlayerGame `json:"games"`
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	json.Unmarshal(body, &page)
+	if !assert.Len(t, page.Games, 1) {
+		return
+	}
+
+	// Get the game with its roster, which also requires an access token
+	response, err = http.Get("http://localhost/games/" + page.Games[0].GameUUID)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodGet, "http://localhost/games/"+page.Games[0].GameUUID, nil, testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var game models.Game
+	body, _ = ioutil.ReadAll(response.Body)
+	json.Unmarshal(body, &game)
+	assert.Equal(t, models.GameFinished, game.Status)
+	assert.NotEmpty(t, game.Winner)
+	assert.Contains(t, game.Players, "1")
+	assert.True(t, game.Finished.Valid)
+
+	response, err = httpRequest(http.MethodGet, "http://localhost/games/00000000-0000-0000-0000-000000000000", nil, testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+
+	response, err = httpRequest(http.MethodGet, "http://localhost/players/1/games?page_token=invalid", nil, testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 400, response.StatusCode)
+}
+
+func httpRequest(method string, url string, data io.Reader, token string) (*http.Response, error) {
+	client := &http.Client{}
+	req, err := http.NewRequest(method, url, data)
+	if err != nil {
+		return nil, err
+	}
+	// set the request header Content-Type for json
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return client.Do(req)
+}
+
+// testToken signs an access token for the player the same way the profile service does
+func testToken(t *testing.T, playerUUID string) string {
+	claims := jwt.RegisteredClaims{
+		Issuer:    "profile-service",
+		Subject:   playerUUID,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(TESTSECRET))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return token
+}
+
+func TestQueue(t *testing.T) {
+	// Games created by the earlier tests are closed, so every player is idle
+	for _, playerUUID := range []string{"1", "2"} {
+		body, _ := json.Marshal(map[string]string{"playerUUID": playerUUID})
+		response, err := httpRequest(http.MethodPost, "http://localhost/queue", bytes.NewBuffer(body), testToken(t, playerUUID))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 201, response.StatusCode)
+	}
+
+	// Queueing twice is a conflict
+	body, _ := json.Marshal(map[string]string{"playerUUID": "1"})
+	response, err := httpRequest(http.MethodPost, "http://localhost/queue", bytes.NewBuffer(body), testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	// Queueing another player is forbidden
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3"})
+	response, err = httpRequest(http.MethodPost, "http://localhost/queue", bytes.NewBuffer(body), testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	// Player 2 leaves the queue, and can't leave twice
+	response, err = httpRequest(http.MethodDelete, "http://localhost/queue/2", nil, testToken(t, "2"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = httpRequest(http.MethodDelete, "http://localhost/queue/2", nil, testToken(t, "2"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+
+	// Player 1 is alone in the queue, so stays queued
+	response, err = httpRequest(http.MethodGet, "http://localhost/queue/1", nil, testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var ticket models.Ticket
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &ticket)
+	assert.Equal(t, models.TicketQueued, ticket.Status)
+	assert.False(t, ticket.GameUUID.Valid)
+}
+
+func TestParties(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"playerUUID": "3"})
+	response, err := httpRequest(http.MethodPost, "http://localhost/parties", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var party models.Party
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &party)
+	assert.Equal(t, "3", party.LeaderUUID)
+	partyURL := "http://localhost/parties/" + party.PartyUUID
+
+	// Player 4 can't join before being invited
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, partyURL+"/join", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3", "invitee": "4"})
+	response, err = httpRequest(http.MethodPost, partyURL+"/invite", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, partyURL+"/join", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// Only the leader invites players and queues the party
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4", "invitee": "5"})
+	response, err = httpRequest(http.MethodPost, partyURL+"/invite", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, "http://localhost/queue", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3", "mode": "5v5"})
+	response, err = httpRequest(http.MethodPost, "http://localhost/queue", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	// The party can't change while it is queued, and cancelling any member's ticket cancels the party's
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, partyURL+"/leave", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	response, err = httpRequest(http.MethodDelete, "http://localhost/queue/4", nil, testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = httpRequest(http.MethodDelete, "http://localhost/queue/3", nil, testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+
+	response, err = httpRequest(http.MethodGet, partyURL, nil, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &party)
+	assert.ElementsMatch(t, []string{"3", "4"}, party.Members)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3"})
+	response, err = httpRequest(http.MethodPost, partyURL+"/disband", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = httpRequest(http.MethodGet, partyURL, nil, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
+func TestLobbies(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"playerUUID": "3", "mode": "1v1"})
+	response, err := httpRequest(http.MethodPost, "http://localhost/lobbies", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var lobby models.Lobby
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &lobby)
+	assert.NotEmpty(t, lobby.Code)
+	lobbyURL := "http://localhost/lobbies/" + lobby.Code
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// A 1v1 lobby holds two players
+	body, _ = json.Marshal(map[string]string{"playerUUID": "5"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "5"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	// Kicked players can't join again, which makes room for another player
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3", "target": "4"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/kick", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "4"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	// Player 1 is still queued from TestQueue, so can't join
+	body, _ = json.Marshal(map[string]string{"playerUUID": "1"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	// Neither can players in a party
+	body, _ = json.Marshal(map[string]string{"playerUUID": "2"})
+	response, err = httpRequest(http.MethodPost, "http://localhost/parties", bytes.NewBuffer(body), testToken(t, "2"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var party models.Party
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &party)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "2"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "2"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, "http://localhost/parties/"+party.PartyUUID+"/disband", bytes.NewBuffer(body), testToken(t, "2"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "5"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "5"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// Only the host starts the lobby, and only once
+	body, _ = json.Marshal(map[string]string{"playerUUID": "5"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/start", bytes.NewBuffer(body), testToken(t, "5"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/start", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var game models.Game
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &game)
+	assert.ElementsMatch(t, []string{"3", "5"}, game.Players)
+	assert.NotEqual(t, game.Teams[0], game.Teams[1])
+
+	// The game server allocated for the game is stored with it, and only shown to the game's players
+	assert.NotEmpty(t, game.Endpoint)
+	response, err = httpRequest(http.MethodGet, "http://localhost/games/"+game.GameUUID, nil, testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var stored models.Game
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &stored)
+	assert.Equal(t, game.Endpoint, stored.Endpoint)
+
+	response, err = httpRequest(http.MethodGet, "http://localhost/games/"+game.GameUUID, nil, testToken(t, "1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var spectated models.Game
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &spectated)
+	assert.Empty(t, spectated.Endpoint)
+	assert.Empty(t, spectated.Game_server)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/start", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	// The lobby's game is closed like any other game
+	body, _ = json.Marshal(map[string]interface{}{"gameUUID": game.GameUUID, "winner": "5"})
+	response, err = httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(body), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+}
+
+func TestBackfill(t *testing.T) {
+	body, _ := json.Marshal(map[string]string{"playerUUID": "3", "mode": "1v1"})
+	response, err := httpRequest(http.MethodPost, "http://localhost/lobbies", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var lobby models.Lobby
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &lobby)
+	lobbyURL := "http://localhost/lobbies/" + lobby.Code
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "5"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/join", bytes.NewBuffer(body), testToken(t, "5"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "3"})
+	response, err = httpRequest(http.MethodPost, lobbyURL+"/start", bytes.NewBuffer(body), testToken(t, "3"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var game models.Game
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &game)
+	gameURL := "http://localhost/games/" + game.GameUUID
+
+	// Only game servers record players leaving and backfill games
+	body, _ = json.Marshal(map[string]string{"playerUUID": "5"})
+	response, err = httpRequest(http.MethodPost, gameURL+"/leave", bytes.NewBuffer(body), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, gameURL+"/leave", bytes.NewBuffer(body), testToken(t, "5"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, gameURL+"/backfill", nil, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	// Players leave a running game once, and only games they play in
+	body, _ = json.Marshal(map[string]string{"playerUUID": "5"})
+	response, err = httpRequest(http.MethodPost, gameURL+"/leave", bytes.NewBuffer(body), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, gameURL+"/leave", bytes.NewBuffer(body), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+
+	body, _ = json.Marshal(map[string]string{"playerUUID": "4"})
+	response, err = httpRequest(http.MethodPost, gameURL+"/leave", bytes.NewBuffer(body), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+
+	// The open slot is filled by someone other than the players already in the game
+	response, err = httpRequest(http.MethodPost, gameURL+"/backfill", nil, TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var added []models.Participant
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &added)
+	assert.LessOrEqual(t, len(added), 1)
+	for _, p := range added {
+		assert.NotContains(t, []string{"3", "5"}, p.PlayerUUID)
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{"gameUUID": game.GameUUID, "winner": "3"})
+	response, err = httpRequest(http.MethodPut, "http://localhost/games/close", bytes.NewBuffer(body), TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// Finished games can't be backfilled
+	response, err = httpRequest(http.MethodPost, gameURL+"/backfill", nil, TESTGAMESERVERSECRET)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 409, response.StatusCode)
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/allocator"
+)
+
+// ErrAllocationFailed is returned when no game server could be allocated for a new game
+var ErrAllocationFailed = errors.New("could not allocate a game server")
+
+// allocateServer is a private helper to allocate a game server once the game is committed, and store where its players connect.
+// If the allocation fails, or its endpoint can't be stored, the game is marked failed and its players are released.
+// A game server that was allocated is released too. Nothing is allocated when a is nil.
+func (g *Game) allocateServer(ctx context.Context, client spanner.Client, a allocator.Allocator) error {
+	if a == nil {
+		return nil
+	}
+
+	endpoint, err := a.Allocate(ctx, allocator.Request{GameUUID: g.GameUUID, Mode: g.Mode, Region: g.Region, Players: g.Players})
+	if err != nil {
+		return g.failAllocation(ctx, client, err)
+	}
+
+	_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		cols := []string{"gameUUID", "endpoint", "game_server"}
+		err := txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("games", cols, []interface{}{g.GameUUID, endpoint.String(), endpoint.Server}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=store_endpoint"})
+
+	if err != nil {
+		err = fmt.Errorf("could not store the endpoint: %s", err)
+		if releaseErr := a.Release(ctx, endpoint); releaseErr != nil {
+			err = fmt.Errorf("%s, and could not release game server '%s': %s", err, endpoint.Server, releaseErr)
+		}
+
+		return g.failAllocation(ctx, client, err)
+	}
+
+	g.Endpoint = endpoint.String()
+	g.Game_server = endpoint.Server
+
+	return nil
+}
+
+// failAllocation is a private helper that releases a game left without a game server, and returns
+// ErrAllocationFailed wrapping the reason.
+func (g *Game) failAllocation(ctx context.Context, client spanner.Client, err error) error {
+	if releaseErr := g.releaseFailedGame(ctx, client); releaseErr != nil {
+		return fmt.Errorf("%w: %s, and could not release the game: %s", ErrAllocationFailed, err, releaseErr)
+	}
+
+	g.Status = GameFailed
+	return fmt.Errorf("%w: %s", ErrAllocationFailed, err)
+}
+
+// releaseFailedGame is a private helper that marks a game without a game server as failed, and releases its players.
+// The tickets that were matched into the game are queued again, and a lobby started into it can be started again.
+// Failed games don't count towards the players' stats or ratings.
+func (g Game) releaseFailedGame(ctx context.Context, client spanner.Client) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRowWithOptions(ctx, "games", spanner.Key{g.GameUUID}, []string{"finished"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetFailedGame"})
+		if err != nil {
+			return err
+		}
+
+		var finished spanner.NullTime
+		if err := row.Column(0, &finished); err != nil {
+			return err
+		}
+
+		// The game was already closed or abandoned
+		if finished.Valid {
+			return nil
+		}
+
+		participants, err := g.getParticipants(ctx, txn)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		m := []*spanner.Mutation{
+			spanner.Update("games", []string{"gameUUID", "finished", "status"}, []interface{}{g.GameUUID, now, GameFailed}),
+		}
+
+		var playerUUIDs []string
+		for _, p := range participants {
+			playerUUIDs = append(playerUUIDs, p.PlayerUUID)
+
+			if !p.Leave_time.Valid {
+				cols := []string{"gameUUID", "playerUUID", "leave_time"}
+				m = append(m, spanner.Update("game_participants", cols, []interface{}{g.GameUUID, p.PlayerUUID, now}))
+			}
+		}
+
+		// Only players still locked into this game are released
+		stmt := spanner.Statement{
+			SQL: `SELECT playerUUID FROM players WHERE playerUUID IN UNNEST(@players) AND current_game=@game`,
+			Params: map[string]interface{}{
+				"players": playerUUIDs,
+				"game":    g.GameUUID,
+			},
+		}
+		iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetFailedGamePlayers"})
+		rows, err := readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			var playerUUID string
+			if err := row.Column(0, &playerUUID); err != nil {
+				return err
+			}
+
+			m = append(m, spanner.Update("players", []string{"playerUUID", "current_game"}, []interface{}{playerUUID, spanner.NullString{}}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		stmt = spanner.Statement{
+			SQL: `UPDATE matchmaking_tickets SET status=@queued, gameUUID=NULL, updated=CURRENT_TIMESTAMP()
+					WHERE playerUUID IN UNNEST(@players) AND gameUUID=@game AND status=@matched`,
+			Params: map[string]interface{}{
+				"players": playerUUIDs,
+				"game":    g.GameUUID,
+				"queued":  TicketQueued,
+				"matched": TicketMatched,
+			},
+		}
+		if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=RequeueFailedGame"}); err != nil {
+			return err
+		}
+
+		stmt = spanner.Statement{
+			SQL: `UPDATE lobbies SET gameUUID=NULL, started=NULL WHERE gameUUID=@game`,
+			Params: map[string]interface{}{
+				"game": g.GameUUID,
+			},
+		}
+		if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=ReopenFailedLobby"}); err != nil {
+			return err
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=release_failed_game"})
+
+	return err
+}
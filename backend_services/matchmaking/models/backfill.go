@@ -0,0 +1,412 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/rating"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrGameFinished is returned when changing the players of a game that already ended
+	ErrGameFinished = errors.New("game is already finished")
+
+	// ErrNotParticipant is returned when a player that didn't play in the game leaves it
+	ErrNotParticipant = errors.New("player is not in the game")
+
+	// ErrAlreadyLeft is returned when a player leaves a game twice
+	ErrAlreadyLeft = errors.New("player already left the game")
+)
+
+// planBackfill is a private helper that picks units, in order, for the open slots of a running game whose teams
+// already hold counts players with totals of their ratings. Units are skipped when they don't fit into the open
+// slots or into a team. Returns the picked players and their teams.
+func planBackfill(units []unit, counts []int, totals []float64, open int, mode config.GameModeConfig) ([]Player, []int64) {
+	var picked []unit
+	var unitTeams []int64
+	count := 0
+
+	for _, u := range units {
+		if count+len(u.players) > open {
+			continue
+		}
+
+		teams, ok := fillTeams(append(picked[:len(picked):len(picked)], u), mode, counts, totals)
+		if !ok {
+			continue
+		}
+
+		picked = append(picked, u)
+		unitTeams = teams
+		count += len(u.players)
+	}
+
+	var players []Player
+	var teams []int64
+	for i, u := range picked {
+		for _, p := range u.players {
+			players = append(players, p)
+			teams = append(teams, unitTeams[i])
+		}
+	}
+
+	return players, teams
+}
+
+// getRunningGame is a private helper that reads the game's mode, region and creation time, making sure it hasn't ended.
+// Returns ErrGameNotFound or ErrGameFinished.
+func (g *Game) getRunningGame(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+	row, err := txn.ReadRowWithOptions(ctx, "games", spanner.Key{g.GameUUID}, []string{"finished", "mode", "region", "created"},
+		&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetRunningGame"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return ErrGameNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var mode, region spanner.NullString
+	var created spanner.NullTime
+	if err := row.Columns(&g.Finished, &mode, &region, &created); err != nil {
+		return err
+	}
+
+	if g.Finished.Valid {
+		return ErrGameFinished
+	}
+
+	g.Mode = mode.StringVal
+	g.Region = region.StringVal
+	g.Created = created.Time
+
+	return nil
+}
+
+// LeaveGame records that the player left the running game, and releases them so they can be matched again.
+// The player still counts towards the game's stats and ratings when it is closed.
+// Returns ErrGameNotFound, ErrGameFinished, ErrNotParticipant or ErrAlreadyLeft if the departure can't be recorded.
+func LeaveGame(ctx context.Context, client spanner.Client, gameUUID string, playerUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		g := Game{GameUUID: gameUUID}
+		if err := g.getRunningGame(ctx, txn); err != nil {
+			return err
+		}
+
+		row, err := txn.ReadRowWithOptions(ctx, "game_participants", spanner.Key{gameUUID, playerUUID}, []string{"leave_time", "participant_type"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetLeavingParticipant"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrNotParticipant
+		}
+		if err != nil {
+			return err
+		}
+
+		var leaveTime spanner.NullTime
+		var participantType string
+		if err := row.Columns(&leaveTime, &participantType); err != nil {
+			return err
+		}
+
+		if leaveTime.Valid {
+			return ErrAlreadyLeft
+		}
+
+		now := time.Now()
+		m := []*spanner.Mutation{
+			spanner.Update("game_participants", []string{"gameUUID", "playerUUID", "leave_time"}, []interface{}{gameUUID, playerUUID, now}),
+		}
+
+		// A game server removing a bot opens its slot for backfill, but there is no player to release
+		if participantType == ParticipantBot {
+			if err := txn.BufferWrite(m); err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+			return nil
+		}
+
+		row, err = txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"current_game"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetLeavingPlayer"})
+		if err != nil {
+			return err
+		}
+
+		var currentGame spanner.NullString
+		if err := row.Column(0, &currentGame); err != nil {
+			return err
+		}
+
+		if currentGame.StringVal == gameUUID {
+			cols := []string{"playerUUID", "current_game", "idle_since"}
+			m = append(m, spanner.Update("players", cols, []interface{}{playerUUID, spanner.NullString{}, now}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=leave_game"})
+
+	return err
+}
+
+// BackfillGame fills the open slots of a running game, left by departed players, and returns the new participants.
+// Queued tickets for the game's mode are preferred, then idle players, closest to the rating of the players still in
+// the game first, within the rating window for how long the game has run. Games with a region only take queued tickets
+// for that region, since idle players have no pings. Parties are only backfilled from the queue, together on one team.
+// The new players are locked into the game, and their queued tickets are matched to it.
+// Returns ErrGameNotFound, ErrGameFinished or ErrUnknownMode if the game can't be backfilled.
+func BackfillGame(ctx context.Context, client spanner.Client, c config.Config, gameUUID string) ([]Participant, error) {
+	window := rating.Window{Initial: c.Rating.Window_initial, Growth: c.Rating.Window_growth, Max: c.Rating.Window_max}
+	var added []Participant
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		added = []Participant{}
+
+		g := Game{GameUUID: gameUUID}
+		if err := g.getRunningGame(ctx, txn); err != nil {
+			return err
+		}
+
+		_, mode, err := gameModeConfig(c.Game, g.Mode)
+		if err != nil {
+			return err
+		}
+
+		participants, err := g.getParticipants(ctx, txn)
+		if err != nil {
+			return err
+		}
+		g.setRoster(participants)
+
+		// Teams are filled around the players and bots still in the game
+		var active []string
+		var bots []int64
+		teamOf := map[string]int64{}
+		for _, p := range participants {
+			if p.Leave_time.Valid {
+				continue
+			}
+
+			if p.Participant_type == ParticipantBot {
+				bots = append(bots, p.Team.Int64)
+				continue
+			}
+
+			active = append(active, p.PlayerUUID)
+			teamOf[p.PlayerUUID] = p.Team.Int64
+		}
+
+		open := mode.Max_players - len(active) - len(bots)
+		if open <= 0 {
+			return nil
+		}
+
+		stmt := spanner.Statement{
+			SQL: `SELECT playerUUID, rating FROM players WHERE playerUUID IN UNNEST(@players)`,
+			Params: map[string]interface{}{
+				"players": active,
+			},
+		}
+		iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetActivePlayers"})
+		rows, err := readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		counts := make([]int, mode.Teams)
+		totals := make([]float64, mode.Teams)
+		activeUnit := unit{}
+		for _, row := range rows {
+			var p Player
+			if err := row.Columns(&p.PlayerUUID, &p.Rating); err != nil {
+				return err
+			}
+			activeUnit.players = append(activeUnit.players, p)
+
+			if team := teamOf[p.PlayerUUID]; team >= 1 && int(team) <= mode.Teams {
+				counts[team-1]++
+				totals[team-1] += p.Rating
+			}
+		}
+
+		target := rating.DefaultRating
+		if len(activeUnit.players) > 0 {
+			target = activeUnit.rating()
+		}
+
+		// Bots are rated as the average of the players, like when they filled the game
+		for _, team := range bots {
+			if team >= 1 && int(team) <= mode.Teams {
+				counts[team-1]++
+				totals[team-1] += target
+			}
+		}
+		low, high := window.Bounds(target, time.Since(g.Created))
+
+		// Queued tickets come first
+		stmt = spanner.Statement{
+			SQL: `SELECT t.playerUUID, t.ticketUUID, t.rating, t.mode, t.party, t.regions, t.created FROM matchmaking_tickets@{FORCE_INDEX=TicketStatus} t
+					JOIN players p ON p.playerUUID = t.playerUUID
+					WHERE t.status=@status AND t.mode=@mode AND t.rating BETWEEN @low AND @high
+					AND t.playerUUID NOT IN UNNEST(@roster) AND p.current_game IS NULL
+					AND (@region = '' OR @region IN UNNEST(t.regions))
+					ORDER BY ABS(t.rating - @rating), t.created LIMIT @limit`,
+			Params: map[string]interface{}{
+				"status": TicketQueued,
+				"mode":   g.Mode,
+				"low":    low,
+				"high":   high,
+				"roster": g.Players,
+				"region": g.Region,
+				"rating": target,
+				"limit":  open * 2,
+			},
+		}
+		iter = txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetBackfillTickets"})
+		rows, err = readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		tickets := map[string]queuedTicket{}
+		var queued []Player
+		var parties []string
+		for _, row := range rows {
+			var t queuedTicket
+			if err := row.ToStruct(&t); err != nil {
+				return err
+			}
+
+			if t.Party.Valid {
+				parties = append(parties, t.Party.StringVal)
+				continue
+			}
+
+			tickets[t.PlayerUUID] = t
+			queued = append(queued, Player{PlayerUUID: t.PlayerUUID, Rating: t.Rating})
+		}
+
+		if len(parties) > 0 {
+			partyTickets, members, err := getQueuedParties(ctx, txn, parties)
+			if err != nil {
+				return err
+			}
+
+			for playerUUID, t := range partyTickets {
+				tickets[playerUUID] = t
+			}
+			queued = append(queued, members...)
+		}
+
+		var units []unit
+		for _, u := range groupUnits(queued) {
+			if g.Region == "" || unitInRegion(u, tickets, g.Region) {
+				units = append(units, u)
+			}
+		}
+		sortByRating(units, target)
+
+		// Then idle players, who have no region. Players queued for another mode are left to the matchmaking worker
+		if g.Region == "" {
+			stmt = spanner.Statement{
+				SQL: `SELECT playerUUID, rating FROM players@{FORCE_INDEX=PlayerRating} p
+						WHERE current_game IS NULL AND party IS NULL AND rating BETWEEN @low AND @high
+						AND playerUUID NOT IN UNNEST(@roster)
+						AND NOT EXISTS (SELECT 1 FROM matchmaking_tickets t WHERE t.playerUUID = p.playerUUID AND t.status = @queued)
+						ORDER BY ABS(rating - @rating) LIMIT @limit`,
+				Params: map[string]interface{}{
+					"queued": TicketQueued,
+					"low":    low,
+					"high":   high,
+					"roster": g.Players,
+					"rating": target,
+					"limit":  open * 2,
+				},
+			}
+			iter = txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetBackfillPlayers"})
+			rows, err = readRows(iter)
+			if err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				var p Player
+				if err := row.Columns(&p.PlayerUUID, &p.Rating); err != nil {
+					return err
+				}
+
+				if _, ok := tickets[p.PlayerUUID]; !ok {
+					units = append(units, unit{players: []Player{p}})
+				}
+			}
+		}
+
+		players, teams := planBackfill(units, counts, totals, open, mode)
+		if len(players) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		var m []*spanner.Mutation
+		var playerUUIDs []string
+		for i, p := range players {
+			playerUUIDs = append(playerUUIDs, p.PlayerUUID)
+			added = append(added, Participant{PlayerUUID: p.PlayerUUID, Team: spanner.NullInt64{Int64: teams[i], Valid: true}, Join_time: now})
+
+			gpCols := []string{"gameUUID", "playerUUID", "team", "join_time"}
+			m = append(m, spanner.Insert("game_participants", gpCols, []interface{}{g.GameUUID, p.PlayerUUID, teams[i], now}))
+
+			pCols := []string{"playerUUID", "current_game"}
+			m = append(m, spanner.Update("players", pCols, []interface{}{p.PlayerUUID, g.GameUUID}))
+		}
+
+		// The players array is kept for older clients
+		m = append(m, spanner.Update("games", []string{"gameUUID", "players"}, []interface{}{g.GameUUID, append(g.Players, playerUUIDs...)}))
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		stmt = spanner.Statement{
+			SQL: `UPDATE matchmaking_tickets SET status=@matched, gameUUID=@game, updated=CURRENT_TIMESTAMP()
+					WHERE playerUUID IN UNNEST(@players) AND status=@queued`,
+			Params: map[string]interface{}{
+				"players": playerUUIDs,
+				"game":    g.GameUUID,
+				"matched": TicketMatched,
+				"queued":  TicketQueued,
+			},
+		}
+		if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=MatchBackfillTickets"}); err != nil {
+			return err
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=backfill_game"})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return added, nil
+}
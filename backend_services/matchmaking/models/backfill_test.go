@@ -0,0 +1,55 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	spanner "cloud.google.com/go/spanner"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanBackfill(t *testing.T) {
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 4, Teams: 2, Team_size: 2}
+	party := spanner.NullString{StringVal: "a", Valid: true}
+
+	// Team 1 lost a player, so the party of two can't fit and the next solo player takes the slot
+	units := []unit{
+		{players: []Player{{PlayerUUID: "1", Rating: 1500, Party: party}, {PlayerUUID: "2", Rating: 1500, Party: party}}},
+		{players: []Player{{PlayerUUID: "3", Rating: 1400}}},
+		{players: []Player{{PlayerUUID: "4", Rating: 1600}}},
+	}
+	players, teams := planBackfill(units, []int{1, 2}, []float64{1500, 3000}, 1, mode)
+	assert.Equal(t, []Player{{PlayerUUID: "3", Rating: 1400}}, players)
+	assert.Equal(t, []int64{1}, teams)
+
+	// A whole team left, so the party fills it together
+	players, teams = planBackfill(units, []int{0, 2}, []float64{0, 3000}, 2, mode)
+	assert.Len(t, players, 2)
+	assert.Equal(t, []int64{1, 1}, teams)
+
+	// Open slots are filled across teams
+	players, teams = planBackfill(units[1:], []int{1, 1}, []float64{1500, 1500}, 2, mode)
+	assert.Len(t, players, 2)
+	assert.ElementsMatch(t, []int64{1, 2}, teams)
+
+	players, teams = planBackfill(nil, []int{1, 1}, []float64{1500, 1500}, 2, mode)
+	assert.Empty(t, players)
+	assert.Empty(t, teams)
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+)
+
+// Participant types. Participants recorded before types were added are players.
+const (
+	ParticipantPlayer = "player"
+	ParticipantBot    = "bot"
+)
+
+// botSlots is a private helper that returns how many bots should fill a queued game with count matched players,
+// when its oldest ticket has waited for waited. Bots fill the game up to the mode's maximum players once the bot
+// policy's wait has passed, as long as enough players were matched. Returns 0 when bots are turned off.
+func botSlots(count int, waited time.Duration, mode config.GameModeConfig, c config.BotConfig) int {
+	if c.Fill_after <= 0 || waited < c.Fill_after {
+		return 0
+	}
+
+	if count == 0 || count < c.Min_players || count >= mode.Max_players {
+		return 0
+	}
+
+	return mode.Max_players - count
+}
+
+// planBots is a private helper that places bots into the teams of a game's players, the team with the fewest players
+// first. Bots are rated as the average of the game's players, so they even out the teams' ratings.
+// Returns the bots' teams, which are empty if they don't fit.
+func planBots(players []Player, teams []int64, bots int, mode config.GameModeConfig) []int64 {
+	counts := make([]int, mode.Teams)
+	totals := make([]float64, mode.Teams)
+	for i, p := range players {
+		if teams[i] >= 1 && int(teams[i]) <= mode.Teams {
+			counts[teams[i]-1]++
+			totals[teams[i]-1] += p.Rating
+		}
+	}
+
+	botRating := unit{players: players}.rating()
+	units := make([]unit, bots)
+	for i := range units {
+		units[i] = unit{players: []Player{{Rating: botRating}}}
+	}
+
+	botTeams, ok := fillTeams(units, mode, counts, totals)
+	if !ok {
+		return nil
+	}
+
+	return botTeams
+}
+
+// addBots is a private helper to buffer a bot participant on each of teams for the new game.
+// Bots get their own UUID in the game's roster, but have no player to lock into the game.
+func (g *Game) addBots(txn *spanner.ReadWriteTransaction, teams []int64) error {
+	var m []*spanner.Mutation
+
+	for _, team := range teams {
+		botUUID := generateUUID()
+		g.Players = append(g.Players, botUUID)
+		g.Teams = append(g.Teams, team)
+
+		gpCols := []string{"gameUUID", "playerUUID", "team", "join_time", "participant_type"}
+		m = append(m, spanner.Insert("game_participants", gpCols, []interface{}{g.GameUUID, botUUID, team, g.Created, ParticipantBot}))
+	}
+
+	// The players array is kept for older clients
+	m = append(m, spanner.Update("games", []string{"gameUUID", "players"}, []interface{}{g.GameUUID, g.Players}))
+
+	if err := txn.BufferWrite(m); err != nil {
+		return fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBotSlots(t *testing.T) {
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}
+	c := config.BotConfig{Fill_after: time.Minute, Min_players: 1}
+
+	assert.Equal(t, 7, botSlots(3, time.Minute, mode, c))
+	assert.Equal(t, 9, botSlots(1, 2*time.Minute, mode, c))
+
+	// Not before the wait, for full games, or without players
+	assert.Equal(t, 0, botSlots(3, 59*time.Second, mode, c))
+	assert.Equal(t, 0, botSlots(10, time.Minute, mode, c))
+	assert.Equal(t, 0, botSlots(0, time.Minute, mode, config.BotConfig{Fill_after: time.Minute}))
+
+	// Not with fewer than the policy's minimum players
+	assert.Equal(t, 0, botSlots(2, time.Minute, mode, config.BotConfig{Fill_after: time.Minute, Min_players: 3}))
+
+	// Bots are turned off by default
+	assert.Equal(t, 0, botSlots(3, time.Hour, mode, config.BotConfig{Min_players: 1}))
+}
+
+func TestPlanBots(t *testing.T) {
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}
+	players := []Player{{PlayerUUID: "1", Rating: 1500}, {PlayerUUID: "2", Rating: 1600}, {PlayerUUID: "3", Rating: 1400}}
+
+	// Bots even out the team sizes first
+	teams := planBots(players, []int64{1, 1, 2}, 7, mode)
+	assert.Len(t, teams, 7)
+
+	counts := map[int64]int{1: 2, 2: 1}
+	for _, team := range teams {
+		counts[team]++
+	}
+	assert.Equal(t, map[int64]int{1: 5, 2: 5}, counts)
+
+	// Bots that don't fit aren't placed
+	assert.Empty(t, planBots(players, []int64{1, 1, 2}, 8, mode))
+}
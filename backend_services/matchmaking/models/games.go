@@ -24,20 +24,59 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"sort"
 	"time"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/allocator"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/rating"
 	"github.com/google/uuid"
 	iterator "google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
 )
 
-// Game represents information for a single game
+// Game statuses. Games created before statuses were added have none.
+const (
+	GameActive    = "active"
+	GameFinished  = "finished"
+	GameAbandoned = "abandoned"
+	GameFailed    = "failed"
+)
+
+var (
+	// ErrNoPlayersAvailable is returned when creating a game while every player is already in a game
+	ErrNoPlayersAvailable = errors.New("no players available for a new game")
+
+	// ErrNotEnoughPlayers is returned when fewer players than the game mode's minimum can be matched
+	ErrNotEnoughPlayers = errors.New("not enough players available for the game mode")
+
+	// ErrGameNotFound is returned when a game does not exist
+	ErrGameNotFound = errors.New("game not found")
+
+	// ErrUnknownMode is returned when creating a game for a mode that isn't configured
+	ErrUnknownMode = errors.New("unknown game mode")
+)
+
+// Game represents information for a single game.
+// Players and Teams hold the game's participants and their teams, in the same order.
+// Winning_team is 0 when the game was won by a single player. Region is empty for games created without regions.
+// Endpoint is where players connect to the game's server, once one is allocated.
 type Game struct {
-	GameUUID string           `json:"gameUUID"`
-	Players  []string         `json:"players"`
-	Winner   string           `json:"winner"`
-	Created  time.Time        `json:"created"`
-	Finished spanner.NullTime `json:"finished"`
+	GameUUID     string           `json:"gameUUID"`
+	Players      []string         `json:"players"`
+	Winner       string           `json:"winner"`
+	Created      time.Time        `json:"created"`
+	Finished     spanner.NullTime `json:"finished"`
+	Mode         string           `json:"mode"`
+	Status       string           `json:"status"`
+	Region       string           `json:"region"`
+	Endpoint     string           `json:"endpoint"`
+	Game_server  string           `json:"game_server"`
+	Teams        []int64          `json:"teams"`
+	Winning_team int64            `json:"winning_team"`
+	Results      []PlayerResult   `json:"results"`
+	Participants []Participant    `json:"participants,omitempty"`
 }
 
 // generateUUID is a private helper to create and returns a v4 UUID string.
@@ -66,8 +105,8 @@ func readRows(iter *spanner.RowIterator) ([]spanner.Row, error) {
 	return rows, nil
 }
 
-// determineWinner returns the uuid of the winning player
-// Current implementation is a random player from the list of players assigned to the game
+// determineWinner returns the uuid of a random player from the list of players assigned to the game
+// It is only used to simulate results for load tests.
 func determineWinner(playerUUIDs []string) string {
 	if len(playerUUIDs) == 0 {
 		return ""
@@ -81,15 +120,34 @@ func determineWinner(playerUUIDs []string) string {
 	return winnerUUID
 }
 
+// rateGame is a private helper to update the ratings of the game's players from their placements in the game.
+// Bots aren't rated, and players' rating changes are scaled by the bot policy's weight when the game had bots.
+func (g Game) rateGame(players []Player, c config.BotConfig) {
+	ratings := make([]rating.Rating, len(players))
+	placements := make([]int, len(players))
+	for i, p := range players {
+		ratings[i] = rating.Rating{Rating: p.Rating, Deviation: p.Rating_deviation}
+		placements[i] = g.placement(p.PlayerUUID)
+	}
+
+	for i, r := range rating.UpdateMatch(ratings, placements) {
+		if g.hasBots() {
+			r = rating.Weighted(ratings[i], r, c.Rating_weight)
+		}
+
+		players[i].Rating = r.Rating
+		players[i].Rating_deviation = r.Deviation
+	}
+}
+
 // getGamePlayers returns player information for a specificied game
-// We only care about the playerUUID and their stats, as this is intended to be used
+// We only care about the playerUUID, their stats and rating, as this is intended to be used
 // to modify players when a game is closed. We get the current_game to make sure later that the player is part of the game.
 func (g Game) getGamePlayers(ctx context.Context, txn *spanner.ReadWriteTransaction) ([]string, []Player, error) {
 	stmt := spanner.Statement{
-		SQL: `SELECT PlayerUUID, Stats, Current_game FROM players
-				INNER JOIN (
-				SELECT pUUID FROM games g, UNNEST(g.Players) AS pUUID WHERE gameUUID=@game
-				) AS gPlayers ON gPlayers.pUUID = players.PlayerUUID;`,
+		SQL: `SELECT p.PlayerUUID, p.Stats, p.Current_game, p.Rating, p.Rating_deviation FROM game_participants gp
+				INNER JOIN players p ON p.PlayerUUID = gp.playerUUID
+				WHERE gp.gameUUID=@game`,
 		Params: map[string]interface{}{
 			"game": g.GameUUID,
 		},
@@ -124,6 +182,47 @@ func (g Game) getGamePlayers(ctx context.Context, txn *spanner.ReadWriteTransact
 	return playerUUIDs, players, nil
 }
 
+// GetGame returns a game with its participants, their results, and the game's winner.
+// Returns ErrGameNotFound if the game does not exist.
+func GetGame(ctx context.Context, client spanner.Client, gameUUID string) (Game, error) {
+	txn := client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	row, err := txn.ReadRowWithOptions(ctx, "games", spanner.Key{gameUUID},
+		[]string{"created", "finished", "winner", "winning_team", "mode", "status", "region", "endpoint", "game_server"},
+		&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetGame"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return Game{}, ErrGameNotFound
+	}
+	if err != nil {
+		return Game{}, err
+	}
+
+	g := Game{GameUUID: gameUUID}
+	var created spanner.NullTime
+	var winner, mode, status, region, endpoint, gameServer spanner.NullString
+	var winningTeam spanner.NullInt64
+	if err := row.Columns(&created, &g.Finished, &winner, &winningTeam, &mode, &status, &region, &endpoint, &gameServer); err != nil {
+		return Game{}, err
+	}
+
+	g.Created = created.Time
+	g.Winner = winner.StringVal
+	g.Winning_team = winningTeam.Int64
+	g.Mode = mode.StringVal
+	g.Status = status.StringVal
+	g.Region = region.StringVal
+	g.Endpoint = endpoint.StringVal
+	g.Game_server = gameServer.StringVal
+
+	if g.Participants, err = g.getParticipants(ctx, txn); err != nil {
+		return Game{}, err
+	}
+	g.setRoster(g.Participants)
+
+	return g, nil
+}
+
 // GetOpenGame returns the gameUUID of an open game.
 func GetOpenGame(ctx context.Context, client spanner.Client) (Game, error) {
 	var g Game
@@ -155,9 +254,18 @@ func GetOpenGame(ctx context.Context, client spanner.Client) (Game, error) {
 // updateGamePlayers updates a game's player statistics when closing out a game.
 // Updating players involves closing out the game (current_game = NULL) and
 // updating their game stats. Specifically, we are incrementing games_played.
-// If the player is the determined winner, then their games_won stat is incremented.
+// If the player is the winner or on the winning team, then their games_won stat is incremented.
+// The players' new ratings are stored, and they are marked idle from now for matchmaking.
+// Players who left the game before it ended were already released, so only their stats and ratings are updated.
+// Bots have no stats and are skipped.
 func (g Game) updateGamePlayers(txn *spanner.ReadWriteTransaction, players []Player) error {
+	now := time.Now()
+
 	for _, p := range players {
+		if g.isBot(p.PlayerUUID) {
+			continue
+		}
+
 		// Modify stats
 		var pStats PlayerStats
 		if err := json.Unmarshal([]byte(p.Stats.String()), &pStats); err != nil {
@@ -166,7 +274,7 @@ func (g Game) updateGamePlayers(txn *spanner.ReadWriteTransaction, players []Pla
 
 		pStats.Games_played = pStats.Games_played + 1
 
-		if p.PlayerUUID == g.Winner {
+		if g.isWinner(p.PlayerUUID) {
 			pStats.Games_won = pStats.Games_won + 1
 		}
 		updatedStats, _ := json.Marshal(pStats)
@@ -174,20 +282,32 @@ func (g Game) updateGamePlayers(txn *spanner.ReadWriteTransaction, players []Pla
 			return fmt.Errorf("could not unmarshal json: %s", err)
 		}
 
+		// Players who left may already be in another game
+		if g.hasLeft(p.PlayerUUID) {
+			cols := []string{"playerUUID", "stats", "rating", "rating_deviation"}
+			err := txn.BufferWrite([]*spanner.Mutation{
+				spanner.Update("players", cols, []interface{}{p.PlayerUUID, p.Stats, p.Rating, p.Rating_deviation}),
+			})
+			if err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+			continue
+		}
+
 		// Update player
 		// If player's current game isn't the same as this game, that's an error
 		if p.Current_game != g.GameUUID {
 			return fmt.Errorf("player '%s' doesn't belong to game '%s'", p.PlayerUUID, g.GameUUID)
 		}
 
-		cols := []string{"playerUUID", "current_game", "stats"}
+		cols := []string{"playerUUID", "current_game", "stats", "rating", "rating_deviation", "idle_since"}
 		newGame := spanner.NullString{
 			StringVal: "",
 			Valid:     false,
 		}
 
 		err := txn.BufferWrite([]*spanner.Mutation{
-			spanner.Update("players", cols, []interface{}{p.PlayerUUID, newGame, p.Stats}),
+			spanner.Update("players", cols, []interface{}{p.PlayerUUID, newGame, p.Stats, p.Rating, p.Rating_deviation, now}),
 		})
 
 		if err != nil {
@@ -198,70 +318,254 @@ func (g Game) updateGamePlayers(txn *spanner.ReadWriteTransaction, players []Pla
 	return nil
 }
 
-// CreateGame starts a new game and assign players
-// Players that are not currently playing a game are eligble to be selected for the new game
-// Current implementation allows for less than numPlayers to be placed in a game
-func (g *Game) CreateGame(ctx context.Context, client spanner.Client) error {
+// gameModeConfig is a private helper to look up and validate a game mode, or the default mode when name is empty.
+// Returns the mode's name and configuration, or ErrUnknownMode if it isn't configured.
+func gameModeConfig(c config.GameConfig, name string) (string, config.GameModeConfig, error) {
+	name, mode, ok := c.Mode(name)
+	if !ok {
+		return "", config.GameModeConfig{}, ErrUnknownMode
+	}
+
+	if err := mode.Validate(); err != nil {
+		return "", config.GameModeConfig{}, fmt.Errorf("invalid game mode '%s': %s", name, err)
+	}
+
+	return name, mode, nil
+}
+
+// assignTeams is a private helper that returns the team of each unit, numbered from 1, keeping each party on one team
+// and balancing the teams' sizes and ratings. Units are placed from the largest and highest rated down, each into the team
+// with the fewest players that has room for it, and the lowest total rating among those.
+// Returns false if a unit doesn't fit into any team.
+func assignTeams(units []unit, mode config.GameModeConfig) ([]int64, bool) {
+	return fillTeams(units, mode, make([]int, mode.Teams), make([]float64, mode.Teams))
+}
+
+// fillTeams is a private helper that places units like assignTeams, into teams that already hold counts players
+// with totals of their ratings. Returns false if a unit doesn't fit into any team.
+func fillTeams(units []unit, mode config.GameModeConfig, counts []int, totals []float64) ([]int64, bool) {
+	order := make([]int, len(units))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ua, ub := units[order[a]], units[order[b]]
+		if len(ua.players) != len(ub.players) {
+			return len(ua.players) > len(ub.players)
+		}
+		return ua.rating() > ub.rating()
+	})
+
+	counts = append([]int{}, counts...)
+	totals = append([]float64{}, totals...)
+	teams := make([]int64, len(units))
+	for _, i := range order {
+		size := len(units[i].players)
+
+		best := -1
+		for t := range counts {
+			if counts[t]+size > mode.Team_size {
+				continue
+			}
+			if best == -1 || counts[t] < counts[best] || (counts[t] == counts[best] && totals[t] < totals[best]) {
+				best = t
+			}
+		}
+
+		if best == -1 {
+			return nil, false
+		}
+
+		counts[best] += size
+		totals[best] += units[i].rating() * float64(size)
+		teams[i] = int64(best + 1)
+	}
+
+	return teams, true
+}
+
+// assignPlayers is a private helper to buffer the new game, add its players on their teams
+// as the game's participants, and lock the players into it.
+func (g *Game) assignPlayers(txn *spanner.ReadWriteTransaction, players []Player, teams []int64) error {
+	var m []*spanner.Mutation
+
+	g.Players = []string{}
+	for _, p := range players {
+		g.Players = append(g.Players, p.PlayerUUID)
+	}
+	g.Teams = teams
+	g.Created = time.Now()
+	g.Status = GameActive
+
+	// Create the game. The players array is kept for older clients, but participants hold the roster.
+	region := spanner.NullString{StringVal: g.Region, Valid: g.Region != ""}
+	gCols := []string{"gameUUID", "players", "created", "mode", "status", "region"}
+	m = append(m, spanner.Insert("games", gCols, []interface{}{g.GameUUID, g.Players, g.Created, g.Mode, g.Status, region}))
+
+	// Add the participants and update players to lock into this game
+	for i, p := range g.Players {
+		gpCols := []string{"gameUUID", "playerUUID", "team", "join_time"}
+		m = append(m, spanner.Insert("game_participants", gpCols, []interface{}{g.GameUUID, p, g.Teams[i], g.Created}))
+
+		pCols := []string{"playerUUID", "current_game"}
+		m = append(m, spanner.Update("players", pCols, []interface{}{p, g.GameUUID}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return nil
+}
+
+// CreateGame starts a new game of the provided mode and assign players with similar ratings
+// A random player that is not currently playing a game is chosen, and the game is filled with the
+// idle players whose ratings are closest to theirs, within the rating window. Players with a queued ticket
+// are left out, since the matchmaking worker places them in a game of the mode they asked for. The longer the chosen
+// player has been idle, the wider the window, so players with unusual ratings still find a game.
+// A party is matched as one unit with its average rating, and all of its members are placed on the same team,
+// so a party is only matched when none of its members are in a game and it fits into one of the mode's teams.
+// The game holds up to the mode's maximum players, split into the mode's teams.
+// The default mode is used when mode is empty. Once the game is created, a game server is allocated for it.
+// Returns ErrUnknownMode if the mode isn't configured, ErrNoPlayersAvailable if every player is already
+// in a game, ErrNotEnoughPlayers if fewer than the mode's minimum players are within the window, or
+// ErrAllocationFailed if no game server could be allocated, in which case the game is marked failed.
+func (g *Game) CreateGame(ctx context.Context, client spanner.Client, c config.Config, mode string, a allocator.Allocator) error {
+	name, gameMode, err := gameModeConfig(c.Game, mode)
+	if err != nil {
+		return err
+	}
+
 	// Initialize game values
 	g.GameUUID = generateUUID()
+	g.Mode = name
 
-	numPlayers := 10
+	window := rating.Window{Initial: c.Rating.Window_initial, Growth: c.Rating.Window_growth, Max: c.Rating.Window_max}
 
 	// Create and assign
-	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
-		var m []*spanner.Mutation
+	_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		// get the player to match others against
+		stmt := spanner.Statement{
+			SQL: `SELECT playerUUID, rating, party, COALESCE(idle_since, created, CURRENT_TIMESTAMP()) FROM (
+					SELECT playerUUID, rating, party, idle_since, created FROM players p WHERE current_game IS NULL
+					AND NOT EXISTS (SELECT 1 FROM matchmaking_tickets t WHERE t.playerUUID = p.playerUUID AND t.status = @queued)
+					LIMIT 10000
+					) TABLESAMPLE RESERVOIR (1 ROWS)`,
+			Params: map[string]interface{}{
+				"queued": TicketQueued,
+			},
+		}
+		iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetAnchorPlayer"})
 
-		// get players
-		query := fmt.Sprintf("SELECT playerUUID FROM (SELECT playerUUID FROM players WHERE current_game IS NULL LIMIT 10000) TABLESAMPLE RESERVOIR (%d ROWS)", numPlayers)
-		stmt := spanner.Statement{SQL: query}
-		iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=AssignPlayers"})
+		anchorRows, err := readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		if len(anchorRows) == 0 {
+			return ErrNoPlayersAvailable
+		}
+
+		var anchor Player
+		var idleSince time.Time
+		if err := anchorRows[0].Columns(&anchor.PlayerUUID, &anchor.Rating, &anchor.Party, &idleSince); err != nil {
+			return err
+		}
+
+		// the anchor's whole party is matched with them
+		anchorPlayers := []Player{anchor}
+		if anchor.Party.Valid {
+			if anchorPlayers, err = getPartyMembers(ctx, txn, []string{anchor.Party.StringVal}); err != nil {
+				return err
+			}
+		}
+
+		anchorUnits := groupUnits(anchorPlayers)
+		if len(anchorUnits) == 0 {
+			return ErrNotEnoughPlayers
+		}
+
+		var anchorUUIDs []string
+		for _, p := range anchorUnits[0].players {
+			anchorUUIDs = append(anchorUUIDs, p.PlayerUUID)
+		}
+
+		// get players within the anchor's rating window, closest rating first.
+		// Extra players are read, since some belong to parties that don't fit into the game.
+		low, high := window.Bounds(anchorUnits[0].rating(), time.Since(idleSince))
+		stmt = spanner.Statement{
+			SQL: `SELECT playerUUID, rating, party FROM players@{FORCE_INDEX=PlayerRating} p
+					WHERE current_game IS NULL AND rating BETWEEN @low AND @high AND playerUUID NOT IN UNNEST(@anchor)
+					AND NOT EXISTS (SELECT 1 FROM matchmaking_tickets t WHERE t.playerUUID = p.playerUUID AND t.status = @queued)
+					ORDER BY ABS(rating - @rating) LIMIT @limit`,
+			Params: map[string]interface{}{
+				"queued": TicketQueued,
+				"low":    low,
+				"high":   high,
+				"anchor": anchorUUIDs,
+				"rating": anchorUnits[0].rating(),
+				"limit":  gameMode.Max_players * 2,
+			},
+		}
+		iter = txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=AssignPlayers"})
 
 		playerRows, err := readRows(iter)
 		if err != nil {
 			return err
 		}
 
-		var playerUUIDs []string
-
+		var candidates []Player
+		var parties []string
 		for _, row := range playerRows {
-			var pUUID string
-			if err := row.Columns(&pUUID); err != nil {
+			var p Player
+			if err := row.Columns(&p.PlayerUUID, &p.Rating, &p.Party); err != nil {
 				return err
 			}
 
-			playerUUIDs = append(playerUUIDs, pUUID)
-		}
+			// party members are read with their whole party below
+			if p.Party.Valid {
+				if p.Party != anchor.Party {
+					parties = append(parties, p.Party.StringVal)
+				}
+				continue
+			}
 
-		// Create the game
-		gCols := []string{"gameUUID", "players", "created"}
-		m = append(m, spanner.Insert("games", gCols, []interface{}{g.GameUUID, playerUUIDs, time.Now()}))
+			candidates = append(candidates, p)
+		}
 
-		// Update players to lock into this game
+		if len(parties) > 0 {
+			members, err := getPartyMembers(ctx, txn, parties)
+			if err != nil {
+				return err
+			}
 
-		for _, p := range playerUUIDs {
-			pCols := []string{"playerUUID", "current_game"}
-			m = append(m, spanner.Update("players", pCols, []interface{}{p, g.GameUUID}))
+			candidates = append(candidates, members...)
 		}
 
-		if err := txn.BufferWrite(m); err != nil {
-			return fmt.Errorf("could not buffer write: %s", err)
+		players, teams := planGame(anchorUnits[0], groupUnits(candidates), low, high, gameMode)
+		if len(players) < gameMode.Min_players {
+			return ErrNotEnoughPlayers
 		}
 
-		return nil
+		return g.assignPlayers(txn, players, teams)
 	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=create_game"})
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return g.allocateServer(ctx, client, a)
 }
 
-// CloseGame chooses a random winner and closes the game when provided a game UUID
-// A game is closed by setting the winner and finished time.
-// Additionally all players' game stats are updated, and the current_game is set to null to allow
+// CloseGame closes the game with the result reported by the game server when provided a game UUID
+// The result holds a winner or winning team, and optionally every player's placement and score. It is
+// validated against the game's roster, and ErrInvalidResult is returned if it doesn't match.
+// When simulate is set, a random winner is chosen instead, which is only meant for load tests.
+// Rating changes from games with bots are weighted by the bot policy.
+// A game is closed by setting the winner and finished time, and storing each participant's result.
+// Additionally all players' game stats and ratings are updated, and the current_game is set to null to allow
 // them to be chosen for a new game.
-func (g *Game) CloseGame(ctx context.Context, client spanner.Client) error {
+func (g *Game) CloseGame(ctx context.Context, client spanner.Client, c config.BotConfig, simulate bool) error {
 	// Close game
 	_, err := client.ReadWriteTransactionWithOptions(ctx,
 		func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
@@ -281,6 +585,14 @@ func (g *Game) CloseGame(ctx context.Context, client spanner.Client) error {
 				return errors.New(errorMsg)
 			}
 
+			// Get the roster to validate the result against
+			participants, err := g.getParticipants(ctx, txn)
+			if err != nil {
+				return err
+			}
+			g.setRoster(participants)
+			g.Participants = participants
+
 			// Get game players
 			playerUUIDs, players, err := g.getGamePlayers(ctx, txn)
 
@@ -294,20 +606,55 @@ func (g *Game) CloseGame(ctx context.Context, client spanner.Client) error {
 				return errors.New(errorMsg)
 			}
 
-			// Get random winner
-			g.Winner = determineWinner(playerUUIDs)
+			if simulate {
+				// Get random winner
+				g.Winner = determineWinner(playerUUIDs)
+				g.Winning_team = 0
+				g.Results = nil
+			}
 
-			cols := []string{"gameUUID", "finished", "winner"}
-			err = txn.BufferWrite([]*spanner.Mutation{
-				spanner.Update("games", cols, []interface{}{g.GameUUID, time.Now(), g.Winner}),
-			})
+			if err := g.validateResult(); err != nil {
+				return err
+			}
 
+			now := time.Now()
+			g.Status = GameFinished
+			winner := spanner.NullString{StringVal: g.Winner, Valid: g.Winner != ""}
+			winningTeam := spanner.NullInt64{Int64: g.Winning_team, Valid: g.Winning_team != 0}
+
+			cols := []string{"gameUUID", "finished", "winner", "winning_team", "status"}
+			m := []*spanner.Mutation{
+				spanner.Update("games", cols, []interface{}{g.GameUUID, now, winner, winningTeam, g.Status}),
+			}
+
+			// Store each participant's result, and the end of the game as the leave time of players still in it
+			for _, p := range participants {
+				var placement spanner.NullInt64
+				var score spanner.NullFloat64
+				if r, ok := g.result(p.PlayerUUID); ok {
+					placement = spanner.NullInt64{Int64: r.Placement, Valid: true}
+					score = spanner.NullFloat64{Float64: r.Score, Valid: true}
+				}
+
+				leaveTime := p.Leave_time
+				if !leaveTime.Valid {
+					leaveTime = spanner.NullTime{Time: now, Valid: true}
+				}
+
+				gpCols := []string{"gameUUID", "playerUUID", "placement", "score", "leave_time"}
+				m = append(m, spanner.Update("game_participants", gpCols, []interface{}{g.GameUUID, p.PlayerUUID, placement, score, leaveTime}))
+			}
+
+			err = txn.BufferWrite(m)
 			if err != nil {
 				return fmt.Errorf("could not buffer write: %s", err)
 			}
 
+			// Rate the players against each other based on their placements
+			g.rateGame(players, c)
+
 			// Update each player to increment stats.games_played (and stats.games_won if winner),
-			// and set current_game to null so they can be chosen for a new game
+			// store their new rating, and set current_game to null so they can be chosen for a new game
 			if err := g.updateGamePlayers(txn, players); err != nil {
 				return err
 			}
@@ -19,6 +19,7 @@ package models
 import (
 	"testing"
 
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -52,3 +53,105 @@ func TestGenerateUUID(t *testing.T) {
 	assert.Nil(t, err)
 
 }
+
+func TestRateGame(t *testing.T) {
+	g := Game{Winner: "2"}
+	players := []Player{
+		{PlayerUUID: "1", Rating: 1500, Rating_deviation: 350},
+		{PlayerUUID: "2", Rating: 1500, Rating_deviation: 350},
+		{PlayerUUID: "3", Rating: 1500, Rating_deviation: 350},
+	}
+
+	g.rateGame(players, config.BotConfig{})
+
+	assert.Greater(t, players[1].Rating, 1500.0)
+	assert.Less(t, players[0].Rating, 1500.0)
+	assert.Equal(t, players[0].Rating, players[2].Rating)
+
+	for _, p := range players {
+		assert.Less(t, p.Rating_deviation, 350.0)
+	}
+}
+
+func TestRateGameWithBots(t *testing.T) {
+	participants := []Participant{
+		{PlayerUUID: "1", Participant_type: ParticipantPlayer},
+		{PlayerUUID: "2", Participant_type: ParticipantPlayer},
+		{PlayerUUID: "bot", Participant_type: ParticipantBot},
+	}
+	newPlayers := func() []Player {
+		return []Player{
+			{PlayerUUID: "1", Rating: 1500, Rating_deviation: 350},
+			{PlayerUUID: "2", Rating: 1500, Rating_deviation: 350},
+		}
+	}
+
+	full := newPlayers()
+	Game{Winner: "2"}.rateGame(full, config.BotConfig{Rating_weight: 0.5})
+
+	g := Game{Winner: "2", Participants: participants}
+	weighted := newPlayers()
+	g.rateGame(weighted, config.BotConfig{Rating_weight: 0.5})
+	assert.InDelta(t, (full[1].Rating-1500)/2, weighted[1].Rating-1500, 0.0001)
+	assert.InDelta(t, (full[0].Rating-1500)/2, weighted[0].Rating-1500, 0.0001)
+
+	// A weight of 0 skips rating updates
+	skipped := newPlayers()
+	g.rateGame(skipped, config.BotConfig{})
+	assert.Equal(t, newPlayers(), skipped)
+
+	assert.True(t, g.isBot("bot"))
+	assert.False(t, g.isBot("1"))
+}
+
+func TestAssignTeams(t *testing.T) {
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}
+	var units []unit
+	for _, r := range []float64{1500, 1900, 1100, 1700, 1300, 1600} {
+		units = append(units, unit{players: []Player{{Rating: r}}})
+	}
+
+	teams, ok := assignTeams(units, mode)
+	assert.True(t, ok)
+
+	// Placed from the highest rating: 1900 -> 1, 1700 -> 2, 1600 -> 2, 1500 -> 1, 1300 -> 2, 1100 -> 1
+	assert.Equal(t, []int64{1, 1, 1, 2, 2, 2}, teams)
+
+	// Each player is their own team in a free for all
+	mode = config.GameModeConfig{Min_players: 2, Max_players: 50, Teams: 50, Team_size: 1}
+	teams, ok = assignTeams(units, mode)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []int64{1, 2, 3, 4, 5, 6}, teams)
+
+	// Parties stay on one team, and are placed before single players
+	mode = config.GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}
+	party := unit{players: []Player{{Rating: 1500}, {Rating: 1500}, {Rating: 1500}, {Rating: 1500}}}
+	teams, ok = assignTeams([]unit{units[0], units[1], party}, mode)
+	assert.True(t, ok)
+	assert.Equal(t, []int64{2, 2, 1}, teams)
+
+	// A party larger than the teams doesn't fit
+	_, ok = assignTeams([]unit{{players: make([]Player, 6)}}, mode)
+	assert.False(t, ok)
+}
+
+func TestGameModeConfig(t *testing.T) {
+	c := config.GameConfig{
+		Default_mode: "5v5",
+		Modes: map[string]config.GameModeConfig{
+			"5v5":    {Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5},
+			"broken": {Min_players: 2, Max_players: 10, Teams: 1, Team_size: 5},
+		},
+	}
+
+	name, mode, err := gameModeConfig(c, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "5v5", name)
+	assert.Equal(t, 10, mode.Max_players)
+
+	_, _, err = gameModeConfig(c, "1v1")
+	assert.ErrorIs(t, err, ErrUnknownMode)
+
+	_, _, err = gameModeConfig(c, "broken")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+)
+
+const (
+	// DefaultPageSize is used when a player's games are listed without a page size
+	DefaultPageSize = 20
+
+	// MaxPageSize is the largest page of games that can be returned
+	MaxPageSize = 100
+)
+
+// ErrInvalidPageToken is returned when a page token can't be decoded, or was issued for another player
+var ErrInvalidPageToken = errors.New("page token is invalid")
+
+// PlayerGame is a finished game in a player's match history, along with the player's own result
+type PlayerGame struct {
+	GameUUID     string              `json:"gameUUID"`
+	Mode         spanner.NullString  `json:"mode"`
+	Status       spanner.NullString  `json:"status"`
+	Created      spanner.NullTime    `json:"created"`
+	Finished     time.Time           `json:"finished"`
+	Winner       spanner.NullString  `json:"winner"`
+	Winning_team spanner.NullInt64   `json:"winning_team"`
+	Team         spanner.NullInt64   `json:"team"`
+	Placement    spanner.NullInt64   `json:"placement"`
+	Score        spanner.NullFloat64 `json:"score"`
+	Join_time    time.Time           `json:"join_time"`
+	Leave_time   spanner.NullTime    `json:"leave_time"`
+}
+
+// PlayerGamePage is a single page of a player's match history.
+// Next_page_token is empty on the last page.
+type PlayerGamePage struct {
+	Games           []PlayerGame `json:"games"`
+	Next_page_token string       `json:"next_page_token,omitempty"`
+}
+
+// historyToken is the position a player's match history continues from. The player is kept so a token
+// can't be used to continue another player's history.
+type historyToken struct {
+	Player    string    `json:"p"`
+	Join_time time.Time `json:"t"`
+	GameUUID  string    `json:"g"`
+}
+
+// encodeHistoryToken is a private helper to turn a match history position into an opaque token
+func encodeHistoryToken(t historyToken) (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeHistoryToken is a private helper to read the match history position from a token.
+// Returns ErrInvalidPageToken if the token is malformed or was issued for another player.
+func decodeHistoryToken(token string, playerUUID string) (historyToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return historyToken{}, ErrInvalidPageToken
+	}
+
+	var t historyToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return historyToken{}, ErrInvalidPageToken
+	}
+
+	if t.Player != playerUUID {
+		return historyToken{}, ErrInvalidPageToken
+	}
+
+	return t, nil
+}
+
+// GetPlayerGames returns a page of the player's finished games, newest first by when the player joined.
+//
+// The games are read from the GameParticipantPlayer index and continue from the last game of the previous
+// page, so every page costs the same no matter how far back in the history it is.
+// An empty token returns the first page. A pageSize outside of 1 to MaxPageSize uses DefaultPageSize.
+func GetPlayerGames(ctx context.Context, client spanner.Client, playerUUID string, token string, pageSize int) (PlayerGamePage, error) {
+	if pageSize < 1 || pageSize > MaxPageSize {
+		pageSize = DefaultPageSize
+	}
+
+	// Read one extra row to find out if there is another page
+	stmt := spanner.Statement{
+		SQL: `SELECT gp.gameUUID, g.mode, g.status, g.created, g.finished, g.winner, g.winning_team,
+				gp.team, gp.placement, gp.score, gp.join_time, gp.leave_time
+				FROM game_participants@{FORCE_INDEX=GameParticipantPlayer} gp
+				INNER JOIN games g ON g.gameUUID = gp.gameUUID
+				WHERE gp.playerUUID = @player AND g.finished IS NOT NULL`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  pageSize + 1,
+		},
+	}
+
+	if token != "" {
+		after, err := decodeHistoryToken(token, playerUUID)
+		if err != nil {
+			return PlayerGamePage{}, err
+		}
+
+		stmt.SQL += ` AND (gp.join_time < @join_time OR (gp.join_time = @join_time AND gp.gameUUID > @game))`
+		stmt.Params["join_time"] = after.Join_time
+		stmt.Params["game"] = after.GameUUID
+	}
+
+	stmt.SQL += ` ORDER BY gp.join_time DESC, gp.gameUUID LIMIT @limit`
+
+	iter := client.Single().QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetPlayerGames"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return PlayerGamePage{}, err
+	}
+
+	page := PlayerGamePage{Games: []PlayerGame{}}
+	for _, row := range rows {
+		var g PlayerGame
+		if err := row.ToStruct(&g); err != nil {
+			return PlayerGamePage{}, err
+		}
+
+		page.Games = append(page.Games, g)
+	}
+
+	if len(page.Games) > pageSize {
+		page.Games = page.Games[:pageSize]
+
+		last := page.Games[pageSize-1]
+		next := historyToken{Player: playerUUID, Join_time: last.Join_time, GameUUID: last.GameUUID}
+		if page.Next_page_token, err = encodeHistoryToken(next); err != nil {
+			return PlayerGamePage{}, err
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,42 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryToken(t *testing.T) {
+	joined := time.Date(2023, 6, 1, 12, 30, 0, 123456789, time.UTC)
+	token, err := encodeHistoryToken(historyToken{Player: "player", Join_time: joined, GameUUID: "game"})
+	assert.Nil(t, err)
+
+	decoded, err := decodeHistoryToken(token, "player")
+	assert.Nil(t, err)
+	assert.True(t, joined.Equal(decoded.Join_time))
+	assert.Equal(t, "game", decoded.GameUUID)
+
+	// Tokens can't be used for another player's history
+	_, err = decodeHistoryToken(token, "other")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+
+	_, err = decodeHistoryToken("not a token", "player")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
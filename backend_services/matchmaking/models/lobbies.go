@@ -0,0 +1,458 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/allocator"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"google.golang.org/grpc/codes"
+)
+
+// Lobby invite codes are made of letters and digits that are hard to mix up
+const (
+	lobbyCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	lobbyCodeLength   = 6
+
+	// lobbyCodeAttempts is how many codes are tried before giving up on creating a lobby
+	lobbyCodeAttempts = 5
+)
+
+var (
+	// ErrLobbyNotFound is returned when no lobby has the invite code
+	ErrLobbyNotFound = errors.New("lobby not found")
+
+	// ErrNotLobbyHost is returned when a player that isn't the lobby's host tries to manage it
+	ErrNotLobbyHost = errors.New("player is not the lobby host")
+
+	// ErrNotLobbyMember is returned when kicking a player that isn't in the lobby
+	ErrNotLobbyMember = errors.New("player is not in the lobby")
+
+	// ErrKickedFromLobby is returned when a kicked player tries to join the lobby again
+	ErrKickedFromLobby = errors.New("player was kicked from the lobby")
+
+	// ErrKickHost is returned when the host tries to kick themselves
+	ErrKickHost = errors.New("the lobby host can't be kicked")
+
+	// ErrLobbyFull is returned when a lobby already has the mode's maximum players
+	ErrLobbyFull = errors.New("lobby is full")
+
+	// ErrLobbyStarted is returned when changing a lobby that was already started
+	ErrLobbyStarted = errors.New("lobby was already started")
+)
+
+// Lobby is a private custom game that players join with its invite code.
+// Once the host starts it, GameUUID holds the game it was turned into.
+type Lobby struct {
+	LobbyUUID string             `json:"lobbyUUID"`
+	Code      string             `json:"code"`
+	HostUUID  string             `json:"hostUUID"`
+	Mode      string             `json:"mode"`
+	GameUUID  spanner.NullString `json:"gameUUID"`
+	Created   time.Time          `json:"created"`
+	Started   spanner.NullTime   `json:"started"`
+	Players   []string           `json:"players"`
+}
+
+// generateLobbyCode is a private helper that returns a random invite code
+func generateLobbyCode() (string, error) {
+	code := make([]byte, lobbyCodeLength)
+	max := big.NewInt(int64(len(lobbyCodeAlphabet)))
+
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = lobbyCodeAlphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// getLobbyPlayers is a private helper that returns the lobby's players that weren't kicked, in the order they joined,
+// with their ratings and current games
+func (l Lobby) getLobbyPlayers(ctx context.Context, txn querier) ([]Player, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT p.playerUUID, p.rating, p.current_game FROM lobby_members m
+				JOIN players p ON p.playerUUID = m.playerUUID
+				WHERE m.lobbyUUID=@lobby AND m.kicked IS NULL ORDER BY m.joined, m.playerUUID`,
+		Params: map[string]interface{}{
+			"lobby": l.LobbyUUID,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetLobbyPlayers"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return nil, err
+	}
+
+	var players []Player
+	for _, row := range rows {
+		var p Player
+		var currentGame spanner.NullString
+		if err := row.Columns(&p.PlayerUUID, &p.Rating, &currentGame); err != nil {
+			return nil, err
+		}
+		p.Current_game = currentGame.StringVal
+
+		players = append(players, p)
+	}
+
+	return players, nil
+}
+
+// getLobby is a private helper that returns the lobby with the invite code, along with its players.
+// Returns ErrLobbyNotFound if no lobby has the code.
+func getLobby(ctx context.Context, txn querier, code string) (Lobby, []Player, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT lobbyUUID, code, hostUUID, mode, gameUUID, created, started FROM lobbies@{FORCE_INDEX=LobbyCode}
+				WHERE code=@code`,
+		Params: map[string]interface{}{
+			"code": code,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetLobby"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return Lobby{}, nil, err
+	}
+
+	if len(rows) == 0 {
+		return Lobby{}, nil, ErrLobbyNotFound
+	}
+
+	var l Lobby
+	if err := rows[0].ToStruct(&l); err != nil {
+		return Lobby{}, nil, err
+	}
+
+	players, err := l.getLobbyPlayers(ctx, txn)
+	if err != nil {
+		return Lobby{}, nil, err
+	}
+
+	l.Players = []string{}
+	for _, p := range players {
+		l.Players = append(l.Players, p.PlayerUUID)
+	}
+
+	return l, players, nil
+}
+
+// CreateLobby creates a private lobby for a game of the mode, hosted by the player, with a new invite code.
+// The default mode is used when mode is empty.
+// Returns ErrUnknownMode or ErrPlayerNotFound if the lobby can't be created.
+func CreateLobby(ctx context.Context, client spanner.Client, c config.GameConfig, hostUUID string, mode string) (Lobby, error) {
+	name, _, err := gameModeConfig(c, mode)
+	if err != nil {
+		return Lobby{}, err
+	}
+
+	// Codes are short, so a new code is tried if it is already taken
+	for attempt := 1; ; attempt++ {
+		code, err := generateLobbyCode()
+		if err != nil {
+			return Lobby{}, err
+		}
+
+		l := Lobby{
+			LobbyUUID: generateUUID(),
+			Code:      code,
+			HostUUID:  hostUUID,
+			Mode:      name,
+			Created:   time.Now(),
+			Players:   []string{hostUUID},
+		}
+
+		_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+			_, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{hostUUID}, []string{"playerUUID"},
+				&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetLobbyHost"})
+			if spanner.ErrCode(err) == codes.NotFound {
+				return ErrPlayerNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			lCols := []string{"lobbyUUID", "code", "hostUUID", "mode", "created"}
+			mCols := []string{"lobbyUUID", "playerUUID", "joined"}
+			err = txn.BufferWrite([]*spanner.Mutation{
+				spanner.Insert("lobbies", lCols, []interface{}{l.LobbyUUID, l.Code, l.HostUUID, l.Mode, l.Created}),
+				spanner.Insert("lobby_members", mCols, []interface{}{l.LobbyUUID, hostUUID, l.Created}),
+			})
+			if err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+
+			return nil
+		}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=create_lobby"})
+
+		if spanner.ErrCode(err) == codes.AlreadyExists && attempt < lobbyCodeAttempts {
+			continue
+		}
+		if err != nil {
+			return Lobby{}, err
+		}
+
+		return l, nil
+	}
+}
+
+// GetLobby returns the lobby with the invite code, along with its players.
+// Returns ErrLobbyNotFound if no lobby has the code.
+func GetLobby(ctx context.Context, client spanner.Client, code string) (Lobby, error) {
+	txn := client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	l, _, err := getLobby(ctx, txn, code)
+	return l, err
+}
+
+// JoinLobby adds the player to the lobby with the invite code. Joining a lobby the player is already in does nothing.
+// Players that are in a game, queued or in a party can't join, since they couldn't play when the lobby is started.
+// Returns ErrLobbyNotFound, ErrLobbyStarted, ErrPlayerNotFound, ErrKickedFromLobby, ErrPlayerInGame, ErrAlreadyQueued,
+// ErrAlreadyInParty or ErrLobbyFull if the player can't join.
+func JoinLobby(ctx context.Context, client spanner.Client, c config.GameConfig, code string, playerUUID string) (Lobby, error) {
+	var l Lobby
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		var err error
+		if l, _, err = getLobby(ctx, txn, code); err != nil {
+			return err
+		}
+
+		if l.Started.Valid {
+			return ErrLobbyStarted
+		}
+
+		row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"current_game", "party"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetLobbyPlayer"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var currentGame, party spanner.NullString
+		if err := row.Columns(&currentGame, &party); err != nil {
+			return err
+		}
+
+		row, err = txn.ReadRowWithOptions(ctx, "lobby_members", spanner.Key{l.LobbyUUID, playerUUID}, []string{"kicked"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetLobbyMember"})
+		if err == nil {
+			var kicked spanner.NullTime
+			if err := row.Column(0, &kicked); err != nil {
+				return err
+			}
+
+			if kicked.Valid {
+				return ErrKickedFromLobby
+			}
+			return nil
+		}
+		if spanner.ErrCode(err) != codes.NotFound {
+			return err
+		}
+
+		if currentGame.Valid {
+			return ErrPlayerInGame
+		}
+
+		if party.Valid {
+			return ErrAlreadyInParty
+		}
+
+		queued, err := playersQueued(ctx, txn, []string{playerUUID})
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrAlreadyQueued
+		}
+
+		_, mode, err := gameModeConfig(c, l.Mode)
+		if err != nil {
+			return err
+		}
+
+		if len(l.Players) >= mode.Max_players {
+			return ErrLobbyFull
+		}
+
+		cols := []string{"lobbyUUID", "playerUUID", "joined"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Insert("lobby_members", cols, []interface{}{l.LobbyUUID, playerUUID, time.Now()}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		l.Players = append(l.Players, playerUUID)
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=join_lobby"})
+
+	if err != nil {
+		return Lobby{}, err
+	}
+
+	return l, nil
+}
+
+// KickFromLobby removes the player from the lobby and keeps them from joining it again. Only the host can kick players.
+// Returns ErrLobbyNotFound, ErrNotLobbyHost, ErrLobbyStarted, ErrKickHost or ErrNotLobbyMember if the player can't be kicked.
+func KickFromLobby(ctx context.Context, client spanner.Client, code string, hostUUID string, playerUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		l, _, err := getLobby(ctx, txn, code)
+		if err != nil {
+			return err
+		}
+
+		if l.HostUUID != hostUUID {
+			return ErrNotLobbyHost
+		}
+
+		if l.Started.Valid {
+			return ErrLobbyStarted
+		}
+
+		if playerUUID == hostUUID {
+			return ErrKickHost
+		}
+
+		member := false
+		for _, p := range l.Players {
+			if p == playerUUID {
+				member = true
+			}
+		}
+
+		if !member {
+			return ErrNotLobbyMember
+		}
+
+		cols := []string{"lobbyUUID", "playerUUID", "kicked"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("lobby_members", cols, []interface{}{l.LobbyUUID, playerUUID, time.Now()}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=kick_from_lobby"})
+
+	return err
+}
+
+// StartLobby turns the lobby into a game of its mode, with the lobby's players balanced into the mode's teams.
+// The game is created like any matched game, so it is closed and rated the same way. The players' queued tickets are
+// cancelled, since they are now in a game. Only the host can start the lobby.
+// Once the game is created, a game server is allocated for it. If that fails, the lobby can be started again.
+// Returns ErrLobbyNotFound, ErrNotLobbyHost, ErrLobbyStarted, ErrUnknownMode, ErrPlayerInGame, ErrNotEnoughPlayers
+// or ErrAllocationFailed if the lobby can't be started.
+func StartLobby(ctx context.Context, client spanner.Client, c config.GameConfig, code string, hostUUID string, a allocator.Allocator) (Game, error) {
+	var g Game
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		l, players, err := getLobby(ctx, txn, code)
+		if err != nil {
+			return err
+		}
+
+		if l.HostUUID != hostUUID {
+			return ErrNotLobbyHost
+		}
+
+		if l.Started.Valid {
+			return ErrLobbyStarted
+		}
+
+		_, mode, err := gameModeConfig(c, l.Mode)
+		if err != nil {
+			return err
+		}
+
+		if len(players) < mode.Min_players {
+			return ErrNotEnoughPlayers
+		}
+
+		var units []unit
+		for _, p := range players {
+			if p.Current_game != "" {
+				return ErrPlayerInGame
+			}
+
+			units = append(units, unit{players: []Player{p}})
+		}
+
+		teams, ok := assignTeams(units, mode)
+		if !ok {
+			return ErrLobbyFull
+		}
+
+		g = Game{GameUUID: generateUUID(), Mode: l.Mode}
+		if err := g.assignPlayers(txn, players, teams); err != nil {
+			return err
+		}
+
+		cols := []string{"lobbyUUID", "gameUUID", "started"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("lobbies", cols, []interface{}{l.LobbyUUID, g.GameUUID, g.Created}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		stmt := spanner.Statement{
+			SQL: `UPDATE matchmaking_tickets SET status=@cancelled, updated=CURRENT_TIMESTAMP()
+					WHERE playerUUID IN UNNEST(@players) AND status=@queued`,
+			Params: map[string]interface{}{
+				"players":   g.Players,
+				"cancelled": TicketCancelled,
+				"queued":    TicketQueued,
+			},
+		}
+
+		if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=CancelLobbyTickets"}); err != nil {
+			return err
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=start_lobby"})
+
+	if err != nil {
+		return Game{}, err
+	}
+
+	if err := g.allocateServer(ctx, client, a); err != nil {
+		return Game{}, err
+	}
+
+	return g, nil
+}
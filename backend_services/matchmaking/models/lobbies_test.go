@@ -0,0 +1,38 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateLobbyCode(t *testing.T) {
+	code, err := generateLobbyCode()
+	assert.Nil(t, err)
+	assert.Len(t, code, lobbyCodeLength)
+
+	for _, r := range code {
+		assert.True(t, strings.ContainsRune(lobbyCodeAlphabet, r))
+	}
+
+	other, err := generateLobbyCode()
+	assert.Nil(t, err)
+	assert.NotEqual(t, code, other)
+}
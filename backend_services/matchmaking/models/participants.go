@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+)
+
+// Participant is a player's part in a game. Placement and score are set when the game is closed
+// with results, and the leave time when the player leaves or the game ends.
+// Participant_type is ParticipantBot for the bot slots of a game, which have no player.
+type Participant struct {
+	PlayerUUID       string              `json:"playerUUID"`
+	Team             spanner.NullInt64   `json:"team"`
+	Placement        spanner.NullInt64   `json:"placement"`
+	Score            spanner.NullFloat64 `json:"score"`
+	Join_time        time.Time           `json:"join_time"`
+	Leave_time       spanner.NullTime    `json:"leave_time"`
+	Participant_type string              `json:"participant_type"`
+}
+
+// querier is implemented by both read-only and read-write transactions
+type querier interface {
+	QueryWithOptions(ctx context.Context, statement spanner.Statement, opts spanner.QueryOptions) *spanner.RowIterator
+}
+
+// getParticipants returns the game's participants in the order they joined
+func (g Game) getParticipants(ctx context.Context, txn querier) ([]Participant, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT playerUUID, team, placement, score, join_time, leave_time, participant_type FROM game_participants
+				WHERE gameUUID=@game ORDER BY join_time, playerUUID`,
+		Params: map[string]interface{}{
+			"game": g.GameUUID,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetGameParticipants"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return nil, err
+	}
+
+	participants := []Participant{}
+	for _, row := range rows {
+		var p Participant
+		if err := row.ToStruct(&p); err != nil {
+			return nil, err
+		}
+
+		participants = append(participants, p)
+	}
+
+	return participants, nil
+}
+
+// setRoster is a private helper to set the game's players and their teams from its participants
+func (g *Game) setRoster(participants []Participant) {
+	g.Players = []string{}
+	g.Teams = []int64{}
+	for _, p := range participants {
+		g.Players = append(g.Players, p.PlayerUUID)
+		g.Teams = append(g.Teams, p.Team.Int64)
+	}
+}
+
+// hasLeft is a private helper that reports whether the player left the game before it ended
+func (g Game) hasLeft(playerUUID string) bool {
+	for _, p := range g.Participants {
+		if p.PlayerUUID == playerUUID {
+			return p.Leave_time.Valid
+		}
+	}
+
+	return false
+}
+
+// isBot is a private helper that reports whether the participant is one of the game's bot slots
+func (g Game) isBot(playerUUID string) bool {
+	for _, p := range g.Participants {
+		if p.PlayerUUID == playerUUID {
+			return p.Participant_type == ParticipantBot
+		}
+	}
+
+	return false
+}
+
+// hasBots is a private helper that reports whether any of the game's slots were filled with bots
+func (g Game) hasBots() bool {
+	for _, p := range g.Participants {
+		if p.Participant_type == ParticipantBot {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,36 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRoster(t *testing.T) {
+	var g Game
+	g.setRoster([]Participant{
+		{PlayerUUID: "a", Team: spanner.NullInt64{Int64: 1, Valid: true}},
+		{PlayerUUID: "b", Team: spanner.NullInt64{Int64: 2, Valid: true}},
+		{PlayerUUID: "c"},
+	})
+
+	assert.Equal(t, []string{"a", "b", "c"}, g.Players)
+	assert.Equal(t, []int64{1, 2, 0}, g.Teams)
+}
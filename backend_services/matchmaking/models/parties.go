@@ -0,0 +1,611 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrPartyNotFound is returned when a party does not exist
+	ErrPartyNotFound = errors.New("party not found")
+
+	// ErrNotPartyLeader is returned when a player that doesn't lead the party tries to manage or queue it
+	ErrNotPartyLeader = errors.New("player is not the party leader")
+
+	// ErrAlreadyInParty is returned when a player that is already in a party tries to create or join one
+	ErrAlreadyInParty = errors.New("player is already in a party")
+
+	// ErrNotInParty is returned when a player leaves a party they are not a member of
+	ErrNotInParty = errors.New("player is not in the party")
+
+	// ErrNotInvited is returned when a player joins a party without an invite
+	ErrNotInvited = errors.New("player has not been invited to the party")
+
+	// ErrPartyFull is returned when a party already has the maximum number of members
+	ErrPartyFull = errors.New("party is full")
+
+	// ErrPartyQueued is returned when changing a party while it is queued for a game
+	ErrPartyQueued = errors.New("party is queued for a game")
+
+	// ErrPartyTooLarge is returned when queueing a party that doesn't fit into one of the game mode's teams
+	ErrPartyTooLarge = errors.New("party is too large for the game mode's teams")
+)
+
+// Party is a group of players that are matched into the same game, on the same team.
+// The leader manages the party and queues it for games.
+type Party struct {
+	PartyUUID  string    `json:"partyUUID"`
+	LeaderUUID string    `json:"leaderUUID"`
+	Created    time.Time `json:"created"`
+	Members    []string  `json:"members"`
+	Invites    []string  `json:"invites"`
+}
+
+// unit is a private helper type for players that are matched together: a party, or a single player
+type unit struct {
+	players []Player
+}
+
+// rating returns the average rating of the unit's players
+func (u unit) rating() float64 {
+	if len(u.players) == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, p := range u.players {
+		total += p.Rating
+	}
+
+	return total / float64(len(u.players))
+}
+
+// groupUnits is a private helper that groups players into units, one for each party and one for each player
+// without a party. Parties with a member in a game are left out, since the party can only be matched together.
+// Units are in the order their first player is found.
+func groupUnits(players []Player) []unit {
+	var units []unit
+	parties := map[string]int{}
+	busy := map[string]bool{}
+
+	for _, p := range players {
+		if !p.Party.Valid {
+			if p.Current_game == "" {
+				units = append(units, unit{players: []Player{p}})
+			}
+			continue
+		}
+
+		if p.Current_game != "" {
+			busy[p.Party.StringVal] = true
+		}
+
+		i, ok := parties[p.Party.StringVal]
+		if !ok {
+			i = len(units)
+			parties[p.Party.StringVal] = i
+			units = append(units, unit{})
+		}
+		units[i].players = append(units[i].players, p)
+	}
+
+	var idle []unit
+	for _, u := range units {
+		if len(u.players) > 0 && !busy[u.players[0].Party.StringVal] {
+			idle = append(idle, u)
+		}
+	}
+
+	return idle
+}
+
+// sortByRating is a private helper that orders units by how close their ratings are to target, closest first
+func sortByRating(units []unit, target float64) {
+	sort.SliceStable(units, func(a, b int) bool {
+		return math.Abs(units[a].rating()-target) < math.Abs(units[b].rating()-target)
+	})
+}
+
+// planGame is a private helper that fills a game of the mode, starting with the anchor's unit.
+// Candidate units whose average rating is between low and high are added, closest to the anchor's rating first,
+// as long as they still fit into the game's teams. Returns the game's players and their teams, which are empty
+// if the anchor itself doesn't fit.
+func planGame(anchor unit, candidates []unit, low float64, high float64, mode config.GameModeConfig) ([]Player, []int64) {
+	var eligible []unit
+	for _, u := range candidates {
+		if r := u.rating(); r >= low && r <= high {
+			eligible = append(eligible, u)
+		}
+	}
+
+	sortByRating(eligible, anchor.rating())
+
+	units := []unit{anchor}
+	unitTeams, ok := assignTeams(units, mode)
+	if !ok || len(anchor.players) > mode.Max_players {
+		return nil, nil
+	}
+
+	count := len(anchor.players)
+	for _, u := range eligible {
+		if count+len(u.players) > mode.Max_players {
+			continue
+		}
+
+		teams, ok := assignTeams(append(units[:len(units):len(units)], u), mode)
+		if !ok {
+			continue
+		}
+
+		units = append(units, u)
+		unitTeams = teams
+		count += len(u.players)
+	}
+
+	var players []Player
+	var teams []int64
+	for i, u := range units {
+		for _, p := range u.players {
+			players = append(players, p)
+			teams = append(teams, unitTeams[i])
+		}
+	}
+
+	return players, teams
+}
+
+// getPartyMembers is a private helper that returns the members of the parties, with their ratings and current games
+func getPartyMembers(ctx context.Context, txn querier, parties []string) ([]Player, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT playerUUID, rating, current_game, party FROM players@{FORCE_INDEX=PlayerParty}
+				WHERE party IN UNNEST(@parties) ORDER BY party, playerUUID`,
+		Params: map[string]interface{}{
+			"parties": parties,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetPartyMembers"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Player
+	for _, row := range rows {
+		var p Player
+		var currentGame spanner.NullString
+		if err := row.Columns(&p.PlayerUUID, &p.Rating, &currentGame, &p.Party); err != nil {
+			return nil, err
+		}
+		p.Current_game = currentGame.StringVal
+
+		members = append(members, p)
+	}
+
+	return members, nil
+}
+
+// getPlayerParty is a private helper that returns the party the player is in, if any.
+// Returns ErrPlayerNotFound if the player does not exist.
+func getPlayerParty(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (spanner.NullString, error) {
+	var party spanner.NullString
+
+	row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"party"},
+		&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetPlayerParty"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return party, ErrPlayerNotFound
+	}
+	if err != nil {
+		return party, err
+	}
+
+	if err := row.Column(0, &party); err != nil {
+		return party, err
+	}
+
+	return party, nil
+}
+
+// getPartyLeader is a private helper that returns the party's leader.
+// Returns ErrPartyNotFound if the party does not exist.
+func getPartyLeader(ctx context.Context, txn *spanner.ReadWriteTransaction, partyUUID string) (string, error) {
+	row, err := txn.ReadRowWithOptions(ctx, "parties", spanner.Key{partyUUID}, []string{"leaderUUID"},
+		&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetPartyLeader"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return "", ErrPartyNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var leader string
+	if err := row.Column(0, &leader); err != nil {
+		return "", err
+	}
+
+	return leader, nil
+}
+
+// partyQueued is a private helper that reports whether the party has queued tickets
+func partyQueued(ctx context.Context, txn *spanner.ReadWriteTransaction, partyUUID string) (bool, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT ticketUUID FROM matchmaking_tickets@{FORCE_INDEX=TicketParty}
+				WHERE party=@party AND status=@status LIMIT 1`,
+		Params: map[string]interface{}{
+			"party":  partyUUID,
+			"status": TicketQueued,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetPartyTickets"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return false, err
+	}
+
+	return len(rows) > 0, nil
+}
+
+// playersQueued is a private helper that reports whether any of the players has a queued ticket
+func playersQueued(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUIDs []string) (bool, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT ticketUUID FROM matchmaking_tickets
+				WHERE playerUUID IN UNNEST(@players) AND status=@status LIMIT 1`,
+		Params: map[string]interface{}{
+			"players": playerUUIDs,
+			"status":  TicketQueued,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetQueuedTicket"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return false, err
+	}
+
+	return len(rows) > 0, nil
+}
+
+// CreateParty creates a new party led by the player.
+// Returns ErrPlayerNotFound, ErrAlreadyInParty or ErrAlreadyQueued if the player can't start a party.
+func CreateParty(ctx context.Context, client spanner.Client, playerUUID string) (Party, error) {
+	var p Party
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		party, err := getPlayerParty(ctx, txn, playerUUID)
+		if err != nil {
+			return err
+		}
+
+		if party.Valid {
+			return ErrAlreadyInParty
+		}
+
+		queued, err := playersQueued(ctx, txn, []string{playerUUID})
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrAlreadyQueued
+		}
+
+		p = Party{
+			PartyUUID:  generateUUID(),
+			LeaderUUID: playerUUID,
+			Created:    time.Now(),
+			Members:    []string{playerUUID},
+			Invites:    []string{},
+		}
+
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Insert("parties", []string{"partyUUID", "leaderUUID", "created"}, []interface{}{p.PartyUUID, p.LeaderUUID, p.Created}),
+			spanner.Update("players", []string{"playerUUID", "party"}, []interface{}{playerUUID, p.PartyUUID}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=create_party"})
+
+	if err != nil {
+		return Party{}, err
+	}
+
+	return p, nil
+}
+
+// InviteToParty invites a player to join the party. Only the party's leader can invite players.
+// Returns ErrPartyNotFound, ErrNotPartyLeader, ErrPlayerNotFound, ErrAlreadyInParty or ErrPartyFull if the player can't be invited.
+func InviteToParty(ctx context.Context, client spanner.Client, c config.PartyConfig, partyUUID string, leaderUUID string, playerUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		leader, err := getPartyLeader(ctx, txn, partyUUID)
+		if err != nil {
+			return err
+		}
+
+		if leader != leaderUUID {
+			return ErrNotPartyLeader
+		}
+
+		party, err := getPlayerParty(ctx, txn, playerUUID)
+		if err != nil {
+			return err
+		}
+
+		if party.StringVal == partyUUID {
+			return ErrAlreadyInParty
+		}
+
+		members, err := getPartyMembers(ctx, txn, []string{partyUUID})
+		if err != nil {
+			return err
+		}
+
+		if len(members) >= c.Max_size {
+			return ErrPartyFull
+		}
+
+		cols := []string{"partyUUID", "playerUUID", "created"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.InsertOrUpdate("party_invites", cols, []interface{}{partyUUID, playerUUID, time.Now()}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=invite_to_party"})
+
+	return err
+}
+
+// JoinParty adds an invited player to the party, and uses up their invite.
+// Returns ErrPartyNotFound, ErrNotInvited, ErrPlayerNotFound, ErrAlreadyInParty, ErrAlreadyQueued, ErrPartyQueued
+// or ErrPartyFull if the player can't join.
+func JoinParty(ctx context.Context, client spanner.Client, c config.PartyConfig, partyUUID string, playerUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if _, err := getPartyLeader(ctx, txn, partyUUID); err != nil {
+			return err
+		}
+
+		_, err := txn.ReadRowWithOptions(ctx, "party_invites", spanner.Key{partyUUID, playerUUID}, []string{"created"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetPartyInvite"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrNotInvited
+		}
+		if err != nil {
+			return err
+		}
+
+		party, err := getPlayerParty(ctx, txn, playerUUID)
+		if err != nil {
+			return err
+		}
+
+		if party.Valid {
+			return ErrAlreadyInParty
+		}
+
+		queued, err := playersQueued(ctx, txn, []string{playerUUID})
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrAlreadyQueued
+		}
+
+		queued, err = partyQueued(ctx, txn, partyUUID)
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrPartyQueued
+		}
+
+		members, err := getPartyMembers(ctx, txn, []string{partyUUID})
+		if err != nil {
+			return err
+		}
+
+		if len(members) >= c.Max_size {
+			return ErrPartyFull
+		}
+
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Delete("party_invites", spanner.Key{partyUUID, playerUUID}),
+			spanner.Update("players", []string{"playerUUID", "party"}, []interface{}{playerUUID, partyUUID}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=join_party"})
+
+	return err
+}
+
+// LeaveParty removes the player from the party. When the leader leaves, the remaining member with the lowest
+// playerUUID leads the party, and the party is removed once its last member leaves.
+// Returns ErrPartyNotFound, ErrPlayerNotFound, ErrNotInParty or ErrPartyQueued if the player can't leave.
+func LeaveParty(ctx context.Context, client spanner.Client, partyUUID string, playerUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		leader, err := getPartyLeader(ctx, txn, partyUUID)
+		if err != nil {
+			return err
+		}
+
+		party, err := getPlayerParty(ctx, txn, playerUUID)
+		if err != nil {
+			return err
+		}
+
+		if party.StringVal != partyUUID {
+			return ErrNotInParty
+		}
+
+		queued, err := partyQueued(ctx, txn, partyUUID)
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrPartyQueued
+		}
+
+		members, err := getPartyMembers(ctx, txn, []string{partyUUID})
+		if err != nil {
+			return err
+		}
+
+		var remaining []string
+		for _, m := range members {
+			if m.PlayerUUID != playerUUID {
+				remaining = append(remaining, m.PlayerUUID)
+			}
+		}
+
+		m := []*spanner.Mutation{
+			spanner.Update("players", []string{"playerUUID", "party"}, []interface{}{playerUUID, spanner.NullString{}}),
+		}
+
+		switch {
+		case len(remaining) == 0:
+			m = append(m, spanner.Delete("parties", spanner.Key{partyUUID}))
+		case leader == playerUUID:
+			m = append(m, spanner.Update("parties", []string{"partyUUID", "leaderUUID"}, []interface{}{partyUUID, remaining[0]}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=leave_party"})
+
+	return err
+}
+
+// DisbandParty removes all members from the party and deletes it, along with its invites.
+// Only the party's leader can disband it.
+// Returns ErrPartyNotFound, ErrNotPartyLeader or ErrPartyQueued if the party can't be disbanded.
+func DisbandParty(ctx context.Context, client spanner.Client, partyUUID string, leaderUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		leader, err := getPartyLeader(ctx, txn, partyUUID)
+		if err != nil {
+			return err
+		}
+
+		if leader != leaderUUID {
+			return ErrNotPartyLeader
+		}
+
+		queued, err := partyQueued(ctx, txn, partyUUID)
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrPartyQueued
+		}
+
+		members, err := getPartyMembers(ctx, txn, []string{partyUUID})
+		if err != nil {
+			return err
+		}
+
+		var m []*spanner.Mutation
+		for _, p := range members {
+			m = append(m, spanner.Update("players", []string{"playerUUID", "party"}, []interface{}{p.PlayerUUID, spanner.NullString{}}))
+		}
+		m = append(m, spanner.Delete("parties", spanner.Key{partyUUID}))
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=disband_party"})
+
+	return err
+}
+
+// GetParty returns the party with its members and pending invites.
+// Returns ErrPartyNotFound if the party does not exist.
+func GetParty(ctx context.Context, client spanner.Client, partyUUID string) (Party, error) {
+	txn := client.ReadOnlyTransaction()
+	defer txn.Close()
+
+	row, err := txn.ReadRowWithOptions(ctx, "parties", spanner.Key{partyUUID}, []string{"leaderUUID", "created"},
+		&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetParty"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return Party{}, ErrPartyNotFound
+	}
+	if err != nil {
+		return Party{}, err
+	}
+
+	p := Party{PartyUUID: partyUUID, Members: []string{}, Invites: []string{}}
+	if err := row.Columns(&p.LeaderUUID, &p.Created); err != nil {
+		return Party{}, err
+	}
+
+	members, err := getPartyMembers(ctx, txn, []string{partyUUID})
+	if err != nil {
+		return Party{}, err
+	}
+
+	for _, m := range members {
+		p.Members = append(p.Members, m.PlayerUUID)
+	}
+
+	stmt := spanner.Statement{
+		SQL: `SELECT playerUUID FROM party_invites WHERE partyUUID=@party ORDER BY created`,
+		Params: map[string]interface{}{
+			"party": partyUUID,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetPartyInvites"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return Party{}, err
+	}
+
+	for _, row := range rows {
+		var invitee string
+		if err := row.Column(0, &invitee); err != nil {
+			return Party{}, err
+		}
+
+		p.Invites = append(p.Invites, invitee)
+	}
+
+	return p, nil
+}
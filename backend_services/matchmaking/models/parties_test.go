@@ -0,0 +1,76 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupUnits(t *testing.T) {
+	partyA := spanner.NullString{StringVal: "a", Valid: true}
+	partyB := spanner.NullString{StringVal: "b", Valid: true}
+	players := []Player{
+		{PlayerUUID: "1", Rating: 1500},
+		{PlayerUUID: "2", Rating: 1400, Party: partyA},
+		{PlayerUUID: "3", Rating: 1600, Party: partyB},
+		{PlayerUUID: "4", Rating: 1600, Party: partyA},
+		{PlayerUUID: "5", Rating: 1700, Party: partyB, Current_game: "game"},
+		{PlayerUUID: "6", Rating: 1500, Current_game: "game"},
+	}
+
+	units := groupUnits(players)
+
+	// Party b has a member in a game, and so does player 6
+	assert.Len(t, units, 2)
+	assert.Len(t, units[0].players, 1)
+	assert.Len(t, units[1].players, 2)
+	assert.Equal(t, 1500.0, units[1].rating())
+}
+
+func TestPlanGame(t *testing.T) {
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 4, Teams: 2, Team_size: 2}
+	party := spanner.NullString{StringVal: "a", Valid: true}
+
+	anchor := unit{players: []Player{{PlayerUUID: "1", Rating: 1500, Party: party}, {PlayerUUID: "2", Rating: 1500, Party: party}}}
+	candidates := []unit{
+		{players: []Player{{PlayerUUID: "3", Rating: 1900}}},
+		{players: []Player{{PlayerUUID: "4", Rating: 1520}}},
+		{players: []Player{{PlayerUUID: "5", Rating: 1400}, {PlayerUUID: "6", Rating: 1400}, {PlayerUUID: "7", Rating: 1400}}},
+		{players: []Player{{PlayerUUID: "8", Rating: 1450}}},
+		{players: []Player{{PlayerUUID: "9", Rating: 1440}}},
+	}
+
+	players, teams := planGame(anchor, candidates, 1300, 1700, mode)
+
+	// 3 is outside the window, and the party of three doesn't fit into a team
+	var uuids []string
+	for _, p := range players {
+		uuids = append(uuids, p.PlayerUUID)
+	}
+	assert.Equal(t, []string{"1", "2", "4", "8"}, uuids)
+	assert.Equal(t, teams[0], teams[1])
+	assert.Equal(t, teams[2], teams[3])
+	assert.NotEqual(t, teams[0], teams[2])
+
+	// An anchor that doesn't fit into a team can't start a game
+	players, _ = planGame(candidates[2], candidates, 1300, 1700, mode)
+	assert.Empty(t, players)
+}
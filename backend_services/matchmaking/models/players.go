@@ -24,7 +24,10 @@ type PlayerStats struct {
 
 // Player maps to the fields required by a game's players
 type Player struct {
-	PlayerUUID   string           `json:"playerUUID"`
-	Stats        spanner.NullJSON `json:"stats"`
-	Current_game string           `json:"current_game"`
+	PlayerUUID       string             `json:"playerUUID"`
+	Stats            spanner.NullJSON   `json:"stats"`
+	Current_game     string             `json:"current_game"`
+	Rating           float64            `json:"rating"`
+	Rating_deviation float64            `json:"rating_deviation"`
+	Party            spanner.NullString `json:"party"`
 }
@@ -0,0 +1,593 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/allocator"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/rating"
+	"google.golang.org/grpc/codes"
+)
+
+// Ticket statuses
+const (
+	TicketQueued    = "queued"
+	TicketMatched   = "matched"
+	TicketCancelled = "cancelled"
+	TicketExpired   = "expired"
+)
+
+// formResult is the outcome of trying to form a game around one anchor ticket
+type formResult int
+
+const (
+	// queueDrained means there are no queued tickets left to try as the anchor
+	queueDrained formResult = iota
+
+	// gameFormed means a game was formed around the anchor
+	gameFormed
+
+	// anchorSkipped means no game can be formed around the anchor yet, so it stays queued
+	anchorSkipped
+
+	// anchorCancelled means the anchor was for a mode that is no longer configured, so it was cancelled
+	anchorCancelled
+)
+
+// MatchReport counts what the matchmaking worker did in one run
+type MatchReport struct {
+	Formed    int
+	Skipped   int
+	Cancelled int
+	Expired   int64
+}
+
+var (
+	// ErrPlayerNotFound is returned when queueing a player that does not exist
+	ErrPlayerNotFound = errors.New("player not found")
+
+	// ErrPlayerInGame is returned when queueing a player that is already in a game
+	ErrPlayerInGame = errors.New("player is already in a game")
+
+	// ErrAlreadyQueued is returned when queueing a player that already has a queued ticket
+	ErrAlreadyQueued = errors.New("player is already queued")
+
+	// ErrTicketNotFound is returned when a player has no matching ticket
+	ErrTicketNotFound = errors.New("ticket not found")
+)
+
+// Ticket is a player's request to be matched into a game.
+// Once the ticket is matched, GameUUID holds the game the player was placed in.
+// Party is set when the player was queued with their party, and Regions holds the regions the player can be matched in.
+type Ticket struct {
+	TicketUUID string             `json:"ticketUUID"`
+	PlayerUUID string             `json:"playerUUID"`
+	Status     string             `json:"status"`
+	Mode       spanner.NullString `json:"mode"`
+	GameUUID   spanner.NullString `json:"gameUUID"`
+	Party      spanner.NullString `json:"party"`
+	Regions    []string           `json:"regions"`
+	Created    time.Time          `json:"created"`
+	Updated    spanner.NullTime   `json:"updated"`
+}
+
+// queuedTicket is a private helper type for the tickets read while forming a game
+type queuedTicket struct {
+	PlayerUUID string
+	TicketUUID string
+	Rating     float64
+	Mode       spanner.NullString
+	Party      spanner.NullString
+	Regions    []string
+	Created    time.Time
+}
+
+// readyToForm is a private helper that reports whether a game with count matched players should be formed,
+// when its oldest ticket has waited for waited. Full games are always formed. Partial games are formed with
+// at least the mode's minimum players once the fill timeout has passed.
+func readyToForm(count int, waited time.Duration, mode config.GameModeConfig, c config.QueueConfig) bool {
+	if count >= mode.Max_players {
+		return true
+	}
+
+	return count >= mode.Min_players && waited >= c.Fill_timeout
+}
+
+// Enqueue creates a ticket for the player to be matched into a game of the mode by the matchmaking worker.
+// The default mode is used when mode is empty. The player's current rating is stored with the ticket.
+// A party is queued by its leader, which creates a ticket for every member with the party's average rating,
+// so the worker matches the members together.
+// When regions are configured, the player's pings to each region, in milliseconds, decide the regions the ticket can be
+// matched in. A party is matched in the regions of its leader's pings.
+// Returns ErrUnknownMode, ErrNoRegion, ErrPlayerNotFound, ErrPlayerInGame, ErrAlreadyQueued, ErrNotPartyLeader or
+// ErrPartyTooLarge if the player can't be queued.
+func Enqueue(ctx context.Context, client spanner.Client, c config.Config, playerUUID string, mode string, pings map[string]int64) (Ticket, error) {
+	var t Ticket
+
+	name, gameMode, err := gameModeConfig(c.Game, mode)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	regions := eligibleRegions(pings, c.Region)
+	if len(c.Region.Names) > 0 && len(regions) == 0 {
+		return Ticket{}, ErrNoRegion
+	}
+
+	_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"current_game", "rating", "party"},
+			&spanner.ReadOptions{RequestTag: "app=matchmaking,action=GetQueuePlayer"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var currentGame spanner.NullString
+		player := Player{PlayerUUID: playerUUID}
+		if err := row.Columns(&currentGame, &player.Rating, &player.Party); err != nil {
+			return err
+		}
+		player.Current_game = currentGame.StringVal
+
+		members := []Player{player}
+		if player.Party.Valid {
+			leader, err := getPartyLeader(ctx, txn, player.Party.StringVal)
+			if err != nil {
+				return err
+			}
+
+			if leader != playerUUID {
+				return ErrNotPartyLeader
+			}
+
+			if members, err = getPartyMembers(ctx, txn, []string{player.Party.StringVal}); err != nil {
+				return err
+			}
+
+			if len(members) > gameMode.Team_size {
+				return ErrPartyTooLarge
+			}
+		}
+
+		var playerUUIDs []string
+		for _, m := range members {
+			if m.Current_game != "" {
+				return ErrPlayerInGame
+			}
+
+			playerUUIDs = append(playerUUIDs, m.PlayerUUID)
+		}
+
+		queued, err := playersQueued(ctx, txn, playerUUIDs)
+		if err != nil {
+			return err
+		}
+
+		if queued {
+			return ErrAlreadyQueued
+		}
+
+		// party members share the party's rating, so they are matched as one
+		ticketRating := unit{players: members}.rating()
+		now := time.Now()
+
+		cols := []string{"playerUUID", "ticketUUID", "status", "rating", "mode", "party", "regions", "created"}
+		var m []*spanner.Mutation
+		for _, member := range members {
+			ticket := Ticket{
+				TicketUUID: generateUUID(),
+				PlayerUUID: member.PlayerUUID,
+				Status:     TicketQueued,
+				Mode:       spanner.NullString{StringVal: name, Valid: true},
+				Party:      player.Party,
+				Regions:    regions,
+				Created:    now,
+			}
+			if member.PlayerUUID == playerUUID {
+				t = ticket
+			}
+
+			m = append(m, spanner.Insert("matchmaking_tickets", cols,
+				[]interface{}{ticket.PlayerUUID, ticket.TicketUUID, ticket.Status, ticketRating, ticket.Mode, ticket.Party, ticket.Regions, ticket.Created}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=enqueue"})
+
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	return t, nil
+}
+
+// CancelTicket removes the player from the queue by cancelling their queued ticket.
+// If the player was queued with their party, the whole party's tickets are cancelled.
+// Returns ErrTicketNotFound if the player has no queued ticket, for example because it was already matched.
+func CancelTicket(ctx context.Context, client spanner.Client, playerUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: `UPDATE matchmaking_tickets SET status=@cancelled, updated=CURRENT_TIMESTAMP()
+					WHERE status=@queued AND (playerUUID=@playerUUID OR party IN (
+						SELECT party FROM matchmaking_tickets WHERE playerUUID=@playerUUID AND status=@queued AND party IS NOT NULL))`,
+			Params: map[string]interface{}{
+				"playerUUID": playerUUID,
+				"cancelled":  TicketCancelled,
+				"queued":     TicketQueued,
+			},
+		}
+
+		count, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=CancelTicket"})
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return ErrTicketNotFound
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=cancel_ticket"})
+
+	return err
+}
+
+// GetTicket returns the player's most recent ticket, so clients can poll for the game they were matched into.
+// Returns ErrTicketNotFound if the player has no tickets.
+func GetTicket(ctx context.Context, client spanner.Client, playerUUID string) (Ticket, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT ticketUUID, playerUUID, status, mode, gameUUID, party, regions, created, updated FROM matchmaking_tickets
+				WHERE playerUUID=@playerUUID ORDER BY created DESC LIMIT 1`,
+		Params: map[string]interface{}{
+			"playerUUID": playerUUID,
+		},
+	}
+
+	iter := client.Single().QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetTicket"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return Ticket{}, err
+	}
+
+	if len(rows) == 0 {
+		return Ticket{}, ErrTicketNotFound
+	}
+
+	var t Ticket
+	if err := rows[0].ToStruct(&t); err != nil {
+		return Ticket{}, err
+	}
+
+	return t, nil
+}
+
+// getQueuedParties is a private helper that returns the queued tickets of the parties' members, keyed by player,
+// along with the members themselves so they can be grouped into units.
+func getQueuedParties(ctx context.Context, txn *spanner.ReadWriteTransaction, parties []string) (map[string]queuedTicket, []Player, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT t.playerUUID, t.ticketUUID, t.rating, t.mode, t.party, t.regions, t.created, p.current_game
+				FROM matchmaking_tickets@{FORCE_INDEX=TicketParty} t
+				JOIN players p ON p.playerUUID = t.playerUUID
+				WHERE t.party IN UNNEST(@parties) AND t.status=@status`,
+		Params: map[string]interface{}{
+			"parties": parties,
+			"status":  TicketQueued,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetPartyTickets"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tickets := map[string]queuedTicket{}
+	var members []Player
+	for _, row := range rows {
+		var t queuedTicket
+		var currentGame spanner.NullString
+		if err := row.Columns(&t.PlayerUUID, &t.TicketUUID, &t.Rating, &t.Mode, &t.Party, &t.Regions, &t.Created, &currentGame); err != nil {
+			return nil, nil, err
+		}
+
+		tickets[t.PlayerUUID] = t
+		members = append(members, Player{PlayerUUID: t.PlayerUUID, Rating: t.Rating, Party: t.Party, Current_game: currentGame.StringVal})
+	}
+
+	return tickets, members, nil
+}
+
+// formGameFromQueue is a private helper that tries to form one game from queued tickets.
+// A random ticket from the oldest queued tickets of players that weren't tried yet is chosen, and the game is filled with the queued tickets for the
+// same mode whose ratings are closest to it, within the rating window for how long it has waited. Tickets for players that are
+// already in a game are skipped until that game is closed. Parties are matched as one unit on the same team, once none
+// of their members are in a game. When the chosen ticket has regions, the game is formed in the one of its regions
+// where the most tickets can play. When the bot policy is turned on and the ticket has waited long enough, the rest of
+// the game is filled with bots. Once the game is formed, a game server is allocated for it.
+// Returns what happened to the chosen ticket, along with the players whose tickets were tried when it was skipped.
+func formGameFromQueue(ctx context.Context, client spanner.Client, c config.Config, a allocator.Allocator, tried []string) (formResult, []string, error) {
+	window := rating.Window{Initial: c.Rating.Window_initial, Growth: c.Rating.Window_growth, Max: c.Rating.Window_max}
+	var result formResult
+	var skipped []string
+	var g Game
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		result = queueDrained
+		skipped = nil
+		g = Game{}
+
+		// Retrieve a random ticket from 10 of the oldest tickets to reduce contention between workers
+		stmt := spanner.Statement{
+			SQL: `SELECT playerUUID, ticketUUID, rating, mode, party, regions, created FROM (
+					SELECT t.playerUUID, t.ticketUUID, t.rating, t.mode, t.party, t.regions, t.created FROM matchmaking_tickets t
+					JOIN players p ON p.playerUUID = t.playerUUID
+					WHERE t.status=@status AND p.current_game IS NULL AND t.playerUUID NOT IN UNNEST(@tried)
+					ORDER BY t.created LIMIT 10
+					) TABLESAMPLE RESERVOIR (1 ROWS)`,
+			Params: map[string]interface{}{
+				"status": TicketQueued,
+				"tried":  tried,
+			},
+		}
+		iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetAnchorTicket"})
+		rows, err := readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		if len(rows) == 0 {
+			return nil
+		}
+
+		var anchor queuedTicket
+		if err := rows[0].ToStruct(&anchor); err != nil {
+			return err
+		}
+
+		// Tickets for a mode that is no longer configured can't be matched, so they are cancelled
+		name, mode, err := gameModeConfig(c.Game, anchor.Mode.StringVal)
+		if errors.Is(err, ErrUnknownMode) {
+			cols := []string{"playerUUID", "ticketUUID", "status", "updated"}
+			err := txn.BufferWrite([]*spanner.Mutation{
+				spanner.Update("matchmaking_tickets", cols, []interface{}{anchor.PlayerUUID, anchor.TicketUUID, TicketCancelled, time.Now()}),
+			})
+			if err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+
+			result = anchorCancelled
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// the anchor's whole party is matched with them
+		tickets := map[string]queuedTicket{anchor.PlayerUUID: anchor}
+		anchorPlayers := []Player{{PlayerUUID: anchor.PlayerUUID, Rating: anchor.Rating}}
+		if anchor.Party.Valid {
+			if tickets, anchorPlayers, err = getQueuedParties(ctx, txn, []string{anchor.Party.StringVal}); err != nil {
+				return err
+			}
+		}
+
+		// the anchor's whole party is skipped with them when no game can be formed
+		result = anchorSkipped
+		for playerUUID := range tickets {
+			skipped = append(skipped, playerUUID)
+		}
+
+		anchorUnits := groupUnits(anchorPlayers)
+		if len(anchorUnits) == 0 {
+			return nil
+		}
+
+		var anchorUUIDs []string
+		for _, p := range anchorUnits[0].players {
+			anchorUUIDs = append(anchorUUIDs, p.PlayerUUID)
+		}
+
+		waited := time.Since(anchor.Created)
+		low, high := window.Bounds(anchor.Rating, waited)
+
+		// Only tickets sharing a region with the anchor are read, or all tickets when the anchor has no regions.
+		// Extra tickets are read, since some belong to parties that don't fit into the game
+		regions := anchor.Regions
+		if regions == nil {
+			regions = []string{}
+		}
+
+		stmt = spanner.Statement{
+			SQL: `SELECT t.playerUUID, t.ticketUUID, t.rating, t.mode, t.party, t.regions, t.created FROM matchmaking_tickets@{FORCE_INDEX=TicketStatus} t
+					JOIN players p ON p.playerUUID = t.playerUUID
+					WHERE t.status=@status AND t.mode=@mode AND t.rating BETWEEN @low AND @high
+					AND t.playerUUID NOT IN UNNEST(@anchor) AND p.current_game IS NULL
+					AND (ARRAY_LENGTH(@regions) = 0 OR EXISTS (SELECT r FROM UNNEST(t.regions) AS r WHERE r IN UNNEST(@regions)))
+					ORDER BY ABS(t.rating - @rating), t.created LIMIT @limit`,
+			Params: map[string]interface{}{
+				"status":  TicketQueued,
+				"mode":    name,
+				"low":     low,
+				"high":    high,
+				"anchor":  anchorUUIDs,
+				"regions": regions,
+				"rating":  anchor.Rating,
+				"limit":   mode.Max_players * 2,
+			},
+		}
+		iter = txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetMatchingTickets"})
+		rows, err = readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		var candidates []Player
+		var parties []string
+		for _, row := range rows {
+			var t queuedTicket
+			if err := row.ToStruct(&t); err != nil {
+				return err
+			}
+
+			// party tickets are read with their whole party below
+			if t.Party.Valid {
+				parties = append(parties, t.Party.StringVal)
+				continue
+			}
+
+			tickets[t.PlayerUUID] = t
+			candidates = append(candidates, Player{PlayerUUID: t.PlayerUUID, Rating: t.Rating})
+		}
+
+		if len(parties) > 0 {
+			partyTickets, members, err := getQueuedParties(ctx, txn, parties)
+			if err != nil {
+				return err
+			}
+
+			for playerUUID, t := range partyTickets {
+				tickets[playerUUID] = t
+			}
+			candidates = append(candidates, members...)
+		}
+
+		players, teams, region := planRegionalGame(anchorUnits[0], groupUnits(candidates), tickets, anchor.Regions, low, high, mode)
+
+		// Off-peak, the game's open slots are filled with bots once the players have waited long enough
+		var botTeams []int64
+		if bots := botSlots(len(players), waited, mode, c.Bot); bots > 0 {
+			botTeams = planBots(players, teams, bots, mode)
+		}
+
+		if len(botTeams) == 0 && !readyToForm(len(players), waited, mode, c.Queue) {
+			return nil
+		}
+
+		g = Game{GameUUID: generateUUID(), Mode: name, Region: region}
+		if err := g.assignPlayers(txn, players, teams); err != nil {
+			return err
+		}
+
+		if len(botTeams) > 0 {
+			if err := g.addBots(txn, botTeams); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		cols := []string{"playerUUID", "ticketUUID", "status", "gameUUID", "updated"}
+		var m []*spanner.Mutation
+		for _, p := range players {
+			t := tickets[p.PlayerUUID]
+			m = append(m, spanner.Update("matchmaking_tickets", cols, []interface{}{t.PlayerUUID, t.TicketUUID, TicketMatched, g.GameUUID, now}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		result = gameFormed
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=form_game"})
+
+	if err != nil {
+		return queueDrained, nil, err
+	}
+
+	// Stop forming games when no game server could be allocated, since the tickets were queued again
+	if g.GameUUID != "" {
+		if err := g.allocateServer(ctx, client, a); err != nil {
+			return queueDrained, nil, err
+		}
+	}
+
+	return result, skipped, nil
+}
+
+// expireTickets is a private helper that expires the tickets that have been queued for longer than the ticket ttl,
+// so players that can't be matched are taken out of the queue. Returns the number of tickets expired.
+func expireTickets(ctx context.Context, client spanner.Client, c config.QueueConfig) (int64, error) {
+	var count int64
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: `UPDATE matchmaking_tickets SET status=@expired, updated=CURRENT_TIMESTAMP()
+					WHERE status=@queued AND created < @cutoff`,
+			Params: map[string]interface{}{
+				"expired": TicketExpired,
+				"queued":  TicketQueued,
+				"cutoff":  time.Now().Add(-c.Ticket_ttl),
+			},
+		}
+
+		var err error
+		count, err = txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=ExpireTickets"})
+		return err
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=expire_tickets"})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// MatchTickets expires the tickets queued for longer than the ticket ttl, then forms games from the queued tickets
+// until every queued ticket was tried as the anchor of a game. Tickets that no game can be formed around yet are
+// skipped for the rest of the run, so they don't hold up the tickets queued after them.
+// Each game is formed in its own transaction, so several workers can match tickets at the same time.
+func MatchTickets(ctx context.Context, client spanner.Client, c config.Config, a allocator.Allocator) (MatchReport, error) {
+	var report MatchReport
+
+	if c.Queue.Ticket_ttl > 0 {
+		expired, err := expireTickets(ctx, client, c.Queue)
+		if err != nil {
+			return report, err
+		}
+		report.Expired = expired
+	}
+
+	tried := []string{}
+	for {
+		result, skipped, err := formGameFromQueue(ctx, client, c, a, tried)
+		if err != nil {
+			return report, err
+		}
+
+		switch result {
+		case queueDrained:
+			return report, nil
+		case gameFormed:
+			report.Formed++
+		case anchorSkipped:
+			report.Skipped++
+			tried = append(tried, skipped...)
+		case anchorCancelled:
+			report.Cancelled++
+		}
+	}
+}
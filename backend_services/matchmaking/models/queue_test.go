@@ -0,0 +1,46 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadyToForm(t *testing.T) {
+	c := config.QueueConfig{Fill_timeout: 30 * time.Second}
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 10, Teams: 2, Team_size: 5}
+
+	var tests = []struct {
+		count  int
+		waited time.Duration
+		want   bool
+	}{
+		{10, 0, true},
+		{9, 0, false},
+		{9, 30 * time.Second, true},
+		{2, time.Minute, true},
+		{1, time.Hour, false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, readyToForm(test.count, test.waited, mode, c), "count %d waited %s", test.count, test.waited)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+)
+
+// abandonedPlayer is a private helper type for the participants of a game being abandoned.
+// Bot participants have no player row, so their current game and rating are null.
+type abandonedPlayer struct {
+	GameUUID         string
+	PlayerUUID       string
+	Participant_type string
+	Leave_time       spanner.NullTime
+	Current_game     spanner.NullString
+	Rating           spanner.NullFloat64
+}
+
+// abandonGames is a private helper to abandon one batch of games created before the cutoff that were never closed.
+// The games are marked abandoned and finished, and the participants still in them, bots included, are given a leave time.
+// Players still locked into the games are released, and lose the penalty from their rating.
+// Returns the number of games abandoned.
+func abandonGames(ctx context.Context, client spanner.Client, cutoff time.Time, c config.ReaperConfig) (int, error) {
+	var count int
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: `SELECT gameUUID FROM games@{FORCE_INDEX=GameFinished}
+					WHERE finished IS NULL AND created < @cutoff LIMIT @limit`,
+			Params: map[string]interface{}{
+				"cutoff": cutoff,
+				"limit":  c.Batch_size,
+			},
+		}
+
+		iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetStaleGames"})
+		rows, err := readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		var gameUUIDs []string
+		for _, row := range rows {
+			var gameUUID string
+			if err := row.Columns(&gameUUID); err != nil {
+				return err
+			}
+
+			gameUUIDs = append(gameUUIDs, gameUUID)
+		}
+
+		count = len(gameUUIDs)
+		if count == 0 {
+			return nil
+		}
+
+		stmt = spanner.Statement{
+			SQL: `SELECT gp.gameUUID, gp.playerUUID, gp.participant_type, gp.leave_time, p.current_game, p.rating FROM game_participants gp
+					LEFT JOIN players p ON p.playerUUID = gp.playerUUID
+					WHERE gp.gameUUID IN UNNEST(@games)`,
+			Params: map[string]interface{}{
+				"games": gameUUIDs,
+			},
+		}
+
+		iter = txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=matchmaking,action=GetStaleGamePlayers"})
+		rows, err = readRows(iter)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var m []*spanner.Mutation
+		for _, gameUUID := range gameUUIDs {
+			m = append(m, spanner.Update("games", []string{"gameUUID", "finished", "status"}, []interface{}{gameUUID, now, GameAbandoned}))
+		}
+
+		for _, row := range rows {
+			var p abandonedPlayer
+			if err := row.ToStruct(&p); err != nil {
+				return err
+			}
+
+			// Players who already left are neither penalized nor released, since they may be in another game
+			if p.Leave_time.Valid {
+				continue
+			}
+
+			gpCols := []string{"gameUUID", "playerUUID", "leave_time"}
+			m = append(m, spanner.Update("game_participants", gpCols, []interface{}{p.GameUUID, p.PlayerUUID, now}))
+
+			// Bots have no player to release or penalize
+			if p.Participant_type == ParticipantBot || p.Current_game.StringVal != p.GameUUID {
+				continue
+			}
+
+			pCols := []string{"playerUUID", "current_game", "idle_since", "rating"}
+			m = append(m, spanner.Update("players", pCols, []interface{}{p.PlayerUUID, spanner.NullString{}, now, p.Rating.Float64 - c.Abandon_penalty}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=matchmaking,action=abandon_games"})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// AbandonStaleGames abandons every game that was created more than the maximum game duration ago and never closed,
+// so their players can be matched again. The games are abandoned in batches, each in its own transaction, so no
+// transaction holds locks on many games at once.
+// Returns the number of games abandoned.
+func AbandonStaleGames(ctx context.Context, client spanner.Client, c config.ReaperConfig) (int, error) {
+	cutoff := time.Now().Add(-c.Max_game_duration)
+	var total int
+
+	for {
+		count, err := abandonGames(ctx, client, cutoff, c)
+		total += count
+		if err != nil {
+			return total, err
+		}
+
+		if count < c.Batch_size {
+			return total, nil
+		}
+	}
+}
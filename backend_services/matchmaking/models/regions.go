@@ -0,0 +1,99 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+)
+
+// ErrNoRegion is returned when queueing a player whose pings to every region are over the latency threshold
+var ErrNoRegion = errors.New("no region is within the latency threshold")
+
+// eligibleRegions is a private helper that returns the configured regions where the player's ping, in milliseconds,
+// is within the latency threshold, lowest ping first. Regions without a ping are left out.
+func eligibleRegions(pings map[string]int64, c config.RegionConfig) []string {
+	var regions []string
+	for _, name := range c.Names {
+		ping, ok := pings[name]
+		if !ok || ping < 0 || time.Duration(ping)*time.Millisecond > c.Max_latency {
+			continue
+		}
+
+		regions = append(regions, name)
+	}
+
+	sort.SliceStable(regions, func(a, b int) bool {
+		return pings[regions[a]] < pings[regions[b]]
+	})
+
+	return regions
+}
+
+// unitInRegion is a private helper that reports whether every player in the unit can play in the region,
+// going by the regions on their tickets
+func unitInRegion(u unit, tickets map[string]queuedTicket, region string) bool {
+	for _, p := range u.players {
+		found := false
+		for _, r := range tickets[p.PlayerUUID].Regions {
+			if r == region {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// planRegionalGame is a private helper that plans a game in each of the regions, in order, with the candidate units
+// that can play there, and returns the game with the most players along with its region.
+// Without regions, the game is planned with every candidate and has no region.
+func planRegionalGame(anchor unit, candidates []unit, tickets map[string]queuedTicket, regions []string, low float64, high float64, mode config.GameModeConfig) ([]Player, []int64, string) {
+	if len(regions) == 0 {
+		players, teams := planGame(anchor, candidates, low, high, mode)
+		return players, teams, ""
+	}
+
+	var bestPlayers []Player
+	var bestTeams []int64
+	var bestRegion string
+	for _, region := range regions {
+		if !unitInRegion(anchor, tickets, region) {
+			continue
+		}
+
+		var eligible []unit
+		for _, u := range candidates {
+			if unitInRegion(u, tickets, region) {
+				eligible = append(eligible, u)
+			}
+		}
+
+		players, teams := planGame(anchor, eligible, low, high, mode)
+		if len(players) > len(bestPlayers) {
+			bestPlayers, bestTeams, bestRegion = players, teams, region
+		}
+	}
+
+	return bestPlayers, bestTeams, bestRegion
+}
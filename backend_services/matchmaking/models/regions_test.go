@@ -0,0 +1,69 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-matchmaking-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEligibleRegions(t *testing.T) {
+	c := config.RegionConfig{Names: []string{"us-east1", "us-west1", "europe-west1"}, Max_latency: 100 * time.Millisecond}
+
+	pings := map[string]int64{"us-west1": 80, "us-east1": 30, "europe-west1": 140, "asia-east1": 10}
+	assert.Equal(t, []string{"us-east1", "us-west1"}, eligibleRegions(pings, c))
+
+	assert.Empty(t, eligibleRegions(map[string]int64{"europe-west1": 101}, c))
+	assert.Empty(t, eligibleRegions(nil, c))
+	assert.Empty(t, eligibleRegions(pings, config.RegionConfig{Max_latency: time.Second}))
+}
+
+func TestPlanRegionalGame(t *testing.T) {
+	mode := config.GameModeConfig{Min_players: 2, Max_players: 4, Teams: 2, Team_size: 2}
+	tickets := map[string]queuedTicket{
+		"1": {PlayerUUID: "1", Regions: []string{"us-east1", "us-west1"}},
+		"2": {PlayerUUID: "2", Regions: []string{"us-east1"}},
+		"3": {PlayerUUID: "3", Regions: []string{"us-west1"}},
+		"4": {PlayerUUID: "4", Regions: []string{"us-west1", "europe-west1"}},
+	}
+
+	anchor := unit{players: []Player{{PlayerUUID: "1", Rating: 1500}}}
+	candidates := []unit{
+		{players: []Player{{PlayerUUID: "2", Rating: 1500}}},
+		{players: []Player{{PlayerUUID: "3", Rating: 1500}}},
+		{players: []Player{{PlayerUUID: "4", Rating: 1500}}},
+	}
+
+	// More players can play in us-west1, even though the anchor prefers us-east1
+	players, teams, region := planRegionalGame(anchor, candidates, tickets, []string{"us-east1", "us-west1"}, 1000, 2000, mode)
+	assert.Equal(t, "us-west1", region)
+	assert.Len(t, players, 3)
+	assert.Len(t, teams, 3)
+
+	// Ties go to the anchor's preferred region
+	players, _, region = planRegionalGame(anchor, candidates[:2], tickets, []string{"us-east1", "us-west1"}, 1000, 2000, mode)
+	assert.Equal(t, "us-east1", region)
+	assert.Len(t, players, 2)
+
+	// Without regions, every candidate can play
+	players, _, region = planRegionalGame(anchor, candidates, tickets, nil, 1000, 2000, mode)
+	assert.Equal(t, "", region)
+	assert.Len(t, players, 4)
+}
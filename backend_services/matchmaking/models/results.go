@@ -0,0 +1,149 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidResult is returned when a game's reported result doesn't match its roster
+var ErrInvalidResult = errors.New("invalid game result")
+
+// PlayerResult is a player's result in a game, as reported by the game server when the game is closed.
+// A lower placement is better, and players can share a placement.
+type PlayerResult struct {
+	PlayerUUID string  `json:"playerUUID"`
+	Placement  int64   `json:"placement"`
+	Score      float64 `json:"score"`
+}
+
+// team is a private helper that returns the player's team, or 0 if the game has no teams or the player isn't in it
+func (g Game) team(playerUUID string) int64 {
+	for i, p := range g.Players {
+		if p == playerUUID && i < len(g.Teams) {
+			return g.Teams[i]
+		}
+	}
+
+	return 0
+}
+
+// isWinner is a private helper that reports whether the player is the game's winner or on the winning team
+func (g Game) isWinner(playerUUID string) bool {
+	if playerUUID == g.Winner {
+		return true
+	}
+
+	return g.Winning_team > 0 && g.team(playerUUID) == g.Winning_team
+}
+
+// placement is a private helper that returns the player's placement, used to rate the players against each other.
+// Without reported results, the winners placed first and every other player is treated as tied for second.
+func (g Game) placement(playerUUID string) int {
+	if r, ok := g.result(playerUUID); ok {
+		return int(r.Placement)
+	}
+
+	if g.isWinner(playerUUID) {
+		return 1
+	}
+
+	return 2
+}
+
+// validateResult is a private helper to check the reported result against the game's roster in g.Players and g.Teams.
+// A winner or a winning team is required. The winner must have played in the game and the winning team must exist.
+// When per-player results are reported, every player in the roster must have exactly one, with a placement of
+// at least 1, and the winners must have the best placement. Bot slots, read from g.Participants, may be reported
+// but aren't required.
+func (g Game) validateResult() error {
+	if g.Winner == "" && g.Winning_team == 0 {
+		return fmt.Errorf("%w: a winner or winning_team is required", ErrInvalidResult)
+	}
+
+	roster := make(map[string]bool)
+	required := 0
+	teams := make(map[int64]bool)
+	for i, p := range g.Players {
+		roster[p] = true
+		if !g.isBot(p) {
+			required++
+		}
+		if i < len(g.Teams) {
+			teams[g.Teams[i]] = true
+		}
+	}
+
+	if g.Winner != "" && !roster[g.Winner] {
+		return fmt.Errorf("%w: winner '%s' didn't play in the game", ErrInvalidResult, g.Winner)
+	}
+	if g.Winning_team != 0 && !teams[g.Winning_team] {
+		return fmt.Errorf("%w: team %d didn't play in the game", ErrInvalidResult, g.Winning_team)
+	}
+	if g.Winner != "" && g.Winning_team != 0 && g.team(g.Winner) != g.Winning_team {
+		return fmt.Errorf("%w: winner '%s' isn't on the winning team", ErrInvalidResult, g.Winner)
+	}
+
+	if len(g.Results) == 0 {
+		return nil
+	}
+
+	best := int64(0)
+	reportedPlayers := 0
+	reported := make(map[string]bool)
+	for _, r := range g.Results {
+		if !roster[r.PlayerUUID] {
+			return fmt.Errorf("%w: player '%s' didn't play in the game", ErrInvalidResult, r.PlayerUUID)
+		}
+		if reported[r.PlayerUUID] {
+			return fmt.Errorf("%w: player '%s' has more than one result", ErrInvalidResult, r.PlayerUUID)
+		}
+		if r.Placement < 1 {
+			return fmt.Errorf("%w: player '%s' has a placement below 1", ErrInvalidResult, r.PlayerUUID)
+		}
+
+		reported[r.PlayerUUID] = true
+		if !g.isBot(r.PlayerUUID) {
+			reportedPlayers++
+		}
+		if best == 0 || r.Placement < best {
+			best = r.Placement
+		}
+	}
+
+	if reportedPlayers != required {
+		return fmt.Errorf("%w: every player needs a result", ErrInvalidResult)
+	}
+
+	for _, r := range g.Results {
+		if g.isWinner(r.PlayerUUID) && r.Placement != best {
+			return fmt.Errorf("%w: winner '%s' doesn't have the best placement", ErrInvalidResult, r.PlayerUUID)
+		}
+	}
+
+	return nil
+}
+
+// result is a private helper that returns the player's reported result, if there is one
+func (g Game) result(playerUUID string) (PlayerResult, bool) {
+	for _, r := range g.Results {
+		if r.PlayerUUID == playerUUID {
+			return r, true
+		}
+	}
+
+	return PlayerResult{}, false
+}
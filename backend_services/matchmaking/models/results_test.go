@@ -0,0 +1,109 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateResult(t *testing.T) {
+	roster := Game{Players: []string{"a", "b", "c", "d"}, Teams: []int64{1, 2, 1, 2}}
+
+	var tests = []struct {
+		name    string
+		winner  string
+		team    int64
+		results []PlayerResult
+		valid   bool
+	}{
+		{"winner", "a", 0, nil, true},
+		{"winning team", "", 2, nil, true},
+		{"winner on winning team", "b", 2, nil, true},
+		{"no winner", "", 0, nil, false},
+		{"unknown winner", "e", 0, nil, false},
+		{"unknown team", "", 3, nil, false},
+		{"winner on losing team", "a", 2, nil, false},
+		{"results", "a", 0, []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"c", 3, 10}, {"d", 4, 0}}, true},
+		{"team results", "", 1, []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"c", 1, 10}, {"d", 2, 0}}, true},
+		{"missing result", "a", 0, []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"c", 3, 10}}, false},
+		{"duplicate result", "a", 0, []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"c", 3, 10}, {"c", 4, 0}}, false},
+		{"unknown player", "a", 0, []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"c", 3, 10}, {"e", 4, 0}}, false},
+		{"zero placement", "a", 0, []PlayerResult{{"a", 1, 30}, {"b", 0, 20}, {"c", 3, 10}, {"d", 4, 0}}, false},
+		{"winner not first", "b", 0, []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"c", 3, 10}, {"d", 4, 0}}, false},
+	}
+
+	for _, test := range tests {
+		g := roster
+		g.Winner = test.winner
+		g.Winning_team = test.team
+		g.Results = test.results
+
+		err := g.validateResult()
+		if test.valid {
+			assert.Nil(t, err, test.name)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidResult, test.name)
+		}
+	}
+}
+
+func TestValidateResultWithBots(t *testing.T) {
+	g := Game{
+		Players: []string{"a", "b", "bot-1", "bot-2"},
+		Teams:   []int64{1, 2, 1, 2},
+		Participants: []Participant{
+			{PlayerUUID: "a", Participant_type: ParticipantPlayer},
+			{PlayerUUID: "b", Participant_type: ParticipantPlayer},
+			{PlayerUUID: "bot-1", Participant_type: ParticipantBot},
+			{PlayerUUID: "bot-2", Participant_type: ParticipantBot},
+		},
+		Winner: "a",
+	}
+
+	// Bots don't need a result
+	g.Results = []PlayerResult{{"a", 1, 30}, {"b", 2, 20}}
+	assert.Nil(t, g.validateResult())
+
+	// but can have one
+	g.Results = []PlayerResult{{"a", 1, 30}, {"b", 2, 20}, {"bot-1", 3, 10}}
+	assert.Nil(t, g.validateResult())
+
+	// Every player still needs one
+	g.Results = []PlayerResult{{"a", 1, 30}, {"bot-1", 2, 10}, {"bot-2", 3, 0}}
+	assert.ErrorIs(t, g.validateResult(), ErrInvalidResult)
+}
+
+func TestPlacement(t *testing.T) {
+	g := Game{Players: []string{"a", "b", "c", "d"}, Teams: []int64{1, 2, 1, 2}, Winning_team: 1}
+
+	assert.Equal(t, 1, g.placement("a"))
+	assert.Equal(t, 2, g.placement("b"))
+	assert.True(t, g.isWinner("c"))
+	assert.False(t, g.isWinner("d"))
+
+	g.Results = []PlayerResult{{"a", 1, 30}, {"b", 3, 20}, {"c", 1, 10}, {"d", 4, 0}}
+	assert.Equal(t, 3, g.placement("b"))
+
+	r, ok := g.result("c")
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, r.Score)
+
+	_, ok = g.result("e")
+	assert.False(t, ok)
+}
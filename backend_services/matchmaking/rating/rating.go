@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rating calculates player skill ratings with the Glicko rating system.
+//
+// Each player has a rating and a rating deviation. The deviation measures how uncertain the
+// rating is: it starts high for new players, so their first games move their rating quickly,
+// and shrinks as they play more games.
+//
+// The package has no dependencies on the database, so the math can be tested on its own.
+package rating
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// DefaultRating is the rating of a player who hasn't played a rated game
+	DefaultRating = 1500.0
+
+	// MaxDeviation is the deviation of a player who hasn't played a rated game
+	MaxDeviation = 350.0
+
+	// MinDeviation keeps ratings responsive for players who have played many games
+	MinDeviation = 30.0
+)
+
+// q is the Glicko scaling constant, ln(10)/400
+var q = math.Ln10 / 400
+
+// Rating is a player's skill estimate
+type Rating struct {
+	Rating    float64
+	Deviation float64
+}
+
+// Outcome is the result of a game against one opponent.
+// Score is 1 for a win, 0.5 for a draw and 0 for a loss.
+type Outcome struct {
+	Opponent Rating
+	Score    float64
+}
+
+// Default returns the rating for a new player
+func Default() Rating {
+	return Rating{Rating: DefaultRating, Deviation: MaxDeviation}
+}
+
+// g is a private helper that reduces the impact of an opponent whose rating is uncertain
+func g(deviation float64) float64 {
+	return 1 / math.Sqrt(1+3*q*q*deviation*deviation/(math.Pi*math.Pi))
+}
+
+// ExpectedScore returns the probability that a player with rating r beats the opponent
+func ExpectedScore(r Rating, opponent Rating) float64 {
+	return 1 / (1 + math.Pow(10, -g(opponent.Deviation)*(r.Rating-opponent.Rating)/400))
+}
+
+// Update returns the player's new rating after the outcomes of a rating period.
+// A player without any outcomes keeps their rating.
+func Update(r Rating, outcomes []Outcome) Rating {
+	if len(outcomes) == 0 {
+		return r
+	}
+
+	var dInv, delta float64
+	for _, o := range outcomes {
+		gj := g(o.Opponent.Deviation)
+		e := ExpectedScore(r, o.Opponent)
+
+		dInv += gj * gj * e * (1 - e)
+		delta += gj * (o.Score - e)
+	}
+	dInv *= q * q
+
+	precision := 1/(r.Deviation*r.Deviation) + dInv
+
+	return Rating{
+		Rating:    r.Rating + q/precision*delta,
+		Deviation: math.Max(MinDeviation, math.Min(MaxDeviation, math.Sqrt(1/precision))),
+	}
+}
+
+// UpdateMatch returns the new ratings for every player in a multiplayer game, in the same order.
+// A lower placement is better. Each player is rated against every player with a different placement,
+// as a win if they placed better and a loss if they placed worse. Players with the same placement
+// are not compared, so a game where only the winner is known compares everyone else only with the winner.
+func UpdateMatch(ratings []Rating, placements []int) []Rating {
+	updated := make([]Rating, len(ratings))
+
+	for i, r := range ratings {
+		var outcomes []Outcome
+		for j, opponent := range ratings {
+			if i == j || placements[i] == placements[j] {
+				continue
+			}
+
+			score := 0.0
+			if placements[i] < placements[j] {
+				score = 1
+			}
+			outcomes = append(outcomes, Outcome{Opponent: opponent, Score: score})
+		}
+
+		updated[i] = Update(r, outcomes)
+	}
+
+	return updated
+}
+
+// Weighted returns a rating that moved only part of the way from before to after.
+// A weight of 0 keeps the rating from before, and a weight of 1 returns the rating after.
+func Weighted(before Rating, after Rating, weight float64) Rating {
+	weight = math.Max(0, math.Min(1, weight))
+
+	return Rating{
+		Rating:    before.Rating + weight*(after.Rating-before.Rating),
+		Deviation: before.Deviation + weight*(after.Deviation-before.Deviation),
+	}
+}
+
+// Window is the range of ratings a player can be matched with. It starts at Initial
+// points either side of the player's rating, and widens by Growth points for every second
+// the player has waited, up to Max, so players who wait a long time still find a game.
+type Window struct {
+	Initial float64
+	Growth  float64
+	Max     float64
+}
+
+// Width returns how far from the player's rating opponents can be after waiting
+func (w Window) Width(waited time.Duration) float64 {
+	if waited < 0 {
+		waited = 0
+	}
+
+	return math.Min(w.Max, w.Initial+w.Growth*waited.Seconds())
+}
+
+// Bounds returns the lowest and highest ratings a player with rating r can be matched with after waiting
+func (w Window) Bounds(r float64, waited time.Duration) (float64, float64) {
+	width := w.Width(waited)
+	return r - width, r + width
+}
@@ -0,0 +1,115 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rating
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The example from Glickman's description of the Glicko system
+func TestUpdateGlickmanExample(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200}
+
+	updated := Update(player, []Outcome{
+		{Opponent: Rating{Rating: 1400, Deviation: 30}, Score: 1},
+		{Opponent: Rating{Rating: 1550, Deviation: 100}, Score: 0},
+		{Opponent: Rating{Rating: 1700, Deviation: 300}, Score: 0},
+	})
+
+	assert.InDelta(t, 1464.1, updated.Rating, 0.1)
+	assert.InDelta(t, 151.4, updated.Deviation, 0.1)
+}
+
+func TestUpdateWithoutOutcomes(t *testing.T) {
+	assert.Equal(t, Default(), Update(Default(), nil))
+}
+
+func TestExpectedScore(t *testing.T) {
+	even := ExpectedScore(Default(), Default())
+	assert.InDelta(t, 0.5, even, 0.0001)
+
+	strong := Rating{Rating: 1900, Deviation: 50}
+	assert.Greater(t, ExpectedScore(strong, Default()), 0.8)
+	assert.Less(t, ExpectedScore(Default(), strong), 0.2)
+}
+
+func TestDeviationBounds(t *testing.T) {
+	r := Rating{Rating: 1500, Deviation: MinDeviation}
+	for i := 0; i < 50; i++ {
+		r = Update(r, []Outcome{{Opponent: Rating{Rating: 1500, Deviation: MinDeviation}, Score: 0.5}})
+	}
+	assert.Equal(t, MinDeviation, r.Deviation)
+}
+
+func TestUpdateMatch(t *testing.T) {
+	ratings := []Rating{Default(), Default(), Default()}
+
+	// Only the winner is known
+	updated := UpdateMatch(ratings, []int{1, 2, 2})
+	assert.Greater(t, updated[0].Rating, DefaultRating)
+	assert.Less(t, updated[1].Rating, DefaultRating)
+	assert.Equal(t, updated[1], updated[2])
+
+	// Full placements
+	updated = UpdateMatch(ratings, []int{3, 1, 2})
+	assert.Less(t, updated[0].Rating, updated[2].Rating)
+	assert.Less(t, updated[2].Rating, updated[1].Rating)
+
+	// Every rated player becomes more certain
+	for _, r := range updated {
+		assert.Less(t, r.Deviation, MaxDeviation)
+	}
+}
+
+func TestUpsetMovesRatingsFurther(t *testing.T) {
+	strong := Rating{Rating: 1800, Deviation: 100}
+	weak := Rating{Rating: 1400, Deviation: 100}
+
+	expected := UpdateMatch([]Rating{strong, weak}, []int{1, 2})
+	upset := UpdateMatch([]Rating{strong, weak}, []int{2, 1})
+
+	assert.Greater(t, strong.Rating-upset[0].Rating, expected[0].Rating-strong.Rating)
+}
+
+func TestWeighted(t *testing.T) {
+	before := Rating{Rating: 1500, Deviation: 200}
+	after := Rating{Rating: 1600, Deviation: 180}
+
+	assert.Equal(t, before, Weighted(before, after, 0))
+	assert.Equal(t, after, Weighted(before, after, 1))
+	assert.Equal(t, Rating{Rating: 1550, Deviation: 190}, Weighted(before, after, 0.5))
+
+	// Weights outside of 0 and 1 are clamped
+	assert.Equal(t, before, Weighted(before, after, -1))
+	assert.Equal(t, after, Weighted(before, after, 2))
+}
+
+func TestWindow(t *testing.T) {
+	w := Window{Initial: 100, Growth: 5, Max: 600}
+
+	assert.Equal(t, 100.0, w.Width(0))
+	assert.Equal(t, 150.0, w.Width(10*time.Second))
+	assert.Equal(t, 600.0, w.Width(time.Hour))
+	assert.Equal(t, 100.0, w.Width(-time.Minute))
+
+	low, high := w.Bounds(1500, 10*time.Second)
+	assert.Equal(t, 1350.0, low)
+	assert.Equal(t, 1650.0, high)
+}
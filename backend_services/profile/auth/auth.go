@@ -0,0 +1,198 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth issues and verifies the signed access tokens that identify
+// a player to the backend services.
+//
+// Tokens are HMAC signed JWTs whose subject is the playerUUID. The same
+// secret must be configured for every service that verifies them.
+//
+// Each backend service is built from its own module, so the token checks are
+// copied into every service. ValidateToken, RequireToken and Authorize, and
+// their tests, must stay identical in all of the copies; only the profile
+// service issues tokens.
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	issuer = "profile-service"
+
+	// playerKey is the gin context key holding the authenticated playerUUID
+	playerKey = "auth_player"
+
+	// sessionKey is the gin context key holding the session the access token was issued for
+	sessionKey = "auth_session"
+
+	// disabledKey is the gin context key set when token checks are turned off
+	disabledKey = "auth_disabled"
+)
+
+// ErrMissingSecret is returned when tokens are enabled without a signing secret
+var ErrMissingSecret = errors.New("no access token secret configured")
+
+// Claims are the verified contents of an access token
+type Claims struct {
+	PlayerUUID  string
+	SessionUUID string
+}
+
+// tokenClaims are the claims signed into an access token. The session id is empty for
+// tokens that don't belong to a login session.
+type tokenClaims struct {
+	SessionUUID string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// IssueToken creates a signed access token for the player's login session that expires after the configured ttl.
+// The token and its expiry time are returned.
+func IssueToken(c config.AuthConfig, playerUUID string, sessionUUID string) (string, time.Time, error) {
+	if c.Secret == "" {
+		return "", time.Time{}, ErrMissingSecret
+	}
+
+	now := time.Now()
+	expires := now.Add(c.Token_ttl)
+
+	claims := tokenClaims{
+		SessionUUID: sessionUUID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    issuer,
+			Subject:   playerUUID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(c.Secret))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("could not sign token: %s", err)
+	}
+
+	return token, expires, nil
+}
+
+// ParseToken verifies the signature and expiry of an access token and returns its claims
+func ParseToken(c config.AuthConfig, token string) (Claims, error) {
+	if c.Secret == "" {
+		return Claims{}, ErrMissingSecret
+	}
+
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(c.Secret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if claims.Subject == "" {
+		return Claims{}, errors.New("token has no subject")
+	}
+
+	return Claims{PlayerUUID: claims.Subject, SessionUUID: claims.SessionUUID}, nil
+}
+
+// ValidateToken verifies the signature and expiry of an access token and returns
+// the playerUUID it was issued to.
+func ValidateToken(c config.AuthConfig, token string) (string, error) {
+	claims, err := ParseToken(c, token)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.PlayerUUID, nil
+}
+
+// bearerToken is a private helper to read the token from an 'Authorization: Bearer' header
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") {
+		return ""
+	}
+
+	return strings.TrimSpace(header[7:])
+}
+
+// RequireToken is a middleware that rejects requests without a valid access token.
+// The authenticated playerUUID is stored in the gin context for Authorize, along with the token's session.
+func RequireToken(c config.AuthConfig) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !c.Enabled {
+			ctx.Set(disabledKey, true)
+			ctx.Next()
+			return
+		}
+
+		token := bearerToken(ctx)
+		if token == "" {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "missing access token"})
+			return
+		}
+
+		claims, err := ParseToken(c, token)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "invalid access token"})
+			return
+		}
+
+		ctx.Set(playerKey, claims.PlayerUUID)
+		ctx.Set(sessionKey, claims.SessionUUID)
+		ctx.Next()
+	}
+}
+
+// Authorize reports whether the request's access token belongs to the provided player.
+// If it does not, the request is aborted with a 403 response.
+func Authorize(ctx *gin.Context, playerUUID string) bool {
+	if ctx.GetBool(disabledKey) {
+		return true
+	}
+
+	if authenticated := ctx.GetString(playerKey); authenticated != "" && authenticated == playerUUID {
+		return true
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "access token does not belong to player"})
+	return false
+}
+
+// PlayerUUID returns the player the request's access token was issued to, or an empty string
+// if token checks are turned off
+func PlayerUUID(ctx *gin.Context) string {
+	return ctx.GetString(playerKey)
+}
+
+// SessionUUID returns the login session of the request's access token, or an empty string
+// if token checks are turned off or the token wasn't issued for a session
+func SessionUUID(ctx *gin.Context) string {
+	return ctx.GetString(sessionKey)
+}
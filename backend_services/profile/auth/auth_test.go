@@ -0,0 +1,119 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = config.AuthConfig{Enabled: true, Secret: "test-secret", Token_ttl: time.Hour}
+
+var testPlayer = "ea32ff20-e10f-42c4-80d1-e0e1970eeb56"
+
+var testSession = "0b6b1c2e-5d0a-4f53-9a3e-6f1f2d3c4b5a"
+
+func TestIssueAndValidateToken(t *testing.T) {
+	token, expires, err := IssueToken(testConfig, testPlayer, testSession)
+	assert.Nil(t, err)
+	assert.True(t, expires.After(time.Now()))
+
+	playerUUID, err := ValidateToken(testConfig, token)
+	assert.Nil(t, err)
+	assert.Equal(t, testPlayer, playerUUID)
+}
+
+func TestParseTokenSession(t *testing.T) {
+	token, _, err := IssueToken(testConfig, testPlayer, testSession)
+	assert.Nil(t, err)
+
+	claims, err := ParseToken(testConfig, token)
+	assert.Nil(t, err)
+	assert.Equal(t, testPlayer, claims.PlayerUUID)
+	assert.Equal(t, testSession, claims.SessionUUID)
+}
+
+func TestIssueTokenWithoutSecret(t *testing.T) {
+	_, _, err := IssueToken(config.AuthConfig{Enabled: true, Token_ttl: time.Hour}, testPlayer, testSession)
+	assert.ErrorIs(t, err, ErrMissingSecret)
+}
+
+func TestExpiredToken(t *testing.T) {
+	expired := testConfig
+	expired.Token_ttl = -time.Minute
+
+	token, _, err := IssueToken(expired, testPlayer, testSession)
+	assert.Nil(t, err)
+
+	_, err = ValidateToken(testConfig, token)
+	assert.NotNil(t, err)
+}
+
+func TestTokenWrongSecret(t *testing.T) {
+	token, _, err := IssueToken(testConfig, testPlayer, testSession)
+	assert.Nil(t, err)
+
+	other := testConfig
+	other.Secret = "another-secret"
+
+	_, err = ValidateToken(other, token)
+	assert.NotNil(t, err)
+}
+
+// serve is a helper to run a single request through RequireToken and Authorize
+func serve(c config.AuthConfig, header string, playerUUID string) int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/players/:id", RequireToken(c), func(ctx *gin.Context) {
+		if !Authorize(ctx, ctx.Param("id")) {
+			return
+		}
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/players/"+playerUUID, nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w.Code
+}
+
+func TestRequireToken(t *testing.T) {
+	token, _, err := IssueToken(testConfig, testPlayer, testSession)
+	assert.Nil(t, err)
+
+	assert.Equal(t, http.StatusOK, serve(testConfig, "Bearer "+token, testPlayer))
+	assert.Equal(t, http.StatusUnauthorized, serve(testConfig, "", testPlayer))
+	assert.Equal(t, http.StatusUnauthorized, serve(testConfig, "Bearer not-a-token", testPlayer))
+	assert.Equal(t, http.StatusForbidden, serve(testConfig, "Bearer "+token, "3349f46a-215d-42e9-ab3a-759883cfeb2e"))
+}
+
+func TestRequireTokenDisabled(t *testing.T) {
+	disabled := testConfig
+	disabled.Enabled = false
+
+	assert.Equal(t, http.StatusOK, serve(disabled, "", testPlayer))
+}
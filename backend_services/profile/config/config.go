@@ -21,14 +21,22 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/viper"
 )
 
 // Config contains all of the available configurations for the profile service
 type Config struct {
-	Server  ServerConfig
-	Spanner SpannerConfig
+	Server   ServerConfig
+	Spanner  SpannerConfig
+	Auth     AuthConfig
+	Email    EmailConfig
+	Lockout  LockoutConfig
+	Session  SessionConfig
+	Password PasswordConfig
+	Identity IdentityConfig
+	Totp     TotpConfig
 }
 
 // ServerConfig contains the information to expose the profile service as a server
@@ -45,6 +53,80 @@ type SpannerConfig struct {
 	CredentialsFile string `mapstructure:"CREDENTIALS_FILE" yaml:"credentials_file,omitempty"`
 }
 
+// AuthConfig contains the information to issue and verify player access tokens
+type AuthConfig struct {
+	Enabled   bool
+	Secret    string
+	Token_ttl time.Duration
+}
+
+// EmailConfig contains the information to verify player email addresses and send password resets.
+// Mailer is either 'log' to write messages to the service log, or 'file' to append them to Mailer_file.
+type EmailConfig struct {
+	Require_verified bool
+	Verification_ttl time.Duration
+	Verify_url       string
+	Reset_ttl        time.Duration
+	Mailer           string
+	Mailer_file      string
+}
+
+// LockoutConfig contains the information to slow down repeated failed logins.
+// An account is locked after Free_attempts consecutive failures, and a client IP after Ip_free_attempts.
+// The first lock lasts Base_delay, and each further failure doubles it up to Max_delay.
+// Failures are forgotten when there have been none for Reset_after.
+type LockoutConfig struct {
+	Enabled          bool
+	Free_attempts    int
+	Ip_free_attempts int
+	Base_delay       time.Duration
+	Max_delay        time.Duration
+	Reset_after      time.Duration
+}
+
+// SessionConfig contains the information to log out players that stopped sending heartbeats.
+// Players whose last heartbeat is older than Ttl are logged out by a sweeper that runs every Sweep_interval.
+// A Ttl of 0 disables the sweeper. Each login session can be refreshed until it is unused for Refresh_ttl.
+type SessionConfig struct {
+	Ttl            time.Duration
+	Sweep_interval time.Duration
+	Refresh_ttl    time.Duration
+}
+
+// PasswordConfig contains the argon2id parameters used to hash player passwords. Memory is in KiB.
+// Passwords hashed with other parameters, or with bcrypt, are re-hashed when the player logs in.
+type PasswordConfig struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	Salt_length uint32
+	Key_length  uint32
+}
+
+// IdentityConfig contains the external identity providers players can log in with, keyed by provider name
+type IdentityConfig struct {
+	Providers map[string]IdentityProviderConfig
+}
+
+// IdentityProviderConfig contains the information to verify ID tokens issued by a provider.
+// The signing keys are read from Jwks_url, or from Jwks_file if no url is set.
+type IdentityProviderConfig struct {
+	Issuer    string
+	Audience  string
+	Jwks_url  string
+	Jwks_file string
+}
+
+// TotpConfig contains the information for TOTP two-factor authentication.
+// Encryption_key is a base64 encoded 32 byte AES key used to encrypt the TOTP secrets stored in the database.
+// Issuer is the name authenticator apps show for the secret. Players with two-factor authentication enabled
+// must provide a code within Challenge_ttl of providing their password.
+type TotpConfig struct {
+	Encryption_key string
+	Issuer         string
+	Challenge_ttl  time.Duration
+}
+
 // NewConfig initializes the configuration with default values and binds
 // environment variables and reads from any supplied config.yml file
 func NewConfig() (Config, error) {
@@ -58,6 +140,42 @@ func NewConfig() (Config, error) {
 	viper.SetDefault("server.host", "localhost")
 	viper.SetDefault("server.port", 8080)
 
+	// Auth defaults
+	viper.SetDefault("auth.enabled", true)
+	viper.SetDefault("auth.token_ttl", "15m")
+
+	// Email defaults
+	viper.SetDefault("email.require_verified", false)
+	viper.SetDefault("email.verification_ttl", "24h")
+	viper.SetDefault("email.verify_url", "http://localhost:8080/players/verify")
+	viper.SetDefault("email.reset_ttl", "1h")
+	viper.SetDefault("email.mailer", "log")
+	viper.SetDefault("email.mailer_file", "mail.log")
+
+	// Lockout defaults
+	viper.SetDefault("lockout.enabled", true)
+	viper.SetDefault("lockout.free_attempts", 5)
+	viper.SetDefault("lockout.ip_free_attempts", 20)
+	viper.SetDefault("lockout.base_delay", "30s")
+	viper.SetDefault("lockout.max_delay", "15m")
+	viper.SetDefault("lockout.reset_after", "1h")
+
+	// Session defaults
+	viper.SetDefault("session.ttl", "15m")
+	viper.SetDefault("session.sweep_interval", "1m")
+	viper.SetDefault("session.refresh_ttl", "720h")
+
+	// Password defaults
+	viper.SetDefault("password.memory", 19456)
+	viper.SetDefault("password.iterations", 2)
+	viper.SetDefault("password.parallelism", 1)
+	viper.SetDefault("password.salt_length", 16)
+	viper.SetDefault("password.key_length", 32)
+
+	// TOTP defaults
+	viper.SetDefault("totp.issuer", "Spanner Gaming Sample")
+	viper.SetDefault("totp.challenge_ttl", "5m")
+
 	// Bind environment variable override
 	if err := viper.BindEnv("server.host", "SERVICE_HOST"); err != nil {
 		return Config{}, fmt.Errorf("could not set environment variable 'server.host': %s", err)
@@ -75,6 +193,47 @@ func NewConfig() (Config, error) {
 		return Config{}, fmt.Errorf("could not set environment variable 'spanner.database_id': %s", err)
 	}
 
+	if err := viper.BindEnv("auth.enabled", "AUTH_ENABLED"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.enabled': %s", err)
+	}
+	if err := viper.BindEnv("auth.secret", "AUTH_SECRET"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.secret': %s", err)
+	}
+	if err := viper.BindEnv("auth.token_ttl", "AUTH_TOKEN_TTL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.token_ttl': %s", err)
+	}
+
+	if err := viper.BindEnv("email.require_verified", "EMAIL_REQUIRE_VERIFIED"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'email.require_verified': %s", err)
+	}
+	if err := viper.BindEnv("email.verify_url", "EMAIL_VERIFY_URL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'email.verify_url': %s", err)
+	}
+	if err := viper.BindEnv("email.mailer", "EMAIL_MAILER"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'email.mailer': %s", err)
+	}
+	if err := viper.BindEnv("email.mailer_file", "EMAIL_MAILER_FILE"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'email.mailer_file': %s", err)
+	}
+
+	if err := viper.BindEnv("lockout.enabled", "LOCKOUT_ENABLED"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'lockout.enabled': %s", err)
+	}
+
+	if err := viper.BindEnv("session.ttl", "SESSION_TTL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'session.ttl': %s", err)
+	}
+	if err := viper.BindEnv("session.sweep_interval", "SESSION_SWEEP_INTERVAL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'session.sweep_interval': %s", err)
+	}
+	if err := viper.BindEnv("session.refresh_ttl", "SESSION_REFRESH_TTL"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'session.refresh_ttl': %s", err)
+	}
+
+	if err := viper.BindEnv("totp.encryption_key", "TOTP_ENCRYPTION_KEY"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'totp.encryption_key': %s", err)
+	}
+
 	if err := viper.ReadInConfig(); err != nil {
 		fmt.Printf("[WARNING] could not read config %s\n", err.Error())
 	}
@@ -79,3 +79,12 @@ func TestEnvironmentVariables(t *testing.T) {
 
 	assert.Equal(t, "projects/test-project/instances/test-instance/databases/test-database", c.Spanner.DB())
 }
+
+func TestPasswordDefaults(t *testing.T) {
+	c, err := NewConfig()
+	assert.Nil(t, err)
+
+	assert.Equal(t, uint32(19456), c.Password.Memory)
+	assert.Equal(t, uint32(2), c.Password.Iterations)
+	assert.Equal(t, uint8(1), c.Password.Parallelism)
+}
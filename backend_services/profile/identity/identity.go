@@ -0,0 +1,292 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package identity verifies ID tokens issued by external identity providers.
+//
+// Each provider is configured with the issuer and audience its tokens must have, and a
+// JSON Web Key Set (JWKS) containing its signing keys. The key set is read from a url or
+// a local file, and is read again when a token is signed with a key that isn't known yet.
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// refreshInterval limits how often an unknown key id causes the key set to be read again
+const refreshInterval = time.Minute
+
+var (
+	// ErrUnknownProvider is returned when no provider is configured with the requested name
+	ErrUnknownProvider = errors.New("unknown identity provider")
+
+	// ErrInvalidToken is returned when an ID token can't be verified
+	ErrInvalidToken = errors.New("invalid ID token")
+)
+
+// Claims is the verified information about a player from an ID token
+type Claims struct {
+	Subject        string
+	Email          string
+	Email_verified bool
+	Name           string
+}
+
+// idTokenClaims are the claims read from an ID token
+type idTokenClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// jwk is a single key in a JSON Web Key Set. Only RSA and EC signing keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Verifier verifies ID tokens for a single provider
+type Verifier struct {
+	Config config.IdentityProviderConfig
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+// NewVerifiers returns a Verifier for each configured provider, keyed by provider name
+func NewVerifiers(c config.IdentityConfig) (map[string]*Verifier, error) {
+	verifiers := map[string]*Verifier{}
+
+	for name, p := range c.Providers {
+		if p.Issuer == "" || p.Audience == "" {
+			return nil, fmt.Errorf("identity provider '%s' requires an issuer and audience", name)
+		}
+		if p.Jwks_url == "" && p.Jwks_file == "" {
+			return nil, fmt.Errorf("identity provider '%s' requires a jwks_url or jwks_file", name)
+		}
+
+		verifiers[name] = &Verifier{Config: p, Client: &http.Client{Timeout: 10 * time.Second}}
+	}
+
+	return verifiers, nil
+}
+
+// Verify checks the ID token's signature, issuer, audience and expiry, and returns its claims
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	var claims idTokenClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(v.Config.Issuer),
+		jwt.WithAudience(v.Config.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: missing subject", ErrInvalidToken)
+	}
+
+	return Claims{
+		Subject:        claims.Subject,
+		Email:          claims.Email,
+		Email_verified: claims.EmailVerified,
+		Name:           claims.Name,
+	}, nil
+}
+
+// key is a private helper to return the signing key with the key id, reading the key set
+// again if the key isn't known and the key set wasn't read recently
+func (v *Verifier) key(ctx context.Context, kid string) (interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+
+	if !v.fetched.IsZero() && time.Since(v.fetched) < refreshInterval {
+		return nil, fmt.Errorf("unknown key id '%s'", kid)
+	}
+
+	keys, err := v.readKeySet(ctx)
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+
+	if k, ok := v.keys[kid]; ok {
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("unknown key id '%s'", kid)
+}
+
+// readKeySet is a private helper to read and parse the provider's key set
+func (v *Verifier) readKeySet(ctx context.Context) (map[string]interface{}, error) {
+	var data []byte
+	var err error
+
+	if v.Config.Jwks_url != "" {
+		data, err = v.fetch(ctx, v.Config.Jwks_url)
+	} else {
+		data, err = os.ReadFile(v.Config.Jwks_file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read jwks: %s", err)
+	}
+
+	return parseKeySet(data)
+}
+
+// fetch is a private helper to download the key set from the url
+func (v *Verifier) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := v.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", response.StatusCode)
+	}
+
+	return io.ReadAll(response.Body)
+}
+
+// parseKeySet is a private helper to decode the signing keys in a JSON Web Key Set, keyed by key id.
+// Keys that aren't used for signatures or have an unsupported type are skipped.
+func parseKeySet(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("could not decode jwks: %s", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			key, err := k.rsaKey()
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = key
+		case "EC":
+			key, err := k.ecKey()
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// decodeInt is a private helper to decode a base64url encoded big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
+
+// rsaKey is a private helper to build the RSA public key from the jwk
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus for key '%s': %s", k.Kid, err)
+	}
+
+	e, err := decodeInt(k.E)
+	if err != nil || !e.IsInt64() {
+		return nil, fmt.Errorf("invalid exponent for key '%s'", k.Kid)
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+// ecKey is a private helper to build the EC public key from the jwk
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve '%s' for key '%s'", k.Crv, k.Kid)
+	}
+
+	x, err := decodeInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x coordinate for key '%s': %s", k.Kid, err)
+	}
+
+	y, err := decodeInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y coordinate for key '%s': %s", k.Kid, err)
+	}
+
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("key '%s' is not on curve %s", k.Kid, k.Crv)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
@@ -0,0 +1,224 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package identity
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "spanner-gaming-sample"
+)
+
+// encodeInt is a helper to base64url encode a big-endian integer for a jwk
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+// rsaJWK is a helper to build the jwk for an RSA public key
+func rsaJWK(kid string, key *rsa.PublicKey) jwk {
+	return jwk{Kty: "RSA", Kid: kid, Use: "sig", N: encodeInt(key.N), E: encodeInt(big.NewInt(int64(key.E)))}
+}
+
+// ecJWK is a helper to build the jwk for a P-256 public key
+func ecJWK(kid string, key *ecdsa.PublicKey) jwk {
+	return jwk{Kty: "EC", Kid: kid, Crv: "P-256", X: encodeInt(key.X), Y: encodeInt(key.Y)}
+}
+
+// keySet is a helper to encode a JSON Web Key Set
+func keySet(t *testing.T, keys ...jwk) []byte {
+	data, err := json.Marshal(map[string][]jwk{"keys": keys})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return data
+}
+
+// signToken is a helper to sign an ID token with the provided claims
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims idTokenClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return signed
+}
+
+// validClaims is a helper to return claims that the test verifier accepts
+func validClaims(subject string) idTokenClaims {
+	return idTokenClaims{
+		Email:         "player@example.com",
+		EmailVerified: true,
+		Name:          "Player",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   subject,
+			Audience:  jwt.ClaimStrings{testAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return key
+}
+
+func TestVerifyWithJWKSFile(t *testing.T) {
+	key := newRSAKey(t)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keySet(t, rsaJWK("key-1", &key.PublicKey)), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	verifiers, err := NewVerifiers(config.IdentityConfig{Providers: map[string]config.IdentityProviderConfig{
+		"test": {Issuer: testIssuer, Audience: testAudience, Jwks_file: path},
+	}})
+	assert.Nil(t, err)
+	v := verifiers["test"]
+
+	token := signToken(t, jwt.SigningMethodRS256, "key-1", key, validClaims("subject-1"))
+	claims, err := v.Verify(context.Background(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, "subject-1", claims.Subject)
+	assert.Equal(t, "player@example.com", claims.Email)
+	assert.True(t, claims.Email_verified)
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	key := newRSAKey(t)
+	other := newRSAKey(t)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, keySet(t, rsaJWK("key-1", &key.PublicKey)), 0600); err != nil {
+		t.Fatal(err.Error())
+	}
+	v := &Verifier{Config: config.IdentityProviderConfig{Issuer: testIssuer, Audience: testAudience, Jwks_file: path}}
+
+	wrongAudience := validClaims("subject-1")
+	wrongAudience.Audience = jwt.ClaimStrings{"another-game"}
+
+	wrongIssuer := validClaims("subject-1")
+	wrongIssuer.Issuer = "https://evil.example.com"
+
+	expired := validClaims("subject-1")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+
+	var tests = map[string]string{
+		"wrong key":      signToken(t, jwt.SigningMethodRS256, "key-1", other, validClaims("subject-1")),
+		"unknown kid":    signToken(t, jwt.SigningMethodRS256, "key-2", key, validClaims("subject-1")),
+		"wrong audience": signToken(t, jwt.SigningMethodRS256, "key-1", key, wrongAudience),
+		"wrong issuer":   signToken(t, jwt.SigningMethodRS256, "key-1", key, wrongIssuer),
+		"expired":        signToken(t, jwt.SigningMethodRS256, "key-1", key, expired),
+		"no subject":     signToken(t, jwt.SigningMethodRS256, "key-1", key, validClaims("")),
+		"hmac":           signToken(t, jwt.SigningMethodHS256, "key-1", []byte("secret"), validClaims("subject-1")),
+		"not a token":    "not-a-token",
+	}
+
+	for name, token := range tests {
+		_, err := v.Verify(context.Background(), token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+}
+
+func TestVerifyWithJWKSServerRotation(t *testing.T) {
+	oldKey := newRSAKey(t)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var mu sync.Mutex
+	keys := keySet(t, rsaJWK("old", &oldKey.PublicKey))
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		_, _ = w.Write(keys)
+	}))
+	defer server.Close()
+
+	v := &Verifier{
+		Config: config.IdentityProviderConfig{Issuer: testIssuer, Audience: testAudience, Jwks_url: server.URL},
+		Client: server.Client(),
+	}
+
+	_, err = v.Verify(context.Background(), signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims("subject-1")))
+	assert.Nil(t, err)
+
+	// Known keys are not fetched again
+	_, err = v.Verify(context.Background(), signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims("subject-1")))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+
+	// The provider rotates to a new key
+	mu.Lock()
+	keys = keySet(t, rsaJWK("old", &oldKey.PublicKey), ecJWK("new", &newKey.PublicKey))
+	mu.Unlock()
+
+	token := signToken(t, jwt.SigningMethodES256, "new", newKey, validClaims("subject-1"))
+
+	// The key set was just read, so it isn't read again straight away
+	_, err = v.Verify(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.Equal(t, 1, requests)
+
+	v.fetched = time.Now().Add(-2 * refreshInterval)
+	_, err = v.Verify(context.Background(), token)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestNewVerifiersRequiresKeys(t *testing.T) {
+	_, err := NewVerifiers(config.IdentityConfig{Providers: map[string]config.IdentityProviderConfig{
+		"test": {Issuer: testIssuer, Audience: testAudience},
+	}})
+	assert.NotNil(t, err)
+
+	_, err = NewVerifiers(config.IdentityConfig{Providers: map[string]config.IdentityProviderConfig{
+		"test": {Audience: testAudience, Jwks_file: "jwks.json"},
+	}})
+	assert.NotNil(t, err)
+}
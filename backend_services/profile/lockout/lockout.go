@@ -0,0 +1,155 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lockout decides when repeated failed logins lock an account or client IP.
+//
+// Failures are counted separately for each account and each client IP. Once a key has used
+// up its free attempts, every further failure locks it for twice as long as the last one,
+// up to a maximum. The package only does the bookkeeping; storing the attempts is left to
+// the caller so the counts can be shared between service replicas.
+package lockout
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+)
+
+const (
+	accountPrefix = "account:"
+	ipPrefix      = "ip:"
+)
+
+// Clock tells the limiter the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock that uses the system time
+type SystemClock struct{}
+
+// Now returns the current system time
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Attempts is the failed login history for a single account or client IP
+type Attempts struct {
+	Attempt_key  string
+	Failures     int64
+	Last_failure time.Time
+	Locked_until time.Time
+}
+
+// LockedError is returned when a login is refused because the account or client IP is locked
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed logins, retry after %s", e.RetryAfter)
+}
+
+// AccountKey returns the key failures for the account with the email are counted under
+func AccountKey(email string) string {
+	return accountPrefix + strings.ToLower(email)
+}
+
+// IPKey returns the key failures from the client IP are counted under
+func IPKey(ip string) string {
+	return ipPrefix + ip
+}
+
+// Limiter applies the lockout configuration to failed login attempts
+type Limiter struct {
+	Config config.LockoutConfig
+	Clock  Clock
+}
+
+// New returns a Limiter for the configuration that uses the system time
+func New(c config.LockoutConfig) *Limiter {
+	return &Limiter{Config: c, Clock: SystemClock{}}
+}
+
+// Enabled returns true if failed logins should be counted
+func (l *Limiter) Enabled() bool {
+	return l != nil && l.Config.Enabled
+}
+
+// freeAttempts is a private helper to return the failures allowed before the key is locked
+func (l *Limiter) freeAttempts(key string) int64 {
+	if strings.HasPrefix(key, ipPrefix) {
+		return int64(l.Config.Ip_free_attempts)
+	}
+
+	return int64(l.Config.Free_attempts)
+}
+
+// Delay returns how long a key is locked for after a number of consecutive failures
+func (l *Limiter) Delay(key string, failures int64) time.Duration {
+	over := failures - l.freeAttempts(key)
+	if over < 0 {
+		return 0
+	}
+
+	delay := l.Config.Base_delay
+	for i := int64(0); i < over && delay < l.Config.Max_delay; i++ {
+		delay *= 2
+	}
+
+	if delay > l.Config.Max_delay {
+		delay = l.Config.Max_delay
+	}
+
+	return delay
+}
+
+// RetryAfter returns how long until all of the keys are unlocked, or 0 if none of them are locked
+func (l *Limiter) RetryAfter(attempts ...Attempts) time.Duration {
+	if !l.Enabled() {
+		return 0
+	}
+
+	now := l.Clock.Now()
+
+	var retry time.Duration
+	for _, a := range attempts {
+		if wait := a.Locked_until.Sub(now); wait > retry {
+			retry = wait
+		}
+	}
+
+	return retry
+}
+
+// Fail returns the attempts with another failure recorded. Failures older than
+// Reset_after are forgotten before the new one is counted.
+func (l *Limiter) Fail(a Attempts) Attempts {
+	now := l.Clock.Now()
+
+	if now.Sub(a.Last_failure) > l.Config.Reset_after {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.Last_failure = now
+
+	if delay := l.Delay(a.Attempt_key, a.Failures); delay > 0 {
+		a.Locked_until = now.Add(delay)
+	}
+
+	return a
+}
@@ -0,0 +1,144 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lockout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is an in-memory clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+var testConfig = config.LockoutConfig{
+	Enabled:          true,
+	Free_attempts:    3,
+	Ip_free_attempts: 10,
+	Base_delay:       30 * time.Second,
+	Max_delay:        5 * time.Minute,
+	Reset_after:      time.Hour,
+}
+
+func newTestLimiter() (*Limiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return &Limiter{Config: testConfig, Clock: clock}, clock
+}
+
+func TestDelay(t *testing.T) {
+	l, _ := newTestLimiter()
+	account := AccountKey("good@gmail.com")
+
+	var tests = []struct {
+		failures int64
+		delay    time.Duration
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 30 * time.Second},
+		{4, time.Minute},
+		{5, 2 * time.Minute},
+		{6, 4 * time.Minute},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.delay, l.Delay(account, test.failures), "failures: %d", test.failures)
+	}
+
+	// Client IPs get more free attempts than accounts
+	assert.Equal(t, time.Duration(0), l.Delay(IPKey("10.0.0.1"), 9))
+	assert.Equal(t, 30*time.Second, l.Delay(IPKey("10.0.0.1"), 10))
+}
+
+func TestFailLocksAfterFreeAttempts(t *testing.T) {
+	l, clock := newTestLimiter()
+	a := Attempts{Attempt_key: AccountKey("good@gmail.com")}
+
+	for i := 0; i < 2; i++ {
+		a = l.Fail(a)
+		assert.Equal(t, time.Duration(0), l.RetryAfter(a))
+	}
+
+	a = l.Fail(a)
+	assert.Equal(t, 30*time.Second, l.RetryAfter(a))
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, 20*time.Second, l.RetryAfter(a))
+
+	// Once the lock passes, the next failure locks for twice as long
+	clock.Advance(20 * time.Second)
+	assert.Equal(t, time.Duration(0), l.RetryAfter(a))
+
+	a = l.Fail(a)
+	assert.Equal(t, time.Minute, l.RetryAfter(a))
+}
+
+func TestFailResetsAfterQuietPeriod(t *testing.T) {
+	l, clock := newTestLimiter()
+	a := Attempts{Attempt_key: AccountKey("good@gmail.com")}
+
+	for i := 0; i < 3; i++ {
+		a = l.Fail(a)
+	}
+	assert.Equal(t, int64(3), a.Failures)
+
+	clock.Advance(2 * time.Hour)
+	a = l.Fail(a)
+	assert.Equal(t, int64(1), a.Failures)
+	assert.Equal(t, time.Duration(0), l.RetryAfter(a))
+}
+
+func TestRetryAfterUsesLongestLock(t *testing.T) {
+	l, clock := newTestLimiter()
+	now := clock.Now()
+
+	account := Attempts{Attempt_key: AccountKey("good@gmail.com"), Locked_until: now.Add(time.Minute)}
+	ip := Attempts{Attempt_key: IPKey("10.0.0.1"), Locked_until: now.Add(3 * time.Minute)}
+
+	assert.Equal(t, 3*time.Minute, l.RetryAfter(account, ip))
+	assert.Equal(t, time.Duration(0), l.RetryAfter())
+}
+
+func TestDisabledLimiter(t *testing.T) {
+	l, clock := newTestLimiter()
+	l.Config.Enabled = false
+
+	a := Attempts{Attempt_key: AccountKey("good@gmail.com"), Locked_until: clock.Now().Add(time.Minute)}
+	assert.Equal(t, time.Duration(0), l.RetryAfter(a))
+
+	var none *Limiter
+	assert.False(t, none.Enabled())
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, AccountKey("good@gmail.com"), AccountKey("Good@Gmail.com"))
+	assert.NotEqual(t, AccountKey("10.0.0.1"), IPKey("10.0.0.1"))
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mailer delivers email messages to players.
+//
+// The sample does not send real email. Messages are written to the service
+// log or appended to a local file, and other delivery mechanisms can be
+// added by implementing the Mailer interface.
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+)
+
+// Message is a single email to a player
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to players
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// LogMailer writes messages to the service log instead of sending them
+type LogMailer struct{}
+
+// Send prints the message to stdout
+func (LogMailer) Send(ctx context.Context, m Message) error {
+	fmt.Printf("[MAIL] to=%s subject=%q body=%q\n", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// FileMailer appends messages to a local file instead of sending them
+type FileMailer struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Send appends the message to the mailer's file
+func (f *FileMailer) Send(ctx context.Context, m Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open mail file: %s", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), m.To, m.Subject, m.Body)
+	if err != nil {
+		return fmt.Errorf("could not write mail file: %s", err)
+	}
+
+	return nil
+}
+
+// New returns the mailer selected by the email configuration
+func New(c config.EmailConfig) (Mailer, error) {
+	switch c.Mailer {
+	case "", "log":
+		return LogMailer{}, nil
+	case "file":
+		if c.Mailer_file == "" {
+			return nil, fmt.Errorf("mailer 'file' requires a mailer_file")
+		}
+		return &FileMailer{Path: c.Mailer_file}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mailer '%s'", c.Mailer)
+}
@@ -0,0 +1,59 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMailer(t *testing.T) {
+	m, err := New(config.EmailConfig{Mailer: "log"})
+	assert.Nil(t, err)
+	assert.IsType(t, LogMailer{}, m)
+
+	m, err = New(config.EmailConfig{Mailer: "file", Mailer_file: "mail.log"})
+	assert.Nil(t, err)
+	assert.IsType(t, &FileMailer{}, m)
+
+	_, err = New(config.EmailConfig{Mailer: "file"})
+	assert.NotNil(t, err)
+
+	_, err = New(config.EmailConfig{Mailer: "smtp"})
+	assert.NotNil(t, err)
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := &FileMailer{Path: path}
+
+	err := m.Send(context.Background(), Message{To: "good@gmail.com", Subject: "Hello", Body: "first"})
+	assert.Nil(t, err)
+	err = m.Send(context.Background(), Message{To: "good@gmail.com", Subject: "Hello", Body: "second"})
+	assert.Nil(t, err)
+
+	contents, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Contains(t, string(contents), "To: good@gmail.com")
+	assert.Contains(t, string(contents), "first")
+	assert.Contains(t, string(contents), "second")
+}
@@ -17,13 +17,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/auth"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/identity"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/lockout"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/mailer"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/models"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/totp"
 
 	"github.com/gin-gonic/gin"
 )
@@ -51,11 +60,123 @@ func getSpannerConnection(c *gin.Context) (context.Context, spanner.Client) {
 
 }
 
+// requireSession is a middleware that rejects access tokens whose login session was revoked or has expired.
+// It must run after auth.RequireToken. Tokens that weren't issued for a session are let through.
+func requireSession(c *gin.Context) {
+	sessionUUID := auth.SessionUUID(c)
+	if sessionUUID == "" {
+		c.Next()
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.CheckSession(ctx, client, auth.PlayerUUID(c), sessionUUID)
+	if errors.Is(err, models.ErrSessionRevoked) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.Next()
+}
+
+// setConfiguration is a mutator to make the service configuration available in gin
+func setConfiguration(c config.Config) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("configuration", c)
+		ctx.Next()
+	}
+}
+
+// getConfiguration is a helper function to retrieve the service configuration
+func getConfiguration(c *gin.Context) config.Config {
+	return c.MustGet("configuration").(config.Config)
+}
+
+// setMailer is a mutator to make the configured mailer available in gin
+func setMailer(m mailer.Mailer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("mailer", m)
+		ctx.Next()
+	}
+}
+
+// getMailer is a helper function to retrieve the mailer
+func getMailer(c *gin.Context) mailer.Mailer {
+	return c.MustGet("mailer").(mailer.Mailer)
+}
+
+// setLimiter is a mutator to make the login lockout limiter available in gin
+func setLimiter(l *lockout.Limiter) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("limiter", l)
+		ctx.Next()
+	}
+}
+
+// getLimiter is a helper function to retrieve the login lockout limiter
+func getLimiter(c *gin.Context) *lockout.Limiter {
+	return c.MustGet("limiter").(*lockout.Limiter)
+}
+
+// setVerifiers is a mutator to make the identity provider verifiers available in gin
+func setVerifiers(v map[string]*identity.Verifier) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set("verifiers", v)
+		ctx.Next()
+	}
+}
+
+// getVerifiers is a helper function to retrieve the identity provider verifiers
+func getVerifiers(c *gin.Context) map[string]*identity.Verifier {
+	return c.MustGet("verifiers").(map[string]*identity.Verifier)
+}
+
+// searchPlayers responds to the GET /players endpoint
+// Returns a page of players whose name starts with 'name_prefix'. The 'page_token' from a previous
+// response continues the same search, and 'page_size' limits the number of players returned.
+// Requires an access token, but any player can search.
+func searchPlayers(c *gin.Context) {
+	pageSize := models.DefaultPageSize
+	if size := c.Query("page_size"); size != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(size); err != nil || pageSize < 1 || pageSize > models.MaxPageSize {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("page_size must be between 1 and %d", models.MaxPageSize)})
+			return
+		}
+	}
+
+	ctx, client := getSpannerConnection(c)
+	page, err := models.SearchPlayers(ctx, client, c.Query("name_prefix"), c.Query("page_token"), pageSize)
+	if errors.Is(err, models.ErrInvalidPageToken) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, page)
+}
+
 // getPlayerID responds to the GET /players/:id endpoint
 // Returns a player's information when provided a valid player uuid
+// Requires an access token issued to the same player
 func getPlayerByID(c *gin.Context) {
 	var playerUUID = c.Param("id")
 
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
 	ctx, client := getSpannerConnection(c)
 
 	player, err := models.GetPlayerByUUID(ctx, client, playerUUID)
@@ -65,16 +186,123 @@ func getPlayerByID(c *gin.Context) {
 		return
 	}
 
+	c.Header("ETag", player.ETag())
 	c.IndentedJSON(http.StatusOK, player)
 }
 
+// updatePlayer responds to the PATCH /players/:id endpoint
+// Changes a player's player_name and/or email. Requires an access token issued to the same player,
+// and an If-Match header with the ETag returned when the player was retrieved.
+// Returns the updated player with its new ETag, or 412 if the player was modified in the meantime.
+func updatePlayer(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	etag := c.GetHeader("If-Match")
+	if etag == "" {
+		c.IndentedJSON(http.StatusPreconditionRequired, gin.H{"message": "If-Match header is required"})
+		return
+	}
+
+	var update models.PlayerUpdate
+	if err := c.BindJSON(&update); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	err := player.UpdateProfile(ctx, client, etag, update, getConfiguration(c).Email, getMailer(c))
+
+	switch {
+	case errors.Is(err, models.ErrPlayerNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
+		return
+	case errors.Is(err, models.ErrPlayerModified):
+		c.IndentedJSON(http.StatusPreconditionFailed, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrPlayerNameTaken), errors.Is(err, models.ErrEmailTaken):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.Header("ETag", player.ETag())
+	c.IndentedJSON(http.StatusOK, player)
+}
+
+// deletePlayer responds to the DELETE /players/:id endpoint
+// Requires an access token issued to the same player. Deletes the player along with their items,
+// and anonymizes the games and trade orders that still refer to them.
+// Returns a report of what was changed.
+func deletePlayer(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	report, err := models.DeletePlayer(ctx, client, playerUUID)
+	if errors.Is(err, models.ErrPlayerNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
+		return
+	}
+	if err != nil {
+		fmt.Printf("Error: could not delete player '%s' after %d batches: %s\n", playerUUID, report.Batches, err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "could not delete player", "report": report})
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, report)
+}
+
+// playerHeartbeat responds to the PUT /players/:id/heartbeat endpoint
+// Requires an access token issued to the same player. Clients send heartbeats while they are connected,
+// so players that stop sending them can be logged out. Returns 409 if the player is not logged in.
+func playerHeartbeat(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	err := player.Heartbeat(ctx, client, auth.SessionUUID(c))
+	if errors.Is(err, models.ErrPlayerNotLoggedIn) {
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "heartbeat received"})
+}
+
 // playerLogin responds to the PUT /players/login endpoint
-// Login requires 'email' and 'password'
-// Returns player's information on successful login. Returns 404 on failed login.
+// Login requires 'email' and 'password'. An optional 'device' name is recorded with the login session.
+// Returns the player's uuid and access token on successful login. Returns 404 on failed login.
+// Returns 202 with a login challenge when the player has two-factor authentication enabled.
+// Returns 429 with a Retry-After header when too many logins failed for the email or client ip.
 func playerLogin(c *gin.Context) {
 	type PlayerLogin struct {
 		Email    string `json:"email" validate:"required_with=Password"`
 		Password string `json:"password" validate:"required_with=Email"`
+		Device   string `json:"device"`
 	}
 	var pLogin PlayerLogin
 
@@ -88,17 +316,382 @@ func playerLogin(c *gin.Context) {
 
 	// Try to login
 	ctx, client := getSpannerConnection(c)
-	playerUUID, err := models.PlayerLogin(ctx, client, pLogin.Email, pLogin.Password)
+	token, err := models.PlayerLogin(ctx, client, getConfiguration(c), getLimiter(c), c.ClientIP(), pLogin.Email, pLogin.Password,
+		models.SessionInfo{Device: pLogin.Device, User_agent: c.Request.UserAgent()})
+
+	var locked *lockout.LockedError
+	if errors.As(err, &locked) {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"message": "too many failed logins"})
+		return
+	}
+
+	if errors.Is(err, auth.ErrMissingSecret) {
+		fmt.Printf("Error: %s\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "could not issue access token"})
+		return
+	}
+
+	if errors.Is(err, models.ErrEmailNotVerified) {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
+
+	var required *models.TwoFactorRequiredError
+	if errors.As(err, &required) {
+		c.IndentedJSON(http.StatusAccepted, required.Challenge)
+		return
+	}
 
 	if err != nil {
 		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
 		return
 	}
 
-	c.IndentedJSON(http.StatusOK, playerUUID)
+	c.IndentedJSON(http.StatusOK, token)
+}
+
+// twoFactorLogin responds to the PUT /players/login/2fa endpoint
+// Requires the 'challenge' returned by PUT /players/login and a 'code' from the player's authenticator app,
+// or one of their recovery codes. Returns the player's uuid and access token on success.
+// Returns 404 if the challenge is invalid or expired, and 401 if the code does not match.
+// Wrong codes count as failed logins, and 429 with a Retry-After header is returned once the email or client ip is locked.
+func twoFactorLogin(c *gin.Context) {
+	var login models.TwoFactorLogin
+
+	if err := c.BindJSON(&login); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	token, err := models.CompleteTwoFactorLogin(ctx, client, getConfiguration(c), getLimiter(c), c.ClientIP(), login, c.Request.UserAgent())
+
+	var locked *lockout.LockedError
+	switch {
+	case errors.As(err, &locked):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.IndentedJSON(http.StatusTooManyRequests, gin.H{"message": "too many failed logins"})
+		return
+	case errors.Is(err, models.ErrInvalidToken):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrInvalidCode):
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, auth.ErrMissingSecret), errors.Is(err, totp.ErrInvalidKey):
+		fmt.Printf("Error: %s\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "could not complete login"})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, token)
+}
+
+// enrollTwoFactor responds to the POST /players/:id/2fa endpoint
+// Requires an access token issued to the same player. Returns 201 with a new TOTP secret and its otpauth uri.
+// Two-factor authentication is not enabled until the secret is confirmed. Returns 409 if it is already enabled.
+func enrollTwoFactor(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	enrollment, err := player.EnrollTwoFactor(ctx, client, getConfiguration(c).Totp)
+
+	switch {
+	case errors.Is(err, models.ErrPlayerNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
+		return
+	case errors.Is(err, models.ErrTwoFactorEnabled):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, totp.ErrInvalidKey):
+		fmt.Printf("Error: %s\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "two-factor authentication is not configured"})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusCreated, enrollment)
+}
+
+// confirmTwoFactor responds to the POST /players/:id/2fa/confirm endpoint
+// Requires an access token issued to the same player, and a 'code' for the enrolled secret.
+// Enables two-factor authentication and returns the player's recovery codes, which are only shown once.
+// Returns 403 if the code does not match, 404 if the player hasn't enrolled and 409 if it is already enabled.
+func confirmTwoFactor(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	var code models.TwoFactorCode
+	if err := c.BindJSON(&code); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	recovery, err := player.ConfirmTwoFactor(ctx, client, getConfiguration(c).Totp, code)
+
+	switch {
+	case errors.Is(err, models.ErrInvalidCode):
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrTwoFactorNotEnrolled):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrTwoFactorEnabled):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, recovery)
+}
+
+// disableTwoFactor responds to the DELETE /players/:id/2fa endpoint
+// Requires an access token issued to the same player, and a current 'code' or recovery code.
+// Returns 403 if the code does not match, and 404 if two-factor authentication isn't enabled.
+func disableTwoFactor(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	var code models.TwoFactorCode
+	if err := c.BindJSON(&code); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	err := player.DisableTwoFactor(ctx, client, getConfiguration(c).Totp, code)
+
+	switch {
+	case errors.Is(err, models.ErrInvalidCode):
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrTwoFactorNotEnrolled):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+// identityLogin responds to the PUT /players/login/:provider endpoint
+// Login requires an 'id_token' issued by the configured identity provider, and accepts an optional 'device' name.
+// The first login for a provider's subject creates a player and returns 201. Later logins return 200
+// with the same player. Both return the player's uuid and access token. Returns 401 if the ID token is invalid.
+// Returns 202 with a login challenge when the player has two-factor authentication enabled.
+func identityLogin(c *gin.Context) {
+	type IdentityLogin struct {
+		Id_token string `json:"id_token" binding:"required"`
+		Device   string `json:"device"`
+	}
+	var iLogin IdentityLogin
+
+	if err := c.BindJSON(&iLogin); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	provider := c.Param("provider")
+	verifier, ok := getVerifiers(c)[provider]
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": identity.ErrUnknownProvider.Error()})
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	claims, err := verifier.Verify(ctx, iLogin.Id_token)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": identity.ErrInvalidToken.Error()})
+		return
+	}
+
+	token, created, err := models.IdentityLogin(ctx, client, getConfiguration(c), provider, claims,
+		models.SessionInfo{Device: iLogin.Device, User_agent: c.Request.UserAgent()})
+	if errors.Is(err, auth.ErrMissingSecret) {
+		fmt.Printf("Error: %s\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "could not issue access token"})
+		return
+	}
+
+	var required *models.TwoFactorRequiredError
+	if errors.As(err, &required) {
+		c.IndentedJSON(http.StatusAccepted, required.Challenge)
+		return
+	}
+
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	if created {
+		c.IndentedJSON(http.StatusCreated, token)
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, token)
+}
+
+// refreshSession responds to the POST /players/refresh endpoint
+// Exchanges a 'refresh_token' for a new access token and refresh token for the same session.
+// Returns 401 if the refresh token is invalid, its session was revoked or expired, or it was already used.
+// Using a refresh token twice revokes its session.
+func refreshSession(c *gin.Context) {
+	type RefreshRequest struct {
+		Refresh_token string `json:"refresh_token" binding:"required"`
+	}
+	var request RefreshRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	token, err := models.RefreshSession(ctx, client, getConfiguration(c), request.Refresh_token)
+
+	switch {
+	case errors.Is(err, models.ErrRefreshTokenReused):
+		fmt.Printf("Warning: %s, session revoked\n", err)
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, models.ErrInvalidToken):
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": err.Error()})
+		return
+	case errors.Is(err, auth.ErrMissingSecret):
+		fmt.Printf("Error: %s\n", err)
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "could not issue access token"})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, token)
+}
+
+// getPlayerSessions responds to the GET /players/:id/sessions endpoint
+// Requires an access token issued to the same player. Returns the player's active sessions,
+// most recently used first. The session of the access token is marked as current.
+func getPlayerSessions(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	sessions, err := player.GetSessions(ctx, client, auth.SessionUUID(c))
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// revokePlayerSession responds to the DELETE /players/:id/sessions/:session endpoint
+// Requires an access token issued to the same player. The session's refresh token can no longer be used.
+// Returns 404 if the session does not exist or was already revoked.
+func revokePlayerSession(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	err := player.RevokeSession(ctx, client, c.Param("session"))
+	if errors.Is(err, models.ErrSessionNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
+
+// revokePlayerSessions responds to the DELETE /players/:id/sessions endpoint
+// Requires an access token issued to the same player. Revokes every session and logs the player out.
+// Returns the number of sessions revoked.
+func revokePlayerSessions(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	count, err := player.RevokeSessions(ctx, client)
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "sessions revoked", "revoked": count})
 }
 
 // playerLogout responds to the PUT /players/logout endpoint
+// Requires an access token issued to the player being logged out. The token's session is revoked.
 // Return an empty response with a 200 code.
 func playerLogout(c *gin.Context) {
 	var player models.Player
@@ -111,9 +704,13 @@ func playerLogout(c *gin.Context) {
 		return
 	}
 
+	if !auth.Authorize(c, player.PlayerUUID) {
+		return
+	}
+
 	// Try to logout
 	ctx, client := getSpannerConnection(c)
-	err := player.PlayerLogout(ctx, client)
+	err := player.PlayerLogout(ctx, client, auth.SessionUUID(c))
 
 	if err != nil {
 		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "could not log player out"})
@@ -125,6 +722,7 @@ func playerLogout(c *gin.Context) {
 
 // createPlayer responds to the POST /players endpoint
 // When provided the required fields of player_name, email and password, creates a player.
+// A verification link is sent to the player's email.
 func createPlayer(c *gin.Context) {
 	var player models.Player
 
@@ -134,7 +732,7 @@ func createPlayer(c *gin.Context) {
 	}
 
 	ctx, client := getSpannerConnection(c)
-	err := player.AddPlayer(ctx, client)
+	err := player.AddPlayer(ctx, client, getConfiguration(c), getMailer(c))
 	if err != nil {
 		_ = c.AbortWithError(http.StatusBadRequest, err)
 		return
@@ -143,6 +741,159 @@ func createPlayer(c *gin.Context) {
 	c.IndentedJSON(http.StatusCreated, player.PlayerUUID)
 }
 
+// verifyEmail responds to the GET /players/verify endpoint
+// Consumes the single-use 'token' sent to the player's email and marks the email as verified.
+func verifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "token is required"})
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	playerUUID, err := models.VerifyEmail(ctx, client, token)
+	if errors.Is(err, models.ErrInvalidToken) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "email verified", "playerUUID": playerUUID})
+}
+
+// changePassword responds to the PUT /players/:id/password endpoint
+// Requires an access token issued to the same player, along with 'old_password' and 'new_password'.
+// Returns 403 if the old password does not match.
+func changePassword(c *gin.Context) {
+	var playerUUID = c.Param("id")
+
+	if !auth.Authorize(c, playerUUID) {
+		return
+	}
+
+	var change models.PasswordChange
+	if err := c.BindJSON(&change); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	player := models.Player{PlayerUUID: playerUUID}
+	err := player.ChangePassword(ctx, client, getConfiguration(c).Password, change)
+
+	switch {
+	case errors.Is(err, models.ErrPlayerNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "player not found"})
+		return
+	case errors.Is(err, models.ErrWrongPassword):
+		c.IndentedJSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "password changed"})
+}
+
+// requestPasswordReset responds to the POST /players/password/reset endpoint
+// Sends a time-limited reset token to the provided 'email'. Always returns 202 so that
+// the endpoint does not reveal whether the email belongs to a player.
+func requestPasswordReset(c *gin.Context) {
+	type ResetRequest struct {
+		Email string `json:"email" binding:"required"`
+	}
+	var request ResetRequest
+
+	if err := c.BindJSON(&request); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.RequestPasswordReset(ctx, client, getConfiguration(c).Email, getMailer(c), request.Email)
+	if err != nil {
+		fmt.Printf("Error: could not request password reset: %s\n", err)
+	}
+
+	c.IndentedJSON(http.StatusAccepted, gin.H{"message": "if the email belongs to a player, a reset token was sent"})
+}
+
+// resetPassword responds to the POST /players/password/reset/confirm endpoint
+// Consumes the reset 'token' sent to the player's email and sets 'new_password'.
+// Returns 404 if the token is invalid or expired.
+func resetPassword(c *gin.Context) {
+	var reset models.PasswordReset
+
+	if err := c.BindJSON(&reset); err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	ctx, client := getSpannerConnection(c)
+	err := models.ResetPassword(ctx, client, getConfiguration(c).Password, reset)
+	if errors.Is(err, models.ErrInvalidToken) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": err.Error()})
+		return
+	}
+	if err != nil {
+		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
+			fmt.Printf("could not abort: %s", err)
+		}
+		return
+	}
+
+	c.IndentedJSON(http.StatusOK, gin.H{"message": "password reset"})
+}
+
+// runSessionSweeper logs out players that stopped sending heartbeats, every sweep interval until ctx is done.
+// Every replica runs its own sweeper. Logging out a stale player is idempotent, so overlapping sweeps are harmless.
+func runSessionSweeper(ctx context.Context, c config.Config) {
+	if c.Session.Ttl <= 0 || c.Session.Sweep_interval <= 0 {
+		fmt.Println("session sweeper is disabled")
+		return
+	}
+
+	client, err := spanner.NewClient(ctx, c.Spanner.DB())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	ticker := time.NewTicker(c.Session.Sweep_interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			count, err := models.LogoutStalePlayers(ctx, *client, time.Now().Add(-c.Session.Ttl))
+			if err != nil {
+				fmt.Printf("could not log out stale players: %s\n", err)
+				continue
+			}
+
+			if count > 0 {
+				fmt.Printf("logged out %d stale players\n", count)
+			}
+		}
+	}
+}
+
 // main initializes the gin router and configures the endpoints
 func main() {
 	configuration, _ := config.NewConfig()
@@ -155,11 +906,48 @@ func main() {
 	}
 
 	router.Use(setSpannerConnection(configuration))
+	router.Use(setConfiguration(configuration))
+
+	mail, err := mailer.New(configuration.Email)
+	if err != nil {
+		fmt.Printf("could not create mailer: %s", err)
+		return
+	}
+	router.Use(setMailer(mail))
+	router.Use(setLimiter(lockout.New(configuration.Lockout)))
+
+	verifiers, err := identity.NewVerifiers(configuration.Identity)
+	if err != nil {
+		fmt.Printf("could not configure identity providers: %s", err)
+		return
+	}
+	router.Use(setVerifiers(verifiers))
+
+	requireToken := auth.RequireToken(configuration.Auth)
 
 	router.POST("/players", createPlayer)
-	router.GET("/players/:id", getPlayerByID)
+	router.GET("/players", requireToken, requireSession, searchPlayers)
+	router.GET("/players/verify", verifyEmail)
+	router.GET("/players/:id", requireToken, requireSession, getPlayerByID)
+	router.PATCH("/players/:id", requireToken, requireSession, updatePlayer)
+	router.DELETE("/players/:id", requireToken, requireSession, deletePlayer)
+	router.PUT("/players/:id/password", requireToken, requireSession, changePassword)
+	router.POST("/players/password/reset", requestPasswordReset)
+	router.POST("/players/password/reset/confirm", resetPassword)
 	router.PUT("/players/login", playerLogin)
-	router.PUT("/players/logout", playerLogout)
+	router.PUT("/players/login/2fa", twoFactorLogin)
+	router.PUT("/players/login/:provider", identityLogin)
+	router.PUT("/players/logout", requireToken, requireSession, playerLogout)
+	router.POST("/players/refresh", refreshSession)
+	router.GET("/players/:id/sessions", requireToken, requireSession, getPlayerSessions)
+	router.DELETE("/players/:id/sessions", requireToken, requireSession, revokePlayerSessions)
+	router.DELETE("/players/:id/sessions/:session", requireToken, requireSession, revokePlayerSession)
+	router.PUT("/players/:id/heartbeat", requireToken, requireSession, playerHeartbeat)
+	router.POST("/players/:id/2fa", requireToken, requireSession, enrollTwoFactor)
+	router.POST("/players/:id/2fa/confirm", requireToken, requireSession, confirmTwoFactor)
+	router.DELETE("/players/:id/2fa", requireToken, requireSession, disableTwoFactor)
+
+	go runSessionSweeper(context.Background(), configuration)
 
 	if err := router.Run(configuration.Server.URL()); err != nil {
 		fmt.Printf("could not run gin router: %s", err)
@@ -19,6 +19,10 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base32"
+	"encoding/base64"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 
@@ -27,6 +31,8 @@ import (
 	"embed"
 	"fmt"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/models"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/totp"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	databasepb "google.golang.org/genproto/googleapis/spanner/admin/database/v1"
@@ -34,10 +40,13 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 //go:embed test_data/schema.sql
@@ -45,6 +54,18 @@ var SCHEMAFILE embed.FS
 
 var TESTNETWORK = "game-sample-test"
 
+var TESTSECRET = "integration-test-secret"
+
+// TESTTOTPKEY encrypts TOTP secrets in the service under test
+var TESTTOTPKEY = base64.StdEncoding.EncodeToString([]byte("integration-test-totp-key-32byte"))
+
+var TESTISSUER = "https://issuer.example.com"
+
+var TESTAUDIENCE = "spanner-gaming-sample"
+
+// identityKey signs ID tokens for the 'test' identity provider configured in setupIdentityProvider
+var identityKey *rsa.PrivateKey
+
 // These integration tests run against the Spanner emulator. The emulator
 // must be running and accessible prior to integration tests running.
 
@@ -197,7 +218,40 @@ func setupDatabase(ctx context.Context, ec Emulator) error {
 
 }
 
-func setupService(ctx context.Context, ec *Emulator) (*Service, error) {
+// setupIdentityProvider creates a signing key for a 'test' identity provider, and returns
+// a JWKS file and config.yml for the service that trust it
+func setupIdentityProvider() ([]testcontainers.ContainerFile, error) {
+	var err error
+	identityKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "identity")
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "test-key", "use": "sig", "n": "%s", "e": "%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(identityKey.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(identityKey.E)).Bytes()))
+	if err := os.WriteFile(filepath.Join(dir, "jwks.json"), []byte(jwks), 0644); err != nil {
+		return nil, err
+	}
+
+	configYml := fmt.Sprintf("identity:\n  providers:\n    test:\n      issuer: %s\n      audience: %s\n      jwks_file: /jwks.json\n",
+		TESTISSUER, TESTAUDIENCE)
+	if err := os.WriteFile(filepath.Join(dir, "config.yml"), []byte(configYml), 0644); err != nil {
+		return nil, err
+	}
+
+	return []testcontainers.ContainerFile{
+		{HostFilePath: filepath.Join(dir, "jwks.json"), ContainerFilePath: "/jwks.json", FileMode: 0644},
+		{HostFilePath: filepath.Join(dir, "config.yml"), ContainerFilePath: "/config.yml", FileMode: 0644},
+	}, nil
+}
+
+func setupService(ctx context.Context, ec *Emulator, files []testcontainers.ContainerFile) (*Service, error) {
 	var service = "profile-service"
 	req := testcontainers.ContainerRequest{
 		Image:        fmt.Sprintf("%s:latest", service),
@@ -216,7 +270,10 @@ func setupService(ctx context.Context, ec *Emulator) (*Service, error) {
 			"SERVICE_HOST":          "0.0.0.0",
 			"SERVICE_PORT":          "80",
 			"SPANNER_EMULATOR_HOST": ec.Endpoint,
+			"AUTH_SECRET":           TESTSECRET,
+			"TOTP_ENCRYPTION_KEY":   TESTTOTPKEY,
 		},
+		Files:      files,
 		WaitingFor: wait.ForLog("Listening and serving HTTP on 0.0.0.0:80"),
 	}
 	serviceContainer, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
@@ -240,13 +297,20 @@ func setupService(ctx context.Context, ec *Emulator) (*Service, error) {
 }
 
 func httpPUT(url string, data io.Reader) (*http.Response, error) {
+	return httpRequest(http.MethodPut, url, data, "")
+}
+
+func httpRequest(method string, url string, data io.Reader, token string) (*http.Response, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPut, url, data)
+	req, err := http.NewRequest(method, url, data)
 	if err != nil {
 		return nil, err
 	}
 	// set the request header Content-Type for json
 	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -267,10 +331,17 @@ var test_players = []TestPlayer{
 		Password:    "insecure_password",
 		Player_name: "test player",
 	},
+	{
+		Email:       "test2@gmail.com",
+		Password:    "insecure_password2",
+		Player_name: "test player 2",
+	},
 }
 
 var playerUUIDs []string
 
+var playerTokens = map[string]string{}
+
 func TestMain(m *testing.M) {
 	ctx := context.Background()
 
@@ -291,8 +362,15 @@ func TestMain(m *testing.M) {
 		os.Exit(1)
 	}
 
+	// Trust a local identity provider
+	files, err := setupIdentityProvider()
+	if err != nil {
+		fmt.Printf("Error setting up identity provider: %s\n", err)
+		os.Exit(1)
+	}
+
 	// Run service
-	service, err := setupService(ctx, spannerEmulator)
+	service, err := setupService(ctx, spannerEmulator, files)
 	if err != nil {
 		fmt.Printf("Error setting up service: %s\n", err)
 		os.Exit(1)
@@ -334,30 +412,32 @@ func TestAddPlayers(t *testing.T) {
 
 func TestGetPlayers(t *testing.T) {
 	assert.NotNil(t, playerUUIDs)
-	// For each added uuid, get that data and validate response code (assuming the result was not empty)
-	if len(playerUUIDs) != 0 {
-		for _, pUUID := range playerUUIDs {
-			response, err := http.Get(fmt.Sprintf("http://localhost/players/%s", pUUID))
-			if err != nil {
-				t.Fatal(err.Error())
-			}
-
-			body, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				t.Fatal(err.Error())
-			}
-
-			var pData models.Player
-			json.Unmarshal(body, &pData)
-
-			assert.NotEmpty(t, pData.PlayerUUID)
-			assert.NotEmpty(t, pData.Email)
-			assert.False(t, pData.Is_logged_in)
-			assert.NotEmpty(t, pData.Stats)
+	// Players can't be retrieved without an access token
+	for _, pUUID := range playerUUIDs {
+		response, err := http.Get(fmt.Sprintf("http://localhost/players/%s", pUUID))
+		if err != nil {
+			t.Fatal(err.Error())
 		}
+		assert.Equal(t, 401, response.StatusCode)
 	}
 }
 
+func TestVerifyEmail(t *testing.T) {
+	// A token is required
+	response, err := http.Get("http://localhost/players/verify")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 400, response.StatusCode)
+
+	// Unknown tokens are rejected
+	response, err = http.Get("http://localhost/players/verify?token=not-a-real-token")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
 func TestPlayerLogin(t *testing.T) {
 	for _, p := range test_players {
 		pJson, err := json.Marshal(p)
@@ -373,17 +453,20 @@ func TestPlayerLogin(t *testing.T) {
 		assert.Equal(t, 200, response.StatusCode)
 
 		// Check playerUUID from response validate player is_logged_in=true
-		var pUUID string
+		var token models.AccessToken
 		body, err := ioutil.ReadAll(response.Body)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
-		json.Unmarshal(body, &pUUID)
+		json.Unmarshal(body, &token)
+		assert.NotEmpty(t, token.AccessToken)
+		playerTokens[token.PlayerUUID] = token.AccessToken
 
-		response, err = http.Get(fmt.Sprintf("http://localhost/players/%s", pUUID))
+		response, err = httpRequest(http.MethodGet, fmt.Sprintf("http://localhost/players/%s", token.PlayerUUID), nil, token.AccessToken)
 		if err != nil {
 			t.Fatal(err.Error())
 		}
+		assert.Equal(t, 200, response.StatusCode)
 
 		body, err = ioutil.ReadAll(response.Body)
 		if err != nil {
@@ -393,6 +476,8 @@ func TestPlayerLogin(t *testing.T) {
 		var pData models.Player
 		json.Unmarshal(body, &pData)
 
+		assert.NotEmpty(t, pData.Email)
+		assert.NotEmpty(t, pData.Stats)
 		assert.True(t, pData.Is_logged_in)
 
 		// check bad password
@@ -413,15 +498,637 @@ func TestPlayerLogin(t *testing.T) {
 	}
 }
 
+func TestSearchPlayers(t *testing.T) {
+	token := playerTokens[playerUUIDs[0]]
+
+	search := func(query string, token string) (*http.Response, models.PlayerPage) {
+		response, err := httpRequest(http.MethodGet, "http://localhost/players?"+query, nil, token)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var page models.PlayerPage
+		json.Unmarshal(body, &page)
+		return response, page
+	}
+
+	// Searching requires an access token
+	response, _ := search("name_prefix=test", "")
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, _ = search("name_prefix=test&page_size=0", token)
+	assert.Equal(t, 400, response.StatusCode)
+
+	// Page through the players one at a time
+	response, page := search("name_prefix=test+player&page_size=1", token)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Len(t, page.Players, 1)
+	assert.Equal(t, test_players[0].Player_name, page.Players[0].Player_name)
+	assert.NotEmpty(t, page.Next_page_token)
+
+	response, page = search("name_prefix=test+player&page_size=1&page_token="+page.Next_page_token, token)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Len(t, page.Players, 1)
+	assert.Equal(t, test_players[1].Player_name, page.Players[0].Player_name)
+	assert.Empty(t, page.Next_page_token)
+
+	// Page tokens only continue the search they came from
+	_, page = search("name_prefix=test+player&page_size=1", token)
+	response, _ = search("name_prefix=other&page_token="+page.Next_page_token, token)
+	assert.Equal(t, 400, response.StatusCode)
+
+	response, page = search("name_prefix=nobody", token)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Empty(t, page.Players)
+}
+
+func TestUpdatePlayer(t *testing.T) {
+	if len(playerUUIDs) < 2 {
+		t.Fatal("expected at least two players")
+	}
+	pUUID := playerUUIDs[0]
+	url := fmt.Sprintf("http://localhost/players/%s", pUUID)
+	token := playerTokens[pUUID]
+
+	// Get the current ETag for the player
+	response, err := httpRequest(http.MethodGet, url, nil, token)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+	etag := response.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	patch := func(body string, ifMatch string) *http.Response {
+		req, err := http.NewRequest(http.MethodPatch, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return response
+	}
+
+	// If-Match is required
+	assert.Equal(t, 428, patch(`{"player_name": "renamed player"}`, "").StatusCode)
+
+	// Another player's name or email is a conflict
+	assert.Equal(t, 409, patch(fmt.Sprintf(`{"player_name": "%s"}`, test_players[1].Player_name), etag).StatusCode)
+	assert.Equal(t, 409, patch(fmt.Sprintf(`{"email": "%s"}`, test_players[1].Email), etag).StatusCode)
+
+	// A valid update returns a new ETag
+	response = patch(`{"player_name": "renamed player"}`, etag)
+	assert.Equal(t, 200, response.StatusCode)
+	newETag := response.Header.Get("ETag")
+	assert.NotEmpty(t, newETag)
+	assert.NotEqual(t, etag, newETag)
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var pData models.Player
+	json.Unmarshal(body, &pData)
+	assert.Equal(t, "renamed player", pData.Player_name)
+
+	// Updating with the stale ETag is rejected
+	assert.Equal(t, 412, patch(`{"player_name": "stale update"}`, etag).StatusCode)
+}
+
+func TestChangePassword(t *testing.T) {
+	pUUID := playerUUIDs[0]
+	url := fmt.Sprintf("http://localhost/players/%s/password", pUUID)
+	token := playerTokens[pUUID]
+
+	// Changing the password requires the current password
+	response, err := httpRequest(http.MethodPut, url, strings.NewReader(`{"old_password": "wrong password", "new_password": "newpassword"}`), token)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	body := fmt.Sprintf(`{"old_password": "%s", "new_password": "newpassword"}`, test_players[0].Password)
+	response, err = httpRequest(http.MethodPut, url, strings.NewReader(body), token)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// The new password can be used to login, the old one can't
+	response, err = httpPUT("http://localhost/players/login", strings.NewReader(fmt.Sprintf(`{"email": "%s", "password": "newpassword"}`, test_players[0].Email)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, err = httpPUT("http://localhost/players/login", strings.NewReader(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, test_players[0].Email, test_players[0].Password)))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
+func TestResetPassword(t *testing.T) {
+	// Unknown emails are accepted so they can't be discovered
+	response, err := httpRequest(http.MethodPost, "http://localhost/players/password/reset", strings.NewReader(`{"email": "nobody@gmail.com"}`), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 202, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPost, "http://localhost/players/password/reset", strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, test_players[1].Email)), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 202, response.StatusCode)
+
+	// Unknown tokens are rejected
+	response, err = httpRequest(http.MethodPost, "http://localhost/players/password/reset/confirm", strings.NewReader(`{"token": "not-a-real-token", "new_password": "newpassword"}`), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
+func TestPlayerHeartbeat(t *testing.T) {
+	pUUID := playerUUIDs[0]
+	url := fmt.Sprintf("http://localhost/players/%s/heartbeat", pUUID)
+
+	// Heartbeats require the player's access token
+	response, err := httpRequest(http.MethodPut, url, nil, playerTokens[playerUUIDs[1]])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	response, err = httpRequest(http.MethodPut, url, nil, playerTokens[pUUID])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// The heartbeat is recorded on the player
+	response, err = httpRequest(http.MethodGet, fmt.Sprintf("http://localhost/players/%s", pUUID), nil, playerTokens[pUUID])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var pData models.Player
+	json.Unmarshal(body, &pData)
+	assert.True(t, pData.Last_seen.Valid)
+}
+
+func TestTwoFactor(t *testing.T) {
+	pUUID := playerUUIDs[1]
+	token := playerTokens[pUUID]
+	url := fmt.Sprintf("http://localhost/players/%s/2fa", pUUID)
+	credentials := fmt.Sprintf(`{"email": "%s", "password": "%s"}`, test_players[1].Email, test_players[1].Password)
+
+	// request is a helper to send a request and decode the response body into v
+	request := func(method string, url string, body string, token string, v interface{}) *http.Response {
+		response, err := httpRequest(method, url, strings.NewReader(body), token)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if v != nil {
+			json.Unmarshal(data, v)
+		}
+		return response
+	}
+
+	// Enrolling requires the player's access token
+	response := request(http.MethodPost, url, "", playerTokens[playerUUIDs[0]], nil)
+	assert.Equal(t, 403, response.StatusCode)
+
+	var enrollment models.TwoFactorEnrollment
+	response = request(http.MethodPost, url, "", token, &enrollment)
+	assert.Equal(t, 201, response.StatusCode)
+	assert.Contains(t, enrollment.Otpauth_uri, "otpauth://totp/")
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Until it is confirmed, the password is enough to login
+	response = request(http.MethodPut, "http://localhost/players/login", credentials, "", nil)
+	assert.Equal(t, 200, response.StatusCode)
+
+	step := totp.Step(time.Now())
+	response = request(http.MethodPost, url+"/confirm", fmt.Sprintf(`{"code": "%s"}`, totp.Code(secret, step-10)), token, nil)
+	assert.Equal(t, 403, response.StatusCode)
+
+	code := totp.Code(secret, step)
+	var recovery models.RecoveryCodes
+	response = request(http.MethodPost, url+"/confirm", fmt.Sprintf(`{"code": "%s"}`, code), token, &recovery)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Len(t, recovery.Recovery_codes, 10)
+
+	response = request(http.MethodPost, url, "", token, nil)
+	assert.Equal(t, 409, response.StatusCode)
+
+	// Now the password only returns a challenge
+	var challenge models.LoginChallenge
+	response = request(http.MethodPut, "http://localhost/players/login", credentials, "", &challenge)
+	assert.Equal(t, 202, response.StatusCode)
+	assert.Equal(t, pUUID, challenge.PlayerUUID)
+	assert.NotEmpty(t, challenge.Challenge)
+
+	// A code that was already used can't be used again
+	response = request(http.MethodPut, "http://localhost/players/login/2fa", fmt.Sprintf(`{"challenge": "%s", "code": "%s"}`, challenge.Challenge, code), "", nil)
+	assert.Equal(t, 401, response.StatusCode)
+
+	var accessToken models.AccessToken
+	response = request(http.MethodPut, "http://localhost/players/login/2fa", fmt.Sprintf(`{"challenge": "%s", "code": "%s"}`, challenge.Challenge, recovery.Recovery_codes[0]), "", &accessToken)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, pUUID, accessToken.PlayerUUID)
+	assert.NotEmpty(t, accessToken.AccessToken)
+
+	// The challenge and the recovery code can only be used once
+	response = request(http.MethodPut, "http://localhost/players/login/2fa", fmt.Sprintf(`{"challenge": "%s", "code": "%s"}`, challenge.Challenge, recovery.Recovery_codes[1]), "", nil)
+	assert.Equal(t, 404, response.StatusCode)
+
+	response = request(http.MethodPut, "http://localhost/players/login", credentials, "", &challenge)
+	assert.Equal(t, 202, response.StatusCode)
+	response = request(http.MethodPut, "http://localhost/players/login/2fa", fmt.Sprintf(`{"challenge": "%s", "code": "%s"}`, challenge.Challenge, recovery.Recovery_codes[0]), "", nil)
+	assert.Equal(t, 401, response.StatusCode)
+
+	// Disabling requires a code, after which the password is enough again
+	response = request(http.MethodDelete, url, `{"code": "wrong-code"}`, accessToken.AccessToken, nil)
+	assert.Equal(t, 403, response.StatusCode)
+
+	response = request(http.MethodDelete, url, fmt.Sprintf(`{"code": "%s"}`, recovery.Recovery_codes[1]), accessToken.AccessToken, nil)
+	assert.Equal(t, 200, response.StatusCode)
+
+	response = request(http.MethodPut, "http://localhost/players/login", credentials, "", nil)
+	assert.Equal(t, 200, response.StatusCode)
+}
+
+func TestPlayerSessions(t *testing.T) {
+	pUUID := playerUUIDs[1]
+	url := fmt.Sprintf("http://localhost/players/%s/sessions", pUUID)
+
+	// login is a helper to log the player in from a named device
+	login := func(device string) models.AccessToken {
+		body := fmt.Sprintf(`{"email": "%s", "password": "%s", "device": "%s"}`, test_players[1].Email, test_players[1].Password, device)
+		response, err := httpPUT("http://localhost/players/login", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 200, response.StatusCode)
+
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var token models.AccessToken
+		json.Unmarshal(data, &token)
+		return token
+	}
+
+	// refresh is a helper to exchange a refresh token
+	refresh := func(refreshToken string) (*http.Response, models.AccessToken) {
+		response, err := httpRequest(http.MethodPost, "http://localhost/players/refresh", strings.NewReader(fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)), "")
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var token models.AccessToken
+		json.Unmarshal(data, &token)
+		return response, token
+	}
+
+	phone := login("phone")
+	laptop := login("laptop")
+	assert.NotEmpty(t, phone.Refresh_token)
+	assert.NotEqual(t, phone.SessionUUID, laptop.SessionUUID)
+
+	// Listing sessions requires the player's access token
+	response, err := httpRequest(http.MethodGet, url, nil, playerTokens[playerUUIDs[0]])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	response, err = httpRequest(http.MethodGet, url, nil, phone.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var list struct {
+		Sessions []models.Session `json:"sessions"`
+	}
+	json.Unmarshal(data, &list)
+
+	devices := map[string]models.Session{}
+	for _, session := range list.Sessions {
+		devices[session.Device.StringVal] = session
+	}
+	assert.Equal(t, phone.SessionUUID, devices["phone"].SessionUUID)
+	assert.True(t, devices["phone"].Current)
+	assert.Equal(t, laptop.SessionUUID, devices["laptop"].SessionUUID)
+	assert.False(t, devices["laptop"].Current)
+
+	// Refreshing rotates the refresh token within the same session
+	response, rotated := refresh(phone.Refresh_token)
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, phone.SessionUUID, rotated.SessionUUID)
+	assert.NotEqual(t, phone.Refresh_token, rotated.Refresh_token)
+	assert.NotEmpty(t, rotated.AccessToken)
+
+	// Reusing the old refresh token revokes the session, so the new one stops working too
+	response, _ = refresh(phone.Refresh_token)
+	assert.Equal(t, 401, response.StatusCode)
+	response, _ = refresh(rotated.Refresh_token)
+	assert.Equal(t, 401, response.StatusCode)
+
+	// A single session can be revoked
+	response, err = httpRequest(http.MethodDelete, url+"/"+laptop.SessionUUID, nil, laptop.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// The revoked session's access token stops working, so another session is needed to revoke it again
+	response, err = httpRequest(http.MethodDelete, url+"/"+laptop.SessionUUID, nil, laptop.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpRequest(http.MethodDelete, url+"/"+laptop.SessionUUID, nil, login("desktop").AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+
+	response, _ = refresh(laptop.Refresh_token)
+	assert.Equal(t, 401, response.StatusCode)
+
+	// Or all of them at once
+	tablet := login("tablet")
+	response, err = httpRequest(http.MethodDelete, url, nil, tablet.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, _ = refresh(tablet.Refresh_token)
+	assert.Equal(t, 401, response.StatusCode)
+
+	// Access tokens of revoked sessions are rejected straight away, including the one from the first login
+	for _, token := range []string{tablet.AccessToken, playerTokens[pUUID]} {
+		response, err = httpRequest(http.MethodGet, url, nil, token)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 401, response.StatusCode)
+	}
+
+	// Log in again for the tests that follow
+	playerTokens[pUUID] = login("desktop").AccessToken
+}
+
 func TestPlayerLogout(t *testing.T) {
-	// For each added uuid, get that data and validate response code (assuming the result was not empty)
-	if len(playerUUIDs) != 0 {
-		for _, pUUID := range playerUUIDs {
-			response, err := http.Get(fmt.Sprintf("http://localhost/players/%s", pUUID))
-			if err != nil {
-				t.Fatal(err.Error())
-			}
-			assert.Equal(t, 200, response.StatusCode)
+	for _, pUUID := range playerUUIDs {
+		pJson, err := json.Marshal(models.Player{PlayerUUID: pUUID})
+		if err != nil {
+			t.Fatal(err.Error())
 		}
+
+		// Logging out requires the player's access token
+		response, err := httpPUT("http://localhost/players/logout", bytes.NewBuffer(pJson))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 401, response.StatusCode)
+
+		response, err = httpRequest(http.MethodPut, "http://localhost/players/logout", bytes.NewBuffer(pJson), playerTokens[pUUID])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 200, response.StatusCode)
+
+		// Logging out revokes the token's session, so it can't be used to send heartbeats
+		response, err = httpRequest(http.MethodPut, fmt.Sprintf("http://localhost/players/%s/heartbeat", pUUID), nil, playerTokens[pUUID])
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 401, response.StatusCode)
 	}
 }
+
+func TestDeletePlayer(t *testing.T) {
+	p := TestPlayer{Email: "deleted@gmail.com", Password: "insecure_password3", Player_name: "deleted player"}
+	pJson, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	response, err := http.Post("http://localhost/players", "application/json", bytes.NewBuffer(pJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	response, err = httpPUT("http://localhost/players/login", bytes.NewBuffer(pJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var token models.AccessToken
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &token)
+
+	url := fmt.Sprintf("http://localhost/players/%s", token.PlayerUUID)
+
+	// Only the player can delete themselves
+	response, err = httpRequest(http.MethodDelete, url, nil, playerTokens[playerUUIDs[0]])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 403, response.StatusCode)
+
+	response, err = httpRequest(http.MethodDelete, url, nil, token.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	var report models.DeletionReport
+	body, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(body, &report)
+	assert.Equal(t, token.PlayerUUID, report.PlayerUUID)
+	assert.Equal(t, 1, report.Batches)
+
+	// The player is gone, along with the sessions their access token was issued for
+	response, err = httpRequest(http.MethodDelete, url, nil, token.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, err = httpPUT("http://localhost/players/login", bytes.NewBuffer(pJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 404, response.StatusCode)
+}
+
+func TestLoginLockout(t *testing.T) {
+	pJson := []byte(`{"email": "locked@gmail.com", "password": "wrong password"}`)
+
+	// Failures up to the free attempts are reported as a failed login
+	for i := 0; i < 5; i++ {
+		response, err := httpPUT("http://localhost/players/login", bytes.NewBuffer(pJson))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 404, response.StatusCode)
+	}
+
+	// Then the account is locked
+	response, err := httpPUT("http://localhost/players/login", bytes.NewBuffer(pJson))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 429, response.StatusCode)
+	assert.NotEmpty(t, response.Header.Get("Retry-After"))
+}
+
+// idToken is a helper to sign an ID token from the test identity provider
+func idToken(t *testing.T, subject string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    TESTISSUER,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{TESTAUDIENCE},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	token.Header["kid"] = "test-key"
+
+	signed, err := token.SignedString(identityKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return signed
+}
+
+func TestIdentityLogin(t *testing.T) {
+	login := func(provider string, token string) (*http.Response, models.AccessToken) {
+		body := fmt.Sprintf(`{"id_token": "%s"}`, token)
+		response, err := httpPUT("http://localhost/players/login/"+provider, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		data, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		var accessToken models.AccessToken
+		json.Unmarshal(data, &accessToken)
+		return response, accessToken
+	}
+
+	// The first login creates the player
+	response, first := login("test", idToken(t, "subject-1"))
+	assert.Equal(t, 201, response.StatusCode)
+	assert.NotEmpty(t, first.PlayerUUID)
+	assert.NotEmpty(t, first.AccessToken)
+
+	// Later logins reuse the player
+	response, second := login("test", idToken(t, "subject-1"))
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, first.PlayerUUID, second.PlayerUUID)
+
+	// The access token works like one from a password login
+	response, err := httpRequest(http.MethodGet, fmt.Sprintf("http://localhost/players/%s", first.PlayerUUID), nil, second.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	// Another subject is another player
+	response, other := login("test", idToken(t, "subject-2"))
+	assert.Equal(t, 201, response.StatusCode)
+	assert.NotEqual(t, first.PlayerUUID, other.PlayerUUID)
+
+	// Once the player enables two-factor authentication, the ID token only returns a challenge
+	url := fmt.Sprintf("http://localhost/players/%s/2fa", other.PlayerUUID)
+	response, err = httpRequest(http.MethodPost, url, nil, other.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 201, response.StatusCode)
+
+	var enrollment models.TwoFactorEnrollment
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	json.Unmarshal(data, &enrollment)
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	code := fmt.Sprintf(`{"code": "%s"}`, totp.Code(secret, totp.Step(time.Now())))
+	response, err = httpRequest(http.MethodPost, url+"/confirm", strings.NewReader(code), other.AccessToken)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, 200, response.StatusCode)
+
+	response, challenged := login("test", idToken(t, "subject-2"))
+	assert.Equal(t, 202, response.StatusCode)
+	assert.Equal(t, other.PlayerUUID, challenged.PlayerUUID)
+	assert.Empty(t, challenged.AccessToken)
+
+	response, _ = login("test", "not-a-token")
+	assert.Equal(t, 401, response.StatusCode)
+
+	response, _ = login("unknown", idToken(t, "subject-1"))
+	assert.Equal(t, 404, response.StatusCode)
+}
@@ -0,0 +1,528 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+
+	spanner "cloud.google.com/go/spanner"
+	"google.golang.org/grpc/codes"
+)
+
+// DeletedPlayerUUID replaces a deleted player's uuid wherever other players' data still refers to it
+const DeletedPlayerUUID = "00000000-0000-0000-0000-000000000000"
+
+// deletionBatchSize bounds the number of rows of each kind changed in a single transaction
+const deletionBatchSize = 100
+
+// DeletionReport describes what was changed to delete a player
+type DeletionReport struct {
+	PlayerUUID              string `json:"playerUUID"`
+	Games_anonymized        int    `json:"games_anonymized"`
+	Participants_deleted    int    `json:"participants_deleted"`
+	Trade_orders_anonymized int    `json:"trade_orders_anonymized"`
+	Trade_orders_deleted    int    `json:"trade_orders_deleted"`
+	Party_invites_deleted   int    `json:"party_invites_deleted"`
+	Lobbies_rehosted        int    `json:"lobbies_rehosted"`
+	Lobby_members_deleted   int    `json:"lobby_members_deleted"`
+	Batches                 int    `json:"batches"`
+}
+
+// anonymizePlayers is a private helper to replace a player's uuid in a game's list of players
+func anonymizePlayers(players []string, playerUUID string) []string {
+	anonymized := make([]string, len(players))
+	for i, p := range players {
+		if p == playerUUID {
+			p = DeletedPlayerUUID
+		}
+		anonymized[i] = p
+	}
+
+	return anonymized
+}
+
+// anonymizePlayer is a private helper to replace a nullable reference to a player
+func anonymizePlayer(ref spanner.NullString, playerUUID string) spanner.NullString {
+	if ref.Valid && ref.StringVal == playerUUID {
+		return spanner.NullString{StringVal: DeletedPlayerUUID, Valid: true}
+	}
+
+	return ref
+}
+
+// anonymizeGames buffers updates replacing the player in games they played or won, and deletes of their
+// game participant rows, which hold their per-player results. The games are found through the player's
+// participant rows, so only the player's own games are read. Their games keep the anonymized reference
+// in the players array. Returns the number of games changed.
+func anonymizeGames(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT g.gameUUID, g.players, g.winner FROM game_participants@{FORCE_INDEX=GameParticipantPlayer} gp
+				JOIN games g ON g.gameUUID = gp.gameUUID
+				WHERE gp.playerUUID = @player LIMIT @limit`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  deletionBatchSize,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetPlayerParticipantGames"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return 0, err
+	}
+
+	var m []*spanner.Mutation
+	for _, row := range rows {
+		var gameUUID string
+		var players []string
+		var winner spanner.NullString
+		if err := row.Columns(&gameUUID, &players, &winner); err != nil {
+			return 0, err
+		}
+
+		cols := []string{"gameUUID", "players", "winner"}
+		m = append(m, spanner.Update("games", cols,
+			[]interface{}{gameUUID, anonymizePlayers(players, playerUUID), anonymizePlayer(winner, playerUUID)}))
+
+		// The participant row is deleted with the update, so the game isn't found again in the next batch
+		m = append(m, spanner.Delete("game_participants", spanner.Key{gameUUID, playerUUID}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return 0, fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return len(rows), nil
+}
+
+// nextLeader is a private helper that returns the leader of a party or lobby once the player has left it.
+// Leadership is handed to the first remaining member when the leader leaves. Returns an empty string
+// when no members remain.
+func nextLeader(members []string, leader string, playerUUID string) string {
+	var remaining []string
+	for _, m := range members {
+		if m != playerUUID {
+			remaining = append(remaining, m)
+		}
+	}
+
+	switch {
+	case len(remaining) == 0:
+		return ""
+	case leader == playerUUID:
+		return remaining[0]
+	}
+
+	return leader
+}
+
+// leaveParty buffers the changes to remove the player from their party, if they are in one.
+// The party is deleted with its invites when no other members remain, and the party's queued tickets
+// are cancelled, since the party can no longer be matched as it was queued.
+func leaveParty(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) error {
+	row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"party"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerParty"})
+	if err != nil {
+		return err
+	}
+
+	var partyUUID spanner.NullString
+	if err := row.Column(0, &partyUUID); err != nil {
+		return err
+	}
+
+	if !partyUUID.Valid {
+		return nil
+	}
+
+	row, err = txn.ReadRowWithOptions(ctx, "parties", spanner.Key{partyUUID.StringVal}, []string{"leaderUUID"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=GetParty"})
+	if err != nil && spanner.ErrCode(err) != codes.NotFound {
+		return err
+	}
+
+	var leader string
+	if err == nil {
+		if err := row.Column(0, &leader); err != nil {
+			return err
+		}
+	}
+
+	stmt := spanner.Statement{
+		SQL: `SELECT playerUUID FROM players@{FORCE_INDEX=PlayerParty} WHERE party = @party ORDER BY playerUUID`,
+		Params: map[string]interface{}{
+			"party": partyUUID.StringVal,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetPartyMembers"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	for _, row := range rows {
+		var member string
+		if err := row.Columns(&member); err != nil {
+			return err
+		}
+		members = append(members, member)
+	}
+
+	stmt = spanner.Statement{
+		SQL: `UPDATE matchmaking_tickets SET status = 'cancelled', updated = CURRENT_TIMESTAMP()
+				WHERE party = @party AND status = 'queued'`,
+		Params: map[string]interface{}{
+			"party": partyUUID.StringVal,
+		},
+	}
+
+	if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=CancelPartyTickets"}); err != nil {
+		return err
+	}
+
+	m := []*spanner.Mutation{
+		spanner.Update("players", []string{"playerUUID", "party"}, []interface{}{playerUUID, spanner.NullString{}}),
+	}
+
+	switch newLeader := nextLeader(members, leader, playerUUID); {
+	case newLeader == "":
+		m = append(m, spanner.Delete("parties", spanner.Key{partyUUID.StringVal}))
+	case newLeader != leader:
+		m = append(m, spanner.Update("parties", []string{"partyUUID", "leaderUUID"}, []interface{}{partyUUID.StringVal, newLeader}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return nil
+}
+
+// deletePartyInvites buffers deletes of the invites the player received to join other players' parties.
+// Returns the number of invites deleted.
+func deletePartyInvites(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT partyUUID FROM party_invites@{FORCE_INDEX=PartyInvitePlayer} WHERE playerUUID = @player LIMIT @limit`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  deletionBatchSize,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetPlayerPartyInvites"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return 0, err
+	}
+
+	var m []*spanner.Mutation
+	for _, row := range rows {
+		var partyUUID string
+		if err := row.Columns(&partyUUID); err != nil {
+			return 0, err
+		}
+
+		m = append(m, spanner.Delete("party_invites", spanner.Key{partyUUID, playerUUID}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return 0, fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return len(rows), nil
+}
+
+// rehostLobbies buffers the changes to lobbies the player hosts. A lobby that hasn't started is handed to the
+// member who joined first and wasn't kicked, or deleted with its members when no one else is in it. Started lobbies
+// only keep the game for their players, so the host is replaced with DeletedPlayerUUID.
+// Returns the number of lobbies changed.
+func rehostLobbies(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT lobbyUUID, gameUUID FROM lobbies@{FORCE_INDEX=LobbyHost} WHERE hostUUID = @player LIMIT @limit`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  deletionBatchSize,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetHostedLobbies"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return 0, err
+	}
+
+	var m []*spanner.Mutation
+	var open []string
+	for _, row := range rows {
+		var lobbyUUID string
+		var gameUUID spanner.NullString
+		if err := row.Columns(&lobbyUUID, &gameUUID); err != nil {
+			return 0, err
+		}
+
+		if gameUUID.Valid {
+			m = append(m, spanner.Update("lobbies", []string{"lobbyUUID", "hostUUID"}, []interface{}{lobbyUUID, DeletedPlayerUUID}))
+			continue
+		}
+		open = append(open, lobbyUUID)
+	}
+
+	if len(open) > 0 {
+		stmt = spanner.Statement{
+			SQL: `SELECT lobbyUUID, playerUUID FROM lobby_members
+					WHERE lobbyUUID IN UNNEST(@lobbies) AND kicked IS NULL ORDER BY lobbyUUID, joined, playerUUID`,
+			Params: map[string]interface{}{
+				"lobbies": open,
+			},
+		}
+
+		iter = txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetLobbyMembers"})
+		memberRows, err := readRows(iter)
+		if err != nil {
+			return 0, err
+		}
+
+		members := map[string][]string{}
+		for _, row := range memberRows {
+			var lobbyUUID, member string
+			if err := row.Columns(&lobbyUUID, &member); err != nil {
+				return 0, err
+			}
+			members[lobbyUUID] = append(members[lobbyUUID], member)
+		}
+
+		for _, lobbyUUID := range open {
+			host := nextLeader(members[lobbyUUID], playerUUID, playerUUID)
+			if host == "" {
+				m = append(m, spanner.Delete("lobbies", spanner.Key{lobbyUUID}))
+				continue
+			}
+			m = append(m, spanner.Update("lobbies", []string{"lobbyUUID", "hostUUID"}, []interface{}{lobbyUUID, host}))
+		}
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return 0, fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return len(rows), nil
+}
+
+// deleteLobbyMembers buffers deletes of the player's lobby memberships, including lobbies they were kicked from.
+// Returns the number of memberships deleted.
+func deleteLobbyMembers(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT lobbyUUID FROM lobby_members@{FORCE_INDEX=LobbyMemberPlayer} WHERE playerUUID = @player LIMIT @limit`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  deletionBatchSize,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetPlayerLobbies"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return 0, err
+	}
+
+	var m []*spanner.Mutation
+	for _, row := range rows {
+		var lobbyUUID string
+		if err := row.Columns(&lobbyUUID); err != nil {
+			return 0, err
+		}
+
+		m = append(m, spanner.Delete("lobby_members", spanner.Key{lobbyUUID, playerUUID}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return 0, fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return len(rows), nil
+}
+
+// deleteItemTradeOrders buffers deletes of trade orders for items the player still holds.
+// These orders reference the player's items, so they would block the items from being deleted.
+// Returns the number of trade orders deleted.
+func deleteItemTradeOrders(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT orderUUID FROM trade_orders
+				WHERE playerItemUUID IN (SELECT playerItemUUID FROM player_items WHERE playerUUID = @player)
+				LIMIT @limit`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  deletionBatchSize,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetPlayerItemOrders"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return 0, err
+	}
+
+	var m []*spanner.Mutation
+	for _, row := range rows {
+		var orderUUID string
+		if err := row.Columns(&orderUUID); err != nil {
+			return 0, err
+		}
+
+		m = append(m, spanner.Delete("trade_orders", spanner.Key{orderUUID}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return 0, fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return len(rows), nil
+}
+
+// anonymizeTradeOrders buffers updates replacing the player as lister or buyer of trade orders
+// for items that now belong to other players. Returns the number of trade orders changed.
+func anonymizeTradeOrders(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT orderUUID, lister, buyer FROM trade_orders
+				WHERE (lister = @player OR buyer = @player)
+				AND playerItemUUID NOT IN (SELECT playerItemUUID FROM player_items WHERE playerUUID = @player)
+				LIMIT @limit`,
+		Params: map[string]interface{}{
+			"player": playerUUID,
+			"limit":  deletionBatchSize,
+		},
+	}
+
+	iter := txn.QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetPlayerOrders"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return 0, err
+	}
+
+	var m []*spanner.Mutation
+	for _, row := range rows {
+		var orderUUID, lister string
+		var buyer spanner.NullString
+		if err := row.Columns(&orderUUID, &lister, &buyer); err != nil {
+			return 0, err
+		}
+
+		if lister == playerUUID {
+			lister = DeletedPlayerUUID
+		}
+
+		cols := []string{"orderUUID", "lister", "buyer"}
+		m = append(m, spanner.Update("trade_orders", cols, []interface{}{orderUUID, lister, anonymizePlayer(buyer, playerUUID)}))
+	}
+
+	if err := txn.BufferWrite(m); err != nil {
+		return 0, fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return len(rows), nil
+}
+
+// DeletePlayer removes a player and anonymizes the references other data still holds to them.
+//
+// The work is done in batches, each in its own transaction, so that deleting a player with a long
+// history doesn't exceed Spanner's mutation limits. Games and trade orders that other players took
+// part in are kept with the player's uuid replaced by DeletedPlayerUUID, and the player's game
+// participant rows are deleted. The games are found through the player's participant rows.
+// Trade orders for items the player still holds are deleted, along with the player's items and
+// ledger entries. The player leaves their party, handing its leadership to another member or
+// disbanding it when they were the last one, and the party invites they received are deleted.
+// Lobbies the player hosts are handed to another member or closed, and their lobby memberships
+// are deleted.
+//
+// The player row itself is only deleted in the batch that finds no more references, so a deletion
+// that fails part way can be safely retried. Returns ErrPlayerNotFound if the player doesn't exist.
+func DeletePlayer(ctx context.Context, client spanner.Client, playerUUID string) (DeletionReport, error) {
+	report := DeletionReport{PlayerUUID: playerUUID}
+
+	for done := false; !done; {
+		var games, orders, deleted, invites, lobbies, memberships int
+
+		_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+			_, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"playerUUID"},
+				&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayer"})
+			if spanner.ErrCode(err) == codes.NotFound {
+				return ErrPlayerNotFound
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := leaveParty(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			if invites, err = deletePartyInvites(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			if lobbies, err = rehostLobbies(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			if memberships, err = deleteLobbyMembers(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			if games, err = anonymizeGames(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			if deleted, err = deleteItemTradeOrders(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			if orders, err = anonymizeTradeOrders(ctx, txn, playerUUID); err != nil {
+				return err
+			}
+
+			// Every query returned less than a full batch, so no references remain after this transaction
+			done = games < deletionBatchSize && deleted < deletionBatchSize && orders < deletionBatchSize &&
+				invites < deletionBatchSize && lobbies < deletionBatchSize && memberships < deletionBatchSize
+			if !done {
+				return nil
+			}
+
+			// Deleting the player cascades to their items, ledger entries and tokens
+			if err := txn.BufferWrite([]*spanner.Mutation{spanner.Delete("players", spanner.Key{playerUUID})}); err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+
+			return nil
+		}, spanner.TransactionOptions{TransactionTag: "app=profile,action=delete_player"})
+
+		if err != nil {
+			return report, err
+		}
+
+		report.Games_anonymized += games
+		report.Participants_deleted += games
+		report.Trade_orders_anonymized += orders
+		report.Trade_orders_deleted += deleted
+		report.Party_invites_deleted += invites
+		report.Lobbies_rehosted += lobbies
+		report.Lobby_members_deleted += memberships
+		report.Batches++
+	}
+
+	return report, nil
+}
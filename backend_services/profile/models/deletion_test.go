@@ -0,0 +1,64 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizePlayers(t *testing.T) {
+	deleted := generateUUID()
+	other := generateUUID()
+
+	players := []string{other, deleted, other}
+	anonymized := anonymizePlayers(players, deleted)
+
+	assert.Equal(t, []string{other, DeletedPlayerUUID, other}, anonymized)
+
+	// The original list is left untouched
+	assert.Equal(t, deleted, players[1])
+}
+
+func TestAnonymizePlayer(t *testing.T) {
+	deleted := generateUUID()
+	other := spanner.NullString{StringVal: generateUUID(), Valid: true}
+
+	assert.Equal(t, spanner.NullString{StringVal: DeletedPlayerUUID, Valid: true},
+		anonymizePlayer(spanner.NullString{StringVal: deleted, Valid: true}, deleted))
+	assert.Equal(t, other, anonymizePlayer(other, deleted))
+	assert.Equal(t, spanner.NullString{}, anonymizePlayer(spanner.NullString{}, deleted))
+}
+
+func TestNextLeader(t *testing.T) {
+	leader := generateUUID()
+	member := generateUUID()
+	other := generateUUID()
+
+	// A member leaving keeps the leader
+	assert.Equal(t, leader, nextLeader([]string{leader, member, other}, leader, member))
+
+	// The leader leaving hands over to the first remaining member
+	assert.Equal(t, member, nextLeader([]string{leader, member, other}, leader, leader))
+	assert.Equal(t, member, nextLeader([]string{member, other}, leader, leader))
+
+	// The last member leaving leaves no one to lead
+	assert.Equal(t, "", nextLeader([]string{leader}, leader, leader))
+	assert.Equal(t, "", nextLeader(nil, leader, leader))
+}
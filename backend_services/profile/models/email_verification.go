@@ -0,0 +1,151 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/mailer"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// ErrInvalidToken is returned when a token does not exist, was already used or has expired
+	ErrInvalidToken = errors.New("token is invalid or expired")
+
+	// ErrEmailNotVerified is returned on login when verified emails are required and the player's email is not
+	ErrEmailNotVerified = errors.New("email address has not been verified")
+)
+
+// newToken is a private helper to create a random url-safe token. The token is returned
+// along with the hash that is stored in the database.
+func newToken() (string, []byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("could not generate token: %s", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken is a private helper to hash a token before it is stored or looked up
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// createEmailVerification buffers a new single-use verification token for the player's email
+// and returns the token to be sent to the player.
+func createEmailVerification(txn *spanner.ReadWriteTransaction, playerUUID string, email string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	cols := []string{"playerUUID", "token_hash", "email", "created", "expires"}
+	err = txn.BufferWrite([]*spanner.Mutation{
+		spanner.Insert("player_email_verifications", cols, []interface{}{playerUUID, tokenHash, email, now, now.Add(ttl)}),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return token, nil
+}
+
+// sendEmailVerification hands the verification link for the token to the mailer
+func sendEmailVerification(ctx context.Context, m mailer.Mailer, c config.EmailConfig, email string, token string) error {
+	link := fmt.Sprintf("%s?token=%s", c.Verify_url, url.QueryEscape(token))
+
+	return m.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body:    fmt.Sprintf("Follow this link to verify your email address: %s", link),
+	})
+}
+
+// VerifyEmail consumes a verification token and marks the player's email as valid.
+// The token can only be used once, and only while the player still has the email it was issued for.
+// Returns the playerUUID whose email was verified.
+func VerifyEmail(ctx context.Context, client spanner.Client, token string) (string, error) {
+	var playerUUID string
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		tokenHash := hashToken(token)
+
+		row, err := txn.ReadRowWithOptions(ctx, "player_email_verifications", spanner.Key{tokenHash},
+			[]string{"playerUUID", "email", "expires"},
+			&spanner.ReadOptions{Index: "PlayerEmailVerificationToken", RequestTag: "app=profile,action=GetEmailVerification"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var email string
+		var expires time.Time
+		if err := row.Columns(&playerUUID, &email, &expires); err != nil {
+			return err
+		}
+
+		if expires.Before(time.Now()) {
+			return ErrInvalidToken
+		}
+
+		// The player changed their email after the token was issued
+		row, err = txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"email"},
+			&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerEmail"})
+		if err != nil {
+			return err
+		}
+
+		var currentEmail string
+		if err := row.Column(0, &currentEmail); err != nil {
+			return err
+		}
+
+		if currentEmail != email {
+			return ErrInvalidToken
+		}
+
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Delete("player_email_verifications", spanner.Key{playerUUID, tokenHash}),
+			spanner.Update("players", []string{"playerUUID", "valid_email"}, []interface{}{playerUUID, true}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=verify_email"})
+
+	if err != nil {
+		return "", err
+	}
+
+	return playerUUID, nil
+}
@@ -0,0 +1,61 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingMailer keeps sent messages in memory
+type recordingMailer struct {
+	messages []mailer.Message
+}
+
+func (r *recordingMailer) Send(ctx context.Context, m mailer.Message) error {
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+func TestNewToken(t *testing.T) {
+	token, hash, err := newToken()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+	assert.Len(t, hash, 32)
+	assert.Equal(t, hash, hashToken(token))
+
+	other, _, err := newToken()
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, other)
+}
+
+func TestSendEmailVerification(t *testing.T) {
+	m := &recordingMailer{}
+	c := config.EmailConfig{Verify_url: "http://localhost:8080/players/verify"}
+
+	err := sendEmailVerification(context.Background(), m, c, "good@gmail.com", "a+token/")
+	assert.Nil(t, err)
+	assert.Len(t, m.messages, 1)
+	assert.Equal(t, "good@gmail.com", m.messages[0].To)
+	assert.True(t, strings.Contains(m.messages[0].Body, c.Verify_url+"?token="+url.QueryEscape("a+token/")))
+}
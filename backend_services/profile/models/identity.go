@@ -0,0 +1,147 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/identity"
+	"google.golang.org/grpc/codes"
+)
+
+// identityPlayerName is a private helper to name a player created from an external identity.
+// Players can choose their own name later with PATCH /players/:id.
+func identityPlayerName(playerUUID string) string {
+	return "player-" + playerUUID[:13]
+}
+
+// identityPlaceholderEmail is a private helper to return a unique email for a player whose
+// provider did not share a verified email that is free to use. The .invalid domain can never receive email.
+func identityPlaceholderEmail(playerUUID string, provider string) string {
+	return fmt.Sprintf("%s@%s.invalid", playerUUID, provider)
+}
+
+// createIdentityPlayer is a private helper to buffer a new player linked to the provider's subject.
+// The player has no password, so they can only log in through the provider until they set one with a password reset.
+func createIdentityPlayer(ctx context.Context, txn *spanner.ReadWriteTransaction, provider string, claims identity.Claims) (string, error) {
+	playerUUID := generateUUID()
+
+	email := identityPlaceholderEmail(playerUUID, provider)
+	validEmail := false
+	if claims.Email != "" && claims.Email_verified {
+		taken, err := isTaken(ctx, txn, "PlayerAuthentication", claims.Email, playerUUID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			email = claims.Email
+			validEmail = true
+		}
+	}
+
+	emptyStats := spanner.NullJSON{Value: PlayerStats{
+		Games_played: spanner.NullInt64{Int64: 0, Valid: true},
+		Games_won:    spanner.NullInt64{Int64: 0, Valid: true},
+	}, Valid: true}
+
+	now := time.Now()
+	pCols := []string{"playerUUID", "player_name", "email", "password_hash", "created", "updated", "stats", "valid_email"}
+	iCols := []string{"playerUUID", "provider", "subject", "created"}
+	err := txn.BufferWrite([]*spanner.Mutation{
+		spanner.Insert("players", pCols, []interface{}{playerUUID, identityPlayerName(playerUUID), email, []byte{}, now, now, emptyStats, validEmail}),
+		spanner.Insert("player_identities", iCols, []interface{}{playerUUID, provider, claims.Subject, now}),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return playerUUID, nil
+}
+
+// IdentityLogin logs in the player linked to the subject of a verified ID token, records a session for the client,
+// and issues a signed access token.
+// The first time a subject is seen for the provider a new player is created and linked to it, and the same
+// player is used for every later login. Returns true if the player was created.
+// Like PlayerLogin, a player with two-factor authentication enabled gets a TwoFactorRequiredError with a
+// login challenge instead of a session.
+func IdentityLogin(ctx context.Context, client spanner.Client, c config.Config, provider string, claims identity.Claims, info SessionInfo) (AccessToken, bool, error) {
+	var playerUUID string
+	var created bool
+	var challengeRequired bool
+	var accessToken AccessToken
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		created = false
+		challengeRequired = false
+
+		row, err := txn.ReadRowWithOptions(ctx, "player_identities", spanner.Key{provider, claims.Subject}, []string{"playerUUID"},
+			&spanner.ReadOptions{Index: "PlayerIdentity", RequestTag: "app=profile,action=GetPlayerIdentity"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			if playerUUID, err = createIdentityPlayer(ctx, txn, provider, claims); err != nil {
+				return err
+			}
+			created = true
+		} else if err != nil {
+			return err
+		} else if err := row.Column(0, &playerUUID); err != nil {
+			return err
+		} else {
+			tf, err := getTwoFactor(ctx, txn, playerUUID)
+			if err != nil && !errors.Is(err, ErrTwoFactorNotEnrolled) {
+				return err
+			}
+			if err == nil && tf.Enabled {
+				challengeRequired = true
+				return nil
+			}
+		}
+
+		now := time.Now()
+		cols := []string{"playerUUID", "is_logged_in", "last_login", "last_seen"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("players", cols, []interface{}{playerUUID, true, now, now}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		token, refreshHash, err := newSession(c, playerUUID)
+		if err != nil {
+			return err
+		}
+		accessToken = token
+
+		return createSession(txn, c.Session, token, refreshHash, info)
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=identity_login"})
+
+	if err != nil {
+		return AccessToken{}, false, err
+	}
+
+	if challengeRequired {
+		challenge, err := createLoginChallenge(ctx, client, c.Totp, playerUUID, nil)
+		if err != nil {
+			return AccessToken{}, false, err
+		}
+		return AccessToken{}, false, &TwoFactorRequiredError{Challenge: challenge}
+	}
+
+	return accessToken, created, nil
+}
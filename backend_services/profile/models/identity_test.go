@@ -0,0 +1,34 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityPlayerDefaults(t *testing.T) {
+	playerUUID := generateUUID()
+
+	name := identityPlayerName(playerUUID)
+	assert.NotEqual(t, name, identityPlayerName(generateUUID()))
+	assert.LessOrEqual(t, len(name), 64)
+
+	player := Player{Player_name: name, Email: identityPlaceholderEmail(playerUUID, "google"), Password: "unused"}
+	assert.Nil(t, player.Validate())
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"fmt"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/lockout"
+)
+
+// attemptReader is satisfied by both read-only and read-write transactions
+type attemptReader interface {
+	ReadWithOptions(ctx context.Context, table string, keys spanner.KeySet, columns []string, opts *spanner.ReadOptions) *spanner.RowIterator
+}
+
+// getLoginAttempts is a private helper to read the failed login history for each key.
+// Keys without any recorded failures are returned with no failures.
+func getLoginAttempts(ctx context.Context, r attemptReader, keys []string) ([]lockout.Attempts, error) {
+	var keySet []spanner.KeySet
+	for _, k := range keys {
+		keySet = append(keySet, spanner.Key{k})
+	}
+
+	iter := r.ReadWithOptions(ctx, "login_attempts", spanner.KeySets(keySet...),
+		[]string{"attempt_key", "failures", "last_failure", "locked_until"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=GetLoginAttempts"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]lockout.Attempts{}
+	for _, row := range rows {
+		var a lockout.Attempts
+		var lockedUntil spanner.NullTime
+		if err := row.Columns(&a.Attempt_key, &a.Failures, &a.Last_failure, &lockedUntil); err != nil {
+			return nil, err
+		}
+		a.Locked_until = lockedUntil.Time
+
+		found[a.Attempt_key] = a
+	}
+
+	attempts := make([]lockout.Attempts, len(keys))
+	for i, k := range keys {
+		a, ok := found[k]
+		if !ok {
+			a = lockout.Attempts{Attempt_key: k}
+		}
+		attempts[i] = a
+	}
+
+	return attempts, nil
+}
+
+// recordLoginFailure counts a failed login against each key, locking any that ran out of free attempts.
+// The counts are read and written in one transaction so concurrent failures on other replicas are not lost.
+func recordLoginFailure(ctx context.Context, client spanner.Client, l *lockout.Limiter, keys []string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		attempts, err := getLoginAttempts(ctx, txn, keys)
+		if err != nil {
+			return err
+		}
+
+		var m []*spanner.Mutation
+		cols := []string{"attempt_key", "failures", "last_failure", "locked_until"}
+		for _, a := range attempts {
+			a = l.Fail(a)
+
+			lockedUntil := spanner.NullTime{Time: a.Locked_until, Valid: !a.Locked_until.IsZero()}
+			m = append(m, spanner.InsertOrUpdate("login_attempts", cols,
+				[]interface{}{a.Attempt_key, a.Failures, a.Last_failure, lockedUntil}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=record_login_failure"})
+
+	return err
+}
+
+// clearLoginFailures forgets the failed logins for a key, once the player has logged in successfully
+func clearLoginFailures(ctx context.Context, client spanner.Client, key string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if err := txn.BufferWrite([]*spanner.Mutation{spanner.Delete("login_attempts", spanner.Key{key})}); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=clear_login_failures"})
+
+	return err
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/mailer"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrWrongPassword is returned when the player's current password does not match
+var ErrWrongPassword = errors.New("current password is incorrect")
+
+// PasswordChange contains the information to change a logged in player's password
+type PasswordChange struct {
+	Old_password string `json:"old_password" validate:"required"`
+	New_password string `json:"new_password" validate:"required"`
+}
+
+// PasswordReset contains the information to set a new password with a reset token
+type PasswordReset struct {
+	Token        string `json:"token" validate:"required"`
+	New_password string `json:"new_password" validate:"required"`
+}
+
+// ChangePassword sets a new password for the player after checking the player's current password.
+// Returns ErrWrongPassword if the current password does not match.
+func (p *Player) ChangePassword(ctx context.Context, client spanner.Client, c config.PasswordConfig, change PasswordChange) error {
+	if err := validator.New().Struct(change); err != nil {
+		return err
+	}
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{p.PlayerUUID}, []string{"password_hash"},
+			&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerPassword"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var hash []byte
+		if err := row.Column(0, &hash); err != nil {
+			return err
+		}
+
+		if err := validatePassword(change.Old_password, hash); err != nil {
+			return ErrWrongPassword
+		}
+
+		return setPassword(txn, c, p.PlayerUUID, change.New_password)
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=change_password"})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// setPassword is a private helper to buffer a new password hash for the player
+func setPassword(txn *spanner.ReadWriteTransaction, c config.PasswordConfig, playerUUID string, password string) error {
+	hash, err := hashPassword(c, password)
+	if err != nil {
+		return errors.New("unable to hash password")
+	}
+
+	cols := []string{"playerUUID", "password_hash", "updated"}
+	err = txn.BufferWrite([]*spanner.Mutation{
+		spanner.Update("players", cols, []interface{}{playerUUID, hash, time.Now().UTC().Truncate(time.Microsecond)}),
+	})
+	if err != nil {
+		return fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset stores a hashed, time-limited reset token for the player with the provided email
+// and hands the token to the mailer. No error is returned when the email does not belong to a player,
+// so callers can't use this to find out which emails are registered.
+func RequestPasswordReset(ctx context.Context, client spanner.Client, emailConfig config.EmailConfig, m mailer.Mailer, email string) error {
+	var token string
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		token = ""
+
+		row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{email}, []string{"playerUUID"},
+			&spanner.ReadOptions{Index: "PlayerAuthentication", RequestTag: "app=profile,action=GetPlayerByEmail"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var playerUUID string
+		if err := row.Column(0, &playerUUID); err != nil {
+			return err
+		}
+
+		var tokenHash []byte
+		token, tokenHash, err = newToken()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		cols := []string{"playerUUID", "token_hash", "created", "expires"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Insert("player_password_resets", cols, []interface{}{playerUUID, tokenHash, now, now.Add(emailConfig.Reset_ttl)}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=request_password_reset"})
+
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		return nil
+	}
+
+	return m.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use this token to reset your password. It expires in %s: %s", emailConfig.Reset_ttl, token),
+	})
+}
+
+// ResetPassword consumes a reset token and sets the player's new password in a single transaction.
+// All outstanding reset tokens for the player are removed, and the player is logged out of every session.
+// Returns ErrInvalidToken if the token does not exist, was already used or has expired.
+func ResetPassword(ctx context.Context, client spanner.Client, c config.PasswordConfig, reset PasswordReset) error {
+	if err := validator.New().Struct(reset); err != nil {
+		return err
+	}
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRowWithOptions(ctx, "player_password_resets", spanner.Key{hashToken(reset.Token)},
+			[]string{"playerUUID", "expires"},
+			&spanner.ReadOptions{Index: "PlayerPasswordResetToken", RequestTag: "app=profile,action=GetPasswordReset"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var playerUUID string
+		var expires time.Time
+		if err := row.Columns(&playerUUID, &expires); err != nil {
+			return err
+		}
+
+		if expires.Before(time.Now()) {
+			return ErrInvalidToken
+		}
+
+		if err := setPassword(txn, c, playerUUID, reset.New_password); err != nil {
+			return err
+		}
+
+		if _, err := revokeSessions(ctx, txn, playerUUID); err != nil {
+			return err
+		}
+
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Delete("player_password_resets", spanner.Key{playerUUID}.AsPrefix()),
+			spanner.Update("players", []string{"playerUUID", "is_logged_in"}, []interface{}{playerUUID, false}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=reset_password"})
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"testing"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/stretchr/testify/assert"
+)
+
+// Invalid requests are rejected before Spanner is contacted, so an empty client is enough here
+func TestChangePasswordRequiresBothPasswords(t *testing.T) {
+	var tests = []PasswordChange{
+		{Old_password: "mypass"},
+		{New_password: "newpass"},
+	}
+
+	player := Player{PlayerUUID: generateUUID()}
+	for _, change := range tests {
+		err := player.ChangePassword(context.Background(), spanner.Client{}, testPasswordConfig, change)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestResetPasswordRequiresTokenAndPassword(t *testing.T) {
+	var tests = []PasswordReset{
+		{Token: "sometoken"},
+		{New_password: "newpass"},
+	}
+
+	for _, reset := range tests {
+		err := ResetPassword(context.Background(), spanner.Client{}, testPasswordConfig, reset)
+		assert.NotNil(t, err)
+	}
+}
@@ -24,9 +24,14 @@ import (
 	"time"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/lockout"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/mailer"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/passhash"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	iterator "google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
 )
 
 var validate *validator.Validate
@@ -44,23 +49,61 @@ type Player struct {
 	Email           string           `json:"email" validate:"required_with=Player_name Password,email"`
 	Password        string           `json:"password" validate:"required_with=Player_name Email"` // not stored in DB
 	Password_hash   []byte           `json:"password_hash"`                                       // stored in DB
-	created         time.Time        //lint:ignore U1000 Field is present to map to database schema
-	updated         time.Time        //lint:ignore U1000 Field is present to map to database schema
+	created         time.Time        // used with updated to derive the player's ETag
+	updated         time.Time        // used with created to derive the player's ETag
 	Stats           spanner.NullJSON `json:"stats"`
 	Account_balance big.Rat          `json:"account_balance"`
 	last_login      time.Time        //lint:ignore U1000 Field is present to map to database schema
 	Is_logged_in    bool             `json:"is_logged_in"`
-	valid_email     bool             //lint:ignore U1000 Field is present to map to database schema
+	Last_seen       spanner.NullTime `json:"last_seen"`
+	valid_email     bool             // set once the player verifies their email
 	Current_game    string           `json:"current_game" validate:"omitempty,uuid4"`
 }
 
+// PlayerUpdate contains the profile fields a player can change. Fields that are not provided are left unchanged.
+type PlayerUpdate struct {
+	Player_name *string `json:"player_name" validate:"omitempty,min=1,max=64"`
+	Email       *string `json:"email" validate:"omitempty,email"`
+}
+
+var (
+	// ErrPlayerNotFound is returned when no player exists for a provided uuid
+	ErrPlayerNotFound = errors.New("player not found")
+
+	// ErrPlayerModified is returned when a player was changed after the provided ETag was retrieved
+	ErrPlayerModified = errors.New("player was modified since it was retrieved")
+
+	// ErrPlayerNameTaken is returned when another player already uses the player name
+	ErrPlayerNameTaken = errors.New("player_name is already in use")
+
+	// ErrEmailTaken is returned when another player already uses the email
+	ErrEmailTaken = errors.New("email is already in use")
+
+	// ErrPlayerNotLoggedIn is returned when a heartbeat is sent for a player that is not logged in
+	ErrPlayerNotLoggedIn = errors.New("player is not logged in")
+)
+
+// playerColumns are the columns read when retrieving a player's profile
+var playerColumns = []string{"playerUUID", "player_name", "email", "is_logged_in", "last_seen", "stats", "created", "updated", "valid_email"}
+
+// AccessToken is returned on a successful login. The token must be provided as a bearer
+// token to endpoints that act on behalf of the player.
+// The refresh token is exchanged for a new access token with POST /players/refresh.
+type AccessToken struct {
+	PlayerUUID    string    `json:"playerUUID"`
+	SessionUUID   string    `json:"sessionUUID"`
+	AccessToken   string    `json:"access_token"`
+	Expires       time.Time `json:"expires"`
+	Refresh_token string    `json:"refresh_token"`
+}
+
 func init() {
 	validate = validator.New()
 }
 
-// hashPassword is a private helper to encrypte a password using the bcrypt library
-func hashPassword(pwd string) ([]byte, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(pwd), bcrypt.DefaultCost)
+// hashPassword is a private helper to hash a password with the configured argon2id parameters
+func hashPassword(c config.PasswordConfig, pwd string) ([]byte, error) {
+	hash, err := passhash.Hash(c, pwd)
 
 	if err != nil {
 		return nil, err
@@ -69,10 +112,10 @@ func hashPassword(pwd string) ([]byte, error) {
 	return hash, nil
 }
 
-// validatePassword is a private helper to ensure a supplied hash matches the stored encrypted
-// password.
+// validatePassword is a private helper to ensure a supplied password matches the stored hash.
+// Both argon2id and legacy bcrypt hashes are supported.
 func validatePassword(pwd string, hash []byte) error {
-	return bcrypt.CompareHashAndPassword(hash, []byte(pwd))
+	return passhash.Verify(pwd, hash)
 }
 
 // generateUUID is a private helper to create and returns a v4 UUID string.
@@ -80,6 +123,27 @@ func generateUUID() string {
 	return uuid.NewString()
 }
 
+// readRows is a private helper function to read rows from Spanner.
+func readRows(iter *spanner.RowIterator) ([]spanner.Row, error) {
+	var rows []spanner.Row
+	defer iter.Stop()
+
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, *row)
+	}
+
+	return rows, nil
+}
+
 // Validate that the player has the required information based on the type's validation rules.
 func (p *Player) Validate() error {
 	validate = validator.New()
@@ -95,11 +159,55 @@ func (p *Player) Validate() error {
 	return nil
 }
 
+// Validate that the update has valid information based on the type's validation rules.
+func (u *PlayerUpdate) Validate() error {
+	return validator.New().Struct(u)
+}
+
+// ETag returns an entity tag for the player that changes every time the player is updated.
+// Players that were never updated use their created time.
+func (p *Player) ETag() string {
+	version := p.updated
+	if version.IsZero() {
+		version = p.created
+	}
+
+	return fmt.Sprintf("\"%d\"", version.UnixMicro())
+}
+
+// playerFromRow is a private helper to read a player's profile from a row containing playerColumns
+func playerFromRow(row *spanner.Row) (Player, error) {
+	player := Player{}
+	if err := row.ToStructLenient(&player); err != nil {
+		return Player{}, err
+	}
+
+	var created, updated spanner.NullTime
+	if err := row.ColumnByName("created", &created); err != nil {
+		return Player{}, err
+	}
+	if err := row.ColumnByName("updated", &updated); err != nil {
+		return Player{}, err
+	}
+	player.created = created.Time
+	player.updated = updated.Time
+
+	var validEmail spanner.NullBool
+	if err := row.ColumnByName("valid_email", &validEmail); err != nil {
+		return Player{}, err
+	}
+	player.valid_email = validEmail.Bool
+
+	return player, nil
+}
+
 // AddPlayer provides functionality to insert a player into the backend.
 // Provide with the required fields from the API call, the password is hashed and
 // a UUID is generated. This is then inserted, along with empty stats, into
 // the Spanner database.
-func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
+// A single-use verification token for the player's email is stored in the same transaction,
+// and handed to the mailer once the player is created.
+func (p *Player) AddPlayer(ctx context.Context, client spanner.Client, c config.Config, m mailer.Mailer) error {
 	// Validate based on struct validation rules
 	err := p.Validate()
 	if err != nil {
@@ -107,7 +215,7 @@ func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
 	}
 
 	// take supplied password+salt, hash. Store in user_password
-	passHash, err := hashPassword(p.Password)
+	passHash, err := hashPassword(c.Password, p.Password)
 
 	if err != nil {
 		return errors.New("unable to hash password")
@@ -124,11 +232,13 @@ func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
 		Games_won:    spanner.NullInt64{Int64: 0, Valid: true},
 	}, Valid: true}
 
+	var verificationToken string
+
 	// insert into spanner
 	_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		stmt := spanner.Statement{
-			SQL: `INSERT players (playerUUID, player_name, email, password_hash, created, stats) VALUES
-					(@playerUUID, @playerName, @email, @passwordHash, CURRENT_TIMESTAMP(), @pStats)
+			SQL: `INSERT players (playerUUID, player_name, email, password_hash, created, updated, stats, valid_email) VALUES
+					(@playerUUID, @playerName, @email, @passwordHash, CURRENT_TIMESTAMP(), CURRENT_TIMESTAMP(), @pStats, false)
 			`,
 			Params: map[string]interface{}{
 				"playerUUID":   p.PlayerUUID,
@@ -140,6 +250,11 @@ func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
 		}
 
 		_, err = txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=AddPlayer"})
+		if err != nil {
+			return err
+		}
+
+		verificationToken, err = createEmailVerification(txn, p.PlayerUUID, p.Email, c.Email.Verification_ttl)
 		return err
 	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=insert_player"})
 
@@ -148,6 +263,11 @@ func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
 		return err
 	}
 
+	// The player exists at this point, so a failure to send is logged rather than returned
+	if err := sendEmailVerification(ctx, m, c.Email, p.Email, verificationToken); err != nil {
+		fmt.Printf("could not send email verification: %s\n", err)
+	}
+
 	// return empty error on success
 	return nil
 }
@@ -156,80 +276,343 @@ func (p *Player) AddPlayer(ctx context.Context, client spanner.Client) error {
 // retrieving the player, an empty Player is returned with the error.
 func GetPlayerByUUID(ctx context.Context, client spanner.Client, uuid string) (Player, error) {
 	row, err := client.Single().ReadRowWithOptions(ctx, "players",
-		spanner.Key{uuid}, []string{"playerUUID", "player_name", "email", "is_logged_in", "stats"},
+		spanner.Key{uuid}, playerColumns,
 		&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerByUuid"})
 	if err != nil {
 		return Player{}, err
 	}
 
-	player := Player{}
-	err = row.ToStruct(&player)
+	return playerFromRow(row)
+}
 
+// isTaken is a private helper to check whether a unique index already holds a value for another player
+func isTaken(ctx context.Context, txn *spanner.ReadWriteTransaction, index string, value string, playerUUID string) (bool, error) {
+	row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{value}, []string{"playerUUID"},
+		&spanner.ReadOptions{Index: index, RequestTag: "app=profile,action=CheckUnique" + index})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return false, nil
+	}
 	if err != nil {
-		return Player{}, err
+		return false, err
 	}
-	return player, nil
+
+	var owner string
+	if err := row.Column(0, &owner); err != nil {
+		return false, err
+	}
+
+	return owner != playerUUID, nil
+}
+
+// UpdateProfile changes the player's name and/or email when the provided etag matches the player's current ETag.
+// The PlayerName and PlayerAuthentication indexes are checked so that a conflicting name or email
+// returns ErrPlayerNameTaken or ErrEmailTaken. On success the player holds the updated profile.
+// Changing the email marks it as unverified and sends a new verification token to the new address.
+func (p *Player) UpdateProfile(ctx context.Context, client spanner.Client, etag string, u PlayerUpdate, emailConfig config.EmailConfig, m mailer.Mailer) error {
+	if err := u.Validate(); err != nil {
+		return err
+	}
+
+	var verificationToken string
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{p.PlayerUUID}, playerColumns,
+			&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerForUpdate"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		current, err := playerFromRow(row)
+		if err != nil {
+			return err
+		}
+
+		// Someone else updated the player since the client retrieved it
+		if current.ETag() != etag {
+			return ErrPlayerModified
+		}
+
+		if u.Player_name != nil && *u.Player_name != current.Player_name {
+			taken, err := isTaken(ctx, txn, "PlayerName", *u.Player_name, current.PlayerUUID)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return ErrPlayerNameTaken
+			}
+			current.Player_name = *u.Player_name
+		}
+
+		if u.Email != nil && *u.Email != current.Email {
+			taken, err := isTaken(ctx, txn, "PlayerAuthentication", *u.Email, current.PlayerUUID)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return ErrEmailTaken
+			}
+			current.Email = *u.Email
+			current.valid_email = false
+
+			verificationToken, err = createEmailVerification(txn, current.PlayerUUID, current.Email, emailConfig.Verification_ttl)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Spanner stores timestamps with microsecond precision
+		current.updated = time.Now().UTC().Truncate(time.Microsecond)
+
+		cols := []string{"playerUUID", "player_name", "email", "valid_email", "updated"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("players", cols, []interface{}{current.PlayerUUID, current.Player_name, current.Email, current.valid_email, current.updated}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		*p = current
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=update_player"})
+
+	if err != nil {
+		return err
+	}
+
+	if verificationToken != "" {
+		if err := sendEmailVerification(ctx, m, emailConfig, p.Email, verificationToken); err != nil {
+			fmt.Printf("could not send email verification: %s\n", err)
+		}
+	}
+
+	return nil
 }
 
 // PlayerLogin logs the player in provided when player email and password. Updates the
-// user login info if found. Should return an error if no player was found.
-func PlayerLogin(ctx context.Context, client spanner.Client, email string, password string) (string, error) {
+// user login info if found, records a session for the client and issues a signed access token for the player.
+// Should return an error if no player was found, or ErrEmailNotVerified if verified emails are required.
+// If the player has two-factor authentication enabled, a *TwoFactorRequiredError holding a login challenge
+// is returned instead, and the player is logged in by CompleteTwoFactorLogin.
+//
+// Failed logins are counted against the email and the client's ip. Once either is locked,
+// a *lockout.LockedError is returned without checking the password.
+func PlayerLogin(ctx context.Context, client spanner.Client, c config.Config, l *lockout.Limiter, ip string, email string, password string, info SessionInfo) (AccessToken, error) {
+	keys := []string{lockout.AccountKey(email), lockout.IPKey(ip)}
+
+	var attempts []lockout.Attempts
+	if l.Enabled() {
+		var err error
+		if attempts, err = getLoginAttempts(ctx, client.Single(), keys); err != nil {
+			return AccessToken{}, err
+		}
+
+		if retry := l.RetryAfter(attempts...); retry > 0 {
+			return AccessToken{}, &lockout.LockedError{RetryAfter: retry}
+		}
+	}
+
+	// failed is a helper to count a failed login before returning its error
+	failed := func(err error) (AccessToken, error) {
+		if l.Enabled() {
+			if lockErr := recordLoginFailure(ctx, client, l, keys); lockErr != nil {
+				fmt.Printf("could not record login failure: %s\n", lockErr)
+			}
+		}
+		return AccessToken{}, err
+	}
+
 	// Get the player based on email,
 	row, err := client.Single().ReadRowWithOptions(ctx, "players",
-		spanner.Key{email}, []string{"playerUUID", "email", "password_hash", "is_logged_in"},
+		spanner.Key{email}, []string{"playerUUID", "email", "password_hash", "is_logged_in", "valid_email"},
 		&spanner.ReadOptions{Index: "PlayerAuthentication", RequestTag: "app=profile,action=GetPlayerByEmail"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return failed(err)
+	}
 	if err != nil {
-		return "", err
+		return AccessToken{}, err
 	}
 
 	player := Player{}
-	err = row.ToStruct(&player)
+	err = row.ToStructLenient(&player)
 	if err != nil {
-		return "", err
+		return AccessToken{}, err
+	}
+
+	var validEmail spanner.NullBool
+	if err := row.ColumnByName("valid_email", &validEmail); err != nil {
+		return AccessToken{}, err
 	}
 
 	// Validate that the password is correct. If it's not, return error
 	pwdErr := validatePassword(password, player.Password_hash)
 	if pwdErr != nil {
-		return "", pwdErr
+		return failed(pwdErr)
+	}
+
+	if c.Email.Require_verified && !validEmail.Bool {
+		return AccessToken{}, ErrEmailNotVerified
+	}
+
+	// The password is known to be correct here, so a hash using bcrypt or outdated parameters is replaced
+	var rehash []byte
+	if passhash.NeedsRehash(c.Password, player.Password_hash) {
+		if rehash, err = hashPassword(c.Password, password); err != nil {
+			return AccessToken{}, errors.New("unable to hash password")
+		}
 	}
 
-	// Validate that the player is not already logged in. If they are, return success.
-	if player.Is_logged_in {
-		return player.PlayerUUID, nil
+	// Players with two-factor authentication enabled are logged in once they provide a code for the challenge.
+	// Their failures are kept until the second factor is also correct.
+	enabled, err := twoFactorEnabled(ctx, client, player.PlayerUUID)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if enabled {
+		challenge, err := createLoginChallenge(ctx, client, c.Totp, player.PlayerUUID, rehash)
+		if err != nil {
+			return AccessToken{}, err
+		}
+		return AccessToken{}, &TwoFactorRequiredError{Challenge: challenge}
 	}
 
-	// If we've made it this far, update player to login
+	// A player that is already logged in only has their last_seen refreshed, so the sweeper doesn't log them out
+	sql := `UPDATE players SET last_seen=CURRENT_TIMESTAMP()`
+	if !player.Is_logged_in {
+		sql = `UPDATE players SET is_logged_in=true, last_login=CURRENT_TIMESTAMP(), last_seen=CURRENT_TIMESTAMP()`
+	}
+	params := map[string]interface{}{
+		"playerUUID": player.PlayerUUID,
+	}
+
+	if rehash != nil {
+		sql += `, password_hash=@passwordHash`
+		params["passwordHash"] = rehash
+	}
+
+	// If we've made it this far, update player to login and start a session
+	var accessToken AccessToken
 	_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		// Example of using DML to update a row.
 		stmt := spanner.Statement{
-			SQL: `UPDATE players SET is_logged_in=true, last_login=CURRENT_TIMESTAMP()
-				WHERE playerUUID=@playerUUID`,
-			Params: map[string]interface{}{
-				"playerUUID": player.PlayerUUID,
-			},
+			SQL:    sql + ` WHERE playerUUID=@playerUUID`,
+			Params: params,
 		}
 
-		_, err = txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=PlayerLogin"})
-		return err
+		if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=PlayerLogin"}); err != nil {
+			return err
+		}
+
+		token, refreshHash, err := newSession(c, player.PlayerUUID)
+		if err != nil {
+			return err
+		}
+		accessToken = token
+
+		return createSession(txn, c.Session, token, refreshHash, info)
 	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=player_login"})
 
 	if err != nil {
 		fmt.Printf("SQL Error: %s", err)
-		return "", err
+		return AccessToken{}, err
+	}
+
+	// The account's failures are forgotten once the login succeeds. Failures from the ip are kept.
+	if l.Enabled() && attempts[0].Failures > 0 {
+		if err := clearLoginFailures(ctx, client, keys[0]); err != nil {
+			fmt.Printf("could not clear login failures: %s\n", err)
+		}
+	}
+
+	return accessToken, nil
+}
+
+// Heartbeat refreshes the player's last_seen time to show their client is still connected.
+// The last use of the client's session is also recorded, unless sessionUUID is empty.
+// Returns ErrPlayerNotLoggedIn if the player logged out or was logged out by the sweeper.
+func (p *Player) Heartbeat(ctx context.Context, client spanner.Client, sessionUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: `UPDATE players SET last_seen=CURRENT_TIMESTAMP()
+				WHERE playerUUID=@playerUUID AND is_logged_in=true`,
+			Params: map[string]interface{}{
+				"playerUUID": p.PlayerUUID,
+			},
+		}
+
+		count, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=Heartbeat"})
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return ErrPlayerNotLoggedIn
+		}
+
+		if sessionUUID == "" {
+			return nil
+		}
+
+		stmt = spanner.Statement{
+			SQL: `UPDATE player_sessions SET last_used=CURRENT_TIMESTAMP()
+				WHERE playerUUID=@playerUUID AND sessionUUID=@sessionUUID AND revoked IS NULL`,
+			Params: map[string]interface{}{
+				"playerUUID":  p.PlayerUUID,
+				"sessionUUID": sessionUUID,
+			},
+		}
+
+		_, err = txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=SessionHeartbeat"})
+		return err
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=heartbeat"})
+
+	return err
+}
+
+// LogoutStalePlayers logs out every player whose last heartbeat is older than the cutoff.
+// Players that logged in before last_seen was recorded are treated as stale.
+//
+// Partitioned DML is used so the update is split across the database's partitions rather than
+// running as a single large transaction. Returns a lower bound on the number of players logged out.
+func LogoutStalePlayers(ctx context.Context, client spanner.Client, cutoff time.Time) (int64, error) {
+	stmt := spanner.Statement{
+		SQL: `UPDATE players SET is_logged_in=false
+				WHERE is_logged_in=true AND (last_seen IS NULL OR last_seen < @cutoff)`,
+		Params: map[string]interface{}{
+			"cutoff": cutoff,
+		},
 	}
 
-	return player.PlayerUUID, nil
+	return client.PartitionedUpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=LogoutStalePlayers"})
 }
 
-// PlayerLogout logs the player out when provided a player UUID. Returns an error if no player was found
-func (p *Player) PlayerLogout(ctx context.Context, client spanner.Client) error {
+// PlayerLogout logs the player out when provided a player UUID, and revokes the session the player
+// logged out from unless sessionUUID is empty. Returns an error if no player was found
+func (p *Player) PlayerLogout(ctx context.Context, client spanner.Client, sessionUUID string) error {
 	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
 		// Example of using mutations to update a row
 		var m []*spanner.Mutation
 		pCols := []string{"playerUUID", "is_logged_in"}
 		m = append(m, spanner.Update("players", pCols, []interface{}{p.PlayerUUID, false}))
 
+		// The session may already be revoked or expired, so DML is used instead of a mutation that requires the row
+		if sessionUUID != "" {
+			stmt := spanner.Statement{
+				SQL: `UPDATE player_sessions SET revoked=CURRENT_TIMESTAMP()
+					WHERE playerUUID=@playerUUID AND sessionUUID=@sessionUUID AND revoked IS NULL`,
+				Params: map[string]interface{}{
+					"playerUUID":  p.PlayerUUID,
+					"sessionUUID": sessionUUID,
+				},
+			}
+
+			if _, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=LogoutSession"}); err != nil {
+				return err
+			}
+		}
+
 		if err := txn.BufferWrite(m); err != nil {
 			return fmt.Errorf("could not buffer write: %s", err)
 		}
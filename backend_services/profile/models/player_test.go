@@ -17,8 +17,11 @@
 package models
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
@@ -51,11 +54,14 @@ func TestInvalidEmails(t *testing.T) {
 	}
 }
 
+// testPasswordConfig uses small argon2id parameters to keep the tests fast
+var testPasswordConfig = config.PasswordConfig{Memory: 1024, Iterations: 1, Parallelism: 1, Salt_length: 16, Key_length: 32}
+
 func TestHashPassword(t *testing.T) {
 	var tests = []string{"mypass", "somepass", "som1pass"}
 
 	for _, pass := range tests {
-		hash, err := hashPassword(pass)
+		hash, err := hashPassword(testPasswordConfig, pass)
 		assert.Nil(t, err)
 
 		err = validatePassword(pass, hash)
@@ -66,7 +72,7 @@ func TestHashPassword(t *testing.T) {
 func TestInvalidPassword(t *testing.T) {
 	var pass = "som1pass"
 
-	hash, err := hashPassword(pass)
+	hash, err := hashPassword(testPasswordConfig, pass)
 	assert.Nil(t, err)
 
 	err = validatePassword("mypass", hash)
@@ -80,3 +86,42 @@ func TestGenerateUUID(t *testing.T) {
 	assert.Nil(t, err)
 
 }
+
+func TestPlayerUpdateValidate(t *testing.T) {
+	name := "New Name"
+	email := "new.email@somedomain.org"
+	badEmail := "bad@gmail"
+	empty := ""
+
+	var tests = []struct {
+		update PlayerUpdate
+		valid  bool
+	}{
+		{PlayerUpdate{}, true},
+		{PlayerUpdate{Player_name: &name}, true},
+		{PlayerUpdate{Player_name: &name, Email: &email}, true},
+		{PlayerUpdate{Email: &badEmail}, false},
+		{PlayerUpdate{Player_name: &empty}, false},
+	}
+
+	for _, test := range tests {
+		err := test.update.Validate()
+		if test.valid {
+			assert.Nil(t, err)
+		} else {
+			assert.NotNil(t, err)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	created := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	player := Player{created: created}
+	assert.Equal(t, fmt.Sprintf("\"%d\"", created.UnixMicro()), player.ETag())
+
+	// Updating the player changes the ETag
+	createdETag := player.ETag()
+	player.updated = created.Add(time.Second)
+	assert.NotEqual(t, createdETag, player.ETag())
+}
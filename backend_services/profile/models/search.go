@@ -0,0 +1,143 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	spanner "cloud.google.com/go/spanner"
+)
+
+const (
+	// DefaultPageSize is used when a search doesn't ask for a page size
+	DefaultPageSize = 20
+
+	// MaxPageSize is the largest page a search can return
+	MaxPageSize = 100
+)
+
+// ErrInvalidPageToken is returned when a page token can't be decoded, or was issued for another search
+var ErrInvalidPageToken = errors.New("page token is invalid")
+
+// PlayerSummary is the public information returned when searching for players
+type PlayerSummary struct {
+	PlayerUUID  string `json:"playerUUID"`
+	Player_name string `json:"player_name"`
+}
+
+// PlayerPage is a single page of player search results.
+// Next_page_token is empty on the last page.
+type PlayerPage struct {
+	Players         []PlayerSummary `json:"players"`
+	Next_page_token string          `json:"next_page_token,omitempty"`
+}
+
+// pageToken is the position a search continues from. The prefix is kept so a token
+// can't be used to continue a different search.
+type pageToken struct {
+	Prefix string `json:"p"`
+	After  string `json:"a"`
+}
+
+// encodePageToken is a private helper to turn a search position into an opaque token
+func encodePageToken(t pageToken) (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodePageToken is a private helper to read the search position from a token.
+// Returns ErrInvalidPageToken if the token is malformed or was issued for another prefix.
+func decodePageToken(token string, prefix string) (pageToken, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return pageToken{}, ErrInvalidPageToken
+	}
+
+	var t pageToken
+	if err := json.Unmarshal(b, &t); err != nil {
+		return pageToken{}, ErrInvalidPageToken
+	}
+
+	if t.Prefix != prefix {
+		return pageToken{}, ErrInvalidPageToken
+	}
+
+	return t, nil
+}
+
+// SearchPlayers returns a page of players whose player_name starts with the prefix, ordered by name.
+//
+// The search reads the PlayerName index and continues from the last name of the previous page,
+// so every page costs the same no matter how deep into the results it is.
+// An empty pageToken returns the first page. A pageSize outside of 1 to MaxPageSize uses DefaultPageSize.
+func SearchPlayers(ctx context.Context, client spanner.Client, prefix string, token string, pageSize int) (PlayerPage, error) {
+	if pageSize < 1 || pageSize > MaxPageSize {
+		pageSize = DefaultPageSize
+	}
+
+	after := pageToken{Prefix: prefix}
+	if token != "" {
+		var err error
+		if after, err = decodePageToken(token, prefix); err != nil {
+			return PlayerPage{}, err
+		}
+	}
+
+	// Read one extra row to find out if there is another page
+	stmt := spanner.Statement{
+		SQL: `SELECT playerUUID, player_name FROM players@{FORCE_INDEX=PlayerName}
+				WHERE STARTS_WITH(player_name, @prefix) AND player_name > @after
+				ORDER BY player_name LIMIT @limit`,
+		Params: map[string]interface{}{
+			"prefix": prefix,
+			"after":  after.After,
+			"limit":  pageSize + 1,
+		},
+	}
+
+	iter := client.Single().QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=SearchPlayers"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return PlayerPage{}, err
+	}
+
+	page := PlayerPage{Players: []PlayerSummary{}}
+	for _, row := range rows {
+		var p PlayerSummary
+		if err := row.ToStruct(&p); err != nil {
+			return PlayerPage{}, err
+		}
+
+		page.Players = append(page.Players, p)
+	}
+
+	if len(page.Players) > pageSize {
+		page.Players = page.Players[:pageSize]
+
+		next := pageToken{Prefix: prefix, After: page.Players[pageSize-1].Player_name}
+		if page.Next_page_token, err = encodePageToken(next); err != nil {
+			return PlayerPage{}, err
+		}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,52 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageToken(t *testing.T) {
+	token, err := encodePageToken(pageToken{Prefix: "Foo", After: "Foo Bar"})
+	assert.Nil(t, err)
+	assert.NotContains(t, token, "Foo")
+
+	decoded, err := decodePageToken(token, "Foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "Foo Bar", decoded.After)
+}
+
+func TestInvalidPageToken(t *testing.T) {
+	token, err := encodePageToken(pageToken{Prefix: "Foo", After: "Foo Bar"})
+	assert.Nil(t, err)
+
+	var tests = []struct {
+		token  string
+		prefix string
+	}{
+		{token, "Bar"},
+		{"not a token", "Foo"},
+		{"bm90IGpzb24", "Foo"},
+	}
+
+	for _, test := range tests {
+		_, err := decodePageToken(test.token, test.prefix)
+		assert.ErrorIs(t, err, ErrInvalidPageToken)
+	}
+}
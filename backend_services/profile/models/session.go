@@ -0,0 +1,352 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/auth"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// maxDeviceLength is the longest device name stored for a session
+	maxDeviceLength = 255
+
+	// maxUserAgentLength is the longest user agent stored for a session
+	maxUserAgentLength = 1024
+)
+
+var (
+	// ErrSessionNotFound is returned when revoking a session that does not exist or was already revoked
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionRevoked is returned when an access token's session was revoked, has expired or no longer exists
+	ErrSessionRevoked = errors.New("session was revoked")
+
+	// ErrRefreshTokenReused is returned when a refresh token that was already exchanged is used again.
+	// The token may have been stolen, so the whole session is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
+// SessionInfo describes the client a player logs in from
+type SessionInfo struct {
+	Device     string
+	User_agent string
+}
+
+// Session is a player's login from one client. Current is set for the session of the access token used to list them.
+type Session struct {
+	SessionUUID string             `json:"sessionUUID"`
+	Device      spanner.NullString `json:"device"`
+	User_agent  spanner.NullString `json:"user_agent"`
+	Created     time.Time          `json:"created"`
+	Last_used   time.Time          `json:"last_used"`
+	Expires     time.Time          `json:"expires"`
+	Current     bool               `json:"current"`
+}
+
+// nullString is a private helper to store empty strings as NULL, truncated to max bytes
+func nullString(s string, max int) spanner.NullString {
+	if len(s) > max {
+		s = s[:max]
+	}
+
+	return spanner.NullString{StringVal: s, Valid: s != ""}
+}
+
+// newSession is a private helper to issue the access token and first refresh token for a new login session.
+// The hash of the refresh token is returned to be stored with the session.
+func newSession(c config.Config, playerUUID string) (AccessToken, []byte, error) {
+	sessionUUID := generateUUID()
+
+	token, expires, err := auth.IssueToken(c.Auth, playerUUID, sessionUUID)
+	if err != nil {
+		return AccessToken{}, nil, err
+	}
+
+	refresh, refreshHash, err := newToken()
+	if err != nil {
+		return AccessToken{}, nil, err
+	}
+
+	return AccessToken{
+		PlayerUUID:    playerUUID,
+		SessionUUID:   sessionUUID,
+		AccessToken:   token,
+		Expires:       expires,
+		Refresh_token: refresh,
+	}, refreshHash, nil
+}
+
+// createSession is a private helper to buffer a new login session and its first refresh token
+func createSession(txn *spanner.ReadWriteTransaction, c config.SessionConfig, token AccessToken, refreshHash []byte, info SessionInfo) error {
+	now := time.Now()
+	sCols := []string{"playerUUID", "sessionUUID", "device", "user_agent", "created", "last_used", "expires"}
+	tCols := []string{"playerUUID", "sessionUUID", "token_hash", "created"}
+
+	err := txn.BufferWrite([]*spanner.Mutation{
+		spanner.Insert("player_sessions", sCols, []interface{}{token.PlayerUUID, token.SessionUUID,
+			nullString(info.Device, maxDeviceLength), nullString(info.User_agent, maxUserAgentLength), now, now, now.Add(c.Refresh_ttl)}),
+		spanner.Insert("player_refresh_tokens", tCols, []interface{}{token.PlayerUUID, token.SessionUUID, refreshHash, now}),
+	})
+	if err != nil {
+		return fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return nil
+}
+
+// revokeSessions is a private helper to revoke all of the player's sessions in the transaction.
+// Returns the number of sessions revoked.
+func revokeSessions(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (int64, error) {
+	stmt := spanner.Statement{
+		SQL: `UPDATE player_sessions SET revoked=CURRENT_TIMESTAMP()
+				WHERE playerUUID=@playerUUID AND revoked IS NULL`,
+		Params: map[string]interface{}{
+			"playerUUID": playerUUID,
+		},
+	}
+
+	return txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=RevokeSessions"})
+}
+
+// RefreshSession exchanges a refresh token for a new access token and refresh token for the same session.
+// Each refresh token can only be used once. If an exchanged token is used again the session is revoked and
+// ErrRefreshTokenReused is returned, since either the player or whoever stole the token holds a newer one.
+// Returns ErrInvalidToken if the token does not exist, or its session was revoked or has expired.
+func RefreshSession(ctx context.Context, client spanner.Client, c config.Config, refreshToken string) (AccessToken, error) {
+	var token AccessToken
+	var reuseErr error
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		reuseErr = nil
+		tokenHash := hashToken(refreshToken)
+
+		row, err := txn.ReadRowWithOptions(ctx, "player_refresh_tokens", spanner.Key{tokenHash},
+			[]string{"playerUUID", "sessionUUID", "replaced"},
+			&spanner.ReadOptions{Index: "PlayerRefreshToken", RequestTag: "app=profile,action=GetRefreshToken"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var playerUUID, sessionUUID string
+		var replaced spanner.NullTime
+		if err := row.Columns(&playerUUID, &sessionUUID, &replaced); err != nil {
+			return err
+		}
+
+		row, err = txn.ReadRowWithOptions(ctx, "player_sessions", spanner.Key{playerUUID, sessionUUID},
+			[]string{"expires", "revoked"},
+			&spanner.ReadOptions{RequestTag: "app=profile,action=GetSession"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var expires time.Time
+		var revoked spanner.NullTime
+		if err := row.Columns(&expires, &revoked); err != nil {
+			return err
+		}
+
+		if revoked.Valid || expires.Before(time.Now()) {
+			return ErrInvalidToken
+		}
+
+		now := time.Now()
+
+		// The revocation is committed, so the error is returned after the transaction
+		if replaced.Valid {
+			reuseErr = ErrRefreshTokenReused
+
+			err := txn.BufferWrite([]*spanner.Mutation{
+				spanner.Update("player_sessions", []string{"playerUUID", "sessionUUID", "revoked"}, []interface{}{playerUUID, sessionUUID, now}),
+			})
+			if err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+
+			return nil
+		}
+
+		accessToken, expiresAt, err := auth.IssueToken(c.Auth, playerUUID, sessionUUID)
+		if err != nil {
+			return err
+		}
+
+		refresh, refreshHash, err := newToken()
+		if err != nil {
+			return err
+		}
+
+		tCols := []string{"playerUUID", "sessionUUID", "token_hash", "created", "replaced"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("player_refresh_tokens", []string{"playerUUID", "sessionUUID", "token_hash", "replaced"},
+				[]interface{}{playerUUID, sessionUUID, tokenHash, now}),
+			spanner.Insert("player_refresh_tokens", tCols, []interface{}{playerUUID, sessionUUID, refreshHash, now, spanner.NullTime{}}),
+			spanner.Update("player_sessions", []string{"playerUUID", "sessionUUID", "last_used", "expires"},
+				[]interface{}{playerUUID, sessionUUID, now, now.Add(c.Session.Refresh_ttl)}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		token = AccessToken{
+			PlayerUUID:    playerUUID,
+			SessionUUID:   sessionUUID,
+			AccessToken:   accessToken,
+			Expires:       expiresAt,
+			Refresh_token: refresh,
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=refresh_session"})
+
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if reuseErr != nil {
+		return AccessToken{}, reuseErr
+	}
+
+	return token, nil
+}
+
+// CheckSession returns ErrSessionRevoked if the player's session was revoked or has expired, so that
+// access tokens issued for it stop working before the tokens themselves expire
+func CheckSession(ctx context.Context, client spanner.Client, playerUUID string, sessionUUID string) error {
+	row, err := client.Single().ReadRowWithOptions(ctx, "player_sessions", spanner.Key{playerUUID, sessionUUID},
+		[]string{"expires", "revoked"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=CheckSession"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return ErrSessionRevoked
+	}
+	if err != nil {
+		return err
+	}
+
+	var expires time.Time
+	var revoked spanner.NullTime
+	if err := row.Columns(&expires, &revoked); err != nil {
+		return err
+	}
+
+	if revoked.Valid || expires.Before(time.Now()) {
+		return ErrSessionRevoked
+	}
+
+	return nil
+}
+
+// GetSessions returns the player's sessions that have not been revoked or expired, most recently used first.
+// The session matching currentSession is marked as current.
+func (p *Player) GetSessions(ctx context.Context, client spanner.Client, currentSession string) ([]Session, error) {
+	stmt := spanner.Statement{
+		SQL: `SELECT sessionUUID, device, user_agent, created, last_used, expires FROM player_sessions
+				WHERE playerUUID=@playerUUID AND revoked IS NULL AND expires > CURRENT_TIMESTAMP()
+				ORDER BY last_used DESC`,
+		Params: map[string]interface{}{
+			"playerUUID": p.PlayerUUID,
+		},
+	}
+
+	iter := client.Single().QueryWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=GetSessions"})
+	rows, err := readRows(iter)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []Session{}
+	for _, row := range rows {
+		var s Session
+		if err := row.Columns(&s.SessionUUID, &s.Device, &s.User_agent, &s.Created, &s.Last_used, &s.Expires); err != nil {
+			return nil, err
+		}
+		s.Current = s.SessionUUID == currentSession
+
+		sessions = append(sessions, s)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of the player's sessions, so its refresh token can no longer be used.
+// Access tokens already issued for the session are rejected by the profile service straight away,
+// the other services accept them until they expire.
+// Returns ErrSessionNotFound if the session does not exist or was already revoked.
+func (p *Player) RevokeSession(ctx context.Context, client spanner.Client, sessionUUID string) error {
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		stmt := spanner.Statement{
+			SQL: `UPDATE player_sessions SET revoked=CURRENT_TIMESTAMP()
+					WHERE playerUUID=@playerUUID AND sessionUUID=@sessionUUID AND revoked IS NULL`,
+			Params: map[string]interface{}{
+				"playerUUID":  p.PlayerUUID,
+				"sessionUUID": sessionUUID,
+			},
+		}
+
+		count, err := txn.UpdateWithOptions(ctx, stmt, spanner.QueryOptions{RequestTag: "app=profile,action=RevokeSession"})
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			return ErrSessionNotFound
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=revoke_session"})
+
+	return err
+}
+
+// RevokeSessions revokes all of the player's sessions and logs the player out.
+// Returns the number of sessions revoked.
+func (p *Player) RevokeSessions(ctx context.Context, client spanner.Client) (int64, error) {
+	var count int64
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		var err error
+		if count, err = revokeSessions(ctx, txn, p.PlayerUUID); err != nil {
+			return err
+		}
+
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("players", []string{"playerUUID", "is_logged_in"}, []interface{}{p.PlayerUUID, false}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=revoke_sessions"})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,59 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/auth"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNullString(t *testing.T) {
+	assert.False(t, nullString("", maxDeviceLength).Valid)
+
+	s := nullString("phone", maxDeviceLength)
+	assert.True(t, s.Valid)
+	assert.Equal(t, "phone", s.StringVal)
+
+	assert.Len(t, nullString(strings.Repeat("a", 300), maxDeviceLength).StringVal, maxDeviceLength)
+}
+
+func TestNewSession(t *testing.T) {
+	c := config.Config{Auth: config.AuthConfig{Enabled: true, Secret: "test-secret", Token_ttl: time.Hour}}
+	playerUUID := generateUUID()
+
+	token, refreshHash, err := newSession(c, playerUUID)
+	assert.Nil(t, err)
+	assert.Equal(t, playerUUID, token.PlayerUUID)
+	assert.NotEmpty(t, token.SessionUUID)
+	assert.Equal(t, hashToken(token.Refresh_token), refreshHash)
+
+	// The access token carries the session
+	claims, err := auth.ParseToken(c.Auth, token.AccessToken)
+	assert.Nil(t, err)
+	assert.Equal(t, playerUUID, claims.PlayerUUID)
+	assert.Equal(t, token.SessionUUID, claims.SessionUUID)
+
+	other, _, err := newSession(c, playerUUID)
+	assert.Nil(t, err)
+	assert.NotEqual(t, token.SessionUUID, other.SessionUUID)
+	assert.NotEqual(t, token.Refresh_token, other.Refresh_token)
+}
@@ -0,0 +1,536 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/lockout"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/totp"
+	"github.com/go-playground/validator/v10"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes issued when two-factor authentication is confirmed
+	recoveryCodeCount = 10
+
+	// challengeMaxAttempts is the number of wrong codes a login challenge accepts before it is removed
+	challengeMaxAttempts = 5
+)
+
+var (
+	// ErrTwoFactorEnabled is returned when enrolling or confirming a player that already has two-factor authentication enabled
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+	// ErrTwoFactorNotEnrolled is returned when confirming without enrolling first, or disabling when it isn't enabled
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enrolled")
+
+	// ErrInvalidCode is returned when a TOTP or recovery code does not match
+	ErrInvalidCode = errors.New("two-factor code is invalid")
+)
+
+// TwoFactorEnrollment is returned when a player enrolls in two-factor authentication. The secret,
+// or the otpauth uri as a QR code, is added to an authenticator app.
+type TwoFactorEnrollment struct {
+	Secret      string `json:"secret"`
+	Otpauth_uri string `json:"otpauth_uri"`
+}
+
+// TwoFactorCode is a TOTP code from the player's authenticator app, or one of their recovery codes
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// RecoveryCodes are single-use codes that can be used instead of a TOTP code.
+// They are only returned once, when two-factor authentication is confirmed.
+type RecoveryCodes struct {
+	Recovery_codes []string `json:"recovery_codes"`
+}
+
+// TwoFactorLogin completes a login for a player with two-factor authentication enabled
+type TwoFactorLogin struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code" validate:"required"`
+	Device    string `json:"device"`
+}
+
+// LoginChallenge is returned instead of an access token when the player's password is correct
+// but a second factor is still required. The challenge is exchanged for an access token along with a code.
+type LoginChallenge struct {
+	PlayerUUID string    `json:"playerUUID"`
+	Challenge  string    `json:"challenge"`
+	Expires    time.Time `json:"expires"`
+}
+
+// TwoFactorRequiredError is returned on login when the player has two-factor authentication enabled
+type TwoFactorRequiredError struct {
+	Challenge LoginChallenge
+}
+
+func (e *TwoFactorRequiredError) Error() string {
+	return "two-factor authentication is required"
+}
+
+// newRecoveryCode is a private helper to create a random recovery code formatted as 'xxxxx-xxxxx'
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate recovery code: %s", err)
+	}
+
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode is a private helper so recovery codes match regardless of case or separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// isTOTPCode is a private helper to tell a TOTP code apart from a recovery code
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// twoFactor is the stored two-factor state of a player
+type twoFactor struct {
+	Secret_encrypted []byte
+	Enabled          bool
+	Last_used_step   spanner.NullInt64
+}
+
+// getTwoFactor is a private helper to read the player's two-factor state.
+// Returns ErrTwoFactorNotEnrolled if the player never enrolled.
+func getTwoFactor(ctx context.Context, txn *spanner.ReadWriteTransaction, playerUUID string) (twoFactor, error) {
+	row, err := txn.ReadRowWithOptions(ctx, "player_two_factor", spanner.Key{playerUUID},
+		[]string{"secret_encrypted", "enabled", "last_used_step"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=GetTwoFactor"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return twoFactor{}, ErrTwoFactorNotEnrolled
+	}
+	if err != nil {
+		return twoFactor{}, err
+	}
+
+	var tf twoFactor
+	if err := row.ToStruct(&tf); err != nil {
+		return twoFactor{}, err
+	}
+
+	return tf, nil
+}
+
+// twoFactorEnabled is a private helper to check if the player must provide a second factor to log in
+func twoFactorEnabled(ctx context.Context, client spanner.Client, playerUUID string) (bool, error) {
+	row, err := client.Single().ReadRowWithOptions(ctx, "player_two_factor", spanner.Key{playerUUID}, []string{"enabled"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=GetTwoFactorEnabled"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var enabled bool
+	if err := row.Column(0, &enabled); err != nil {
+		return false, err
+	}
+
+	return enabled, nil
+}
+
+// checkSecondFactor is a private helper to verify a TOTP or recovery code for a player with two-factor enabled.
+// A TOTP code is refused if it, or a later code, was already used. A recovery code is removed once used.
+// Returns ErrInvalidCode if the code does not match.
+func checkSecondFactor(ctx context.Context, txn *spanner.ReadWriteTransaction, c config.TotpConfig, playerUUID string, code string) error {
+	tf, err := getTwoFactor(ctx, txn, playerUUID)
+	if err != nil {
+		return err
+	}
+	if !tf.Enabled {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	if isTOTPCode(code) {
+		secret, err := totp.Decrypt(c.Encryption_key, playerUUID, tf.Secret_encrypted)
+		if err != nil {
+			return err
+		}
+
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok || (tf.Last_used_step.Valid && step <= tf.Last_used_step.Int64) {
+			return ErrInvalidCode
+		}
+
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Update("player_two_factor", []string{"playerUUID", "last_used_step"}, []interface{}{playerUUID, step}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}
+
+	codeHash := hashToken(normalizeRecoveryCode(code))
+	_, err = txn.ReadRowWithOptions(ctx, "player_recovery_codes", spanner.Key{playerUUID, codeHash}, []string{"code_hash"},
+		&spanner.ReadOptions{RequestTag: "app=profile,action=GetRecoveryCode"})
+	if spanner.ErrCode(err) == codes.NotFound {
+		return ErrInvalidCode
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := txn.BufferWrite([]*spanner.Mutation{spanner.Delete("player_recovery_codes", spanner.Key{playerUUID, codeHash})}); err != nil {
+		return fmt.Errorf("could not buffer write: %s", err)
+	}
+
+	return nil
+}
+
+// EnrollTwoFactor creates a new TOTP secret for the player and stores it encrypted.
+// The secret isn't required to log in until it is confirmed with a code. Enrolling again before
+// confirming replaces the secret. Returns ErrTwoFactorEnabled if two-factor authentication is already enabled.
+func (p *Player) EnrollTwoFactor(ctx context.Context, client spanner.Client, c config.TotpConfig) (TwoFactorEnrollment, error) {
+	var enrollment TwoFactorEnrollment
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{p.PlayerUUID}, []string{"email"},
+			&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerEmail"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var email string
+		if err := row.Column(0, &email); err != nil {
+			return err
+		}
+
+		tf, err := getTwoFactor(ctx, txn, p.PlayerUUID)
+		if err != nil && !errors.Is(err, ErrTwoFactorNotEnrolled) {
+			return err
+		}
+		if tf.Enabled {
+			return ErrTwoFactorEnabled
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := totp.Encrypt(c.Encryption_key, p.PlayerUUID, secret)
+		if err != nil {
+			return err
+		}
+
+		cols := []string{"playerUUID", "secret_encrypted", "enabled", "last_used_step", "created", "confirmed"}
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.InsertOrUpdate("player_two_factor", cols,
+				[]interface{}{p.PlayerUUID, encrypted, false, spanner.NullInt64{}, time.Now(), spanner.NullTime{}}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		enrollment = TwoFactorEnrollment{
+			Secret:      totp.EncodeSecret(secret),
+			Otpauth_uri: totp.URI(c.Issuer, email, secret),
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=enroll_two_factor"})
+
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+
+	return enrollment, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the player proves their authenticator app
+// produces valid codes for the enrolled secret. Returns a new set of recovery codes, replacing any earlier ones.
+// Returns ErrTwoFactorNotEnrolled if the player hasn't enrolled, or ErrInvalidCode if the code does not match.
+func (p *Player) ConfirmTwoFactor(ctx context.Context, client spanner.Client, c config.TotpConfig, code TwoFactorCode) (RecoveryCodes, error) {
+	if err := validator.New().Struct(code); err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	var recovery RecoveryCodes
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		recovery = RecoveryCodes{}
+
+		tf, err := getTwoFactor(ctx, txn, p.PlayerUUID)
+		if err != nil {
+			return err
+		}
+		if tf.Enabled {
+			return ErrTwoFactorEnabled
+		}
+
+		secret, err := totp.Decrypt(c.Encryption_key, p.PlayerUUID, tf.Secret_encrypted)
+		if err != nil {
+			return err
+		}
+
+		step, ok := totp.Validate(secret, code.Code, time.Now())
+		if !ok {
+			return ErrInvalidCode
+		}
+
+		now := time.Now()
+		m := []*spanner.Mutation{
+			spanner.Update("player_two_factor", []string{"playerUUID", "enabled", "last_used_step", "confirmed"},
+				[]interface{}{p.PlayerUUID, true, step, now}),
+			spanner.Delete("player_recovery_codes", spanner.Key{p.PlayerUUID}.AsPrefix()),
+		}
+
+		for i := 0; i < recoveryCodeCount; i++ {
+			rc, err := newRecoveryCode()
+			if err != nil {
+				return err
+			}
+
+			recovery.Recovery_codes = append(recovery.Recovery_codes, rc)
+			m = append(m, spanner.Insert("player_recovery_codes", []string{"playerUUID", "code_hash", "created"},
+				[]interface{}{p.PlayerUUID, hashToken(normalizeRecoveryCode(rc)), now}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=confirm_two_factor"})
+
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	return recovery, nil
+}
+
+// DisableTwoFactor turns off two-factor authentication after checking a current TOTP or recovery code.
+// The secret, recovery codes and outstanding login challenges are removed.
+// Returns ErrTwoFactorNotEnrolled if two-factor authentication isn't enabled, or ErrInvalidCode if the code does not match.
+func (p *Player) DisableTwoFactor(ctx context.Context, client spanner.Client, c config.TotpConfig, code TwoFactorCode) error {
+	if err := validator.New().Struct(code); err != nil {
+		return err
+	}
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		if err := checkSecondFactor(ctx, txn, c, p.PlayerUUID, code.Code); err != nil {
+			return err
+		}
+
+		err := txn.BufferWrite([]*spanner.Mutation{
+			spanner.Delete("player_two_factor", spanner.Key{p.PlayerUUID}),
+			spanner.Delete("player_recovery_codes", spanner.Key{p.PlayerUUID}.AsPrefix()),
+			spanner.Delete("player_login_challenges", spanner.Key{p.PlayerUUID}.AsPrefix()),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=disable_two_factor"})
+
+	return err
+}
+
+// createLoginChallenge is a private helper to store a login challenge once the player's password was checked.
+// A replacement password hash is written in the same transaction when the stored hash needs a rehash.
+func createLoginChallenge(ctx context.Context, client spanner.Client, c config.TotpConfig, playerUUID string, rehash []byte) (LoginChallenge, error) {
+	token, tokenHash, err := newToken()
+	if err != nil {
+		return LoginChallenge{}, err
+	}
+
+	now := time.Now()
+	challenge := LoginChallenge{PlayerUUID: playerUUID, Challenge: token, Expires: now.Add(c.Challenge_ttl)}
+
+	_, err = client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		cols := []string{"playerUUID", "token_hash", "attempts", "created", "expires"}
+		m := []*spanner.Mutation{
+			spanner.Insert("player_login_challenges", cols, []interface{}{playerUUID, tokenHash, 0, now, challenge.Expires}),
+		}
+		if rehash != nil {
+			m = append(m, spanner.Update("players", []string{"playerUUID", "password_hash"}, []interface{}{playerUUID, rehash}))
+		}
+
+		if err := txn.BufferWrite(m); err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		return nil
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=create_login_challenge"})
+
+	if err != nil {
+		return LoginChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// CompleteTwoFactorLogin exchanges a login challenge and a TOTP or recovery code for an access token,
+// logs the player in and records a session for the client. Returns ErrInvalidToken if the challenge
+// does not exist or has expired, or ErrInvalidCode if the code does not match. A challenge is removed
+// after it is used, or after challengeMaxAttempts wrong codes.
+// Wrong codes count as failed logins for the player's email and the ip, so a locked account or ip
+// is refused with a lockout.LockedError just like a password login.
+func CompleteTwoFactorLogin(ctx context.Context, client spanner.Client, c config.Config, l *lockout.Limiter, ip string, login TwoFactorLogin, userAgent string) (AccessToken, error) {
+	if err := validator.New().Struct(login); err != nil {
+		return AccessToken{}, err
+	}
+
+	var accessToken AccessToken
+	var codeErr error
+	var keys []string
+	var loginAttempts []lockout.Attempts
+
+	_, err := client.ReadWriteTransactionWithOptions(ctx, func(ctx context.Context, txn *spanner.ReadWriteTransaction) error {
+		codeErr = nil
+		keys = nil
+		tokenHash := hashToken(login.Challenge)
+
+		row, err := txn.ReadRowWithOptions(ctx, "player_login_challenges", spanner.Key{tokenHash},
+			[]string{"playerUUID", "attempts", "expires"},
+			&spanner.ReadOptions{Index: "PlayerLoginChallengeToken", RequestTag: "app=profile,action=GetLoginChallenge"})
+		if spanner.ErrCode(err) == codes.NotFound {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var playerUUID string
+		var attempts int64
+		var expires time.Time
+		if err := row.Columns(&playerUUID, &attempts, &expires); err != nil {
+			return err
+		}
+
+		if expires.Before(time.Now()) {
+			return ErrInvalidToken
+		}
+
+		if l.Enabled() {
+			row, err := txn.ReadRowWithOptions(ctx, "players", spanner.Key{playerUUID}, []string{"email"},
+				&spanner.ReadOptions{RequestTag: "app=profile,action=GetPlayerEmail"})
+			if err != nil {
+				return err
+			}
+
+			var email string
+			if err := row.Column(0, &email); err != nil {
+				return err
+			}
+
+			keys = []string{lockout.AccountKey(email), lockout.IPKey(ip)}
+			if loginAttempts, err = getLoginAttempts(ctx, txn, keys); err != nil {
+				return err
+			}
+
+			if retry := l.RetryAfter(loginAttempts...); retry > 0 {
+				return &lockout.LockedError{RetryAfter: retry}
+			}
+		}
+
+		key := spanner.Key{playerUUID, tokenHash}
+
+		// A wrong code is counted rather than returned, so the attempt is committed
+		if err := checkSecondFactor(ctx, txn, c.Totp, playerUUID, login.Code); errors.Is(err, ErrInvalidCode) {
+			codeErr = err
+
+			m := spanner.Update("player_login_challenges", []string{"playerUUID", "token_hash", "attempts"},
+				[]interface{}{playerUUID, tokenHash, attempts + 1})
+			if attempts+1 >= challengeMaxAttempts {
+				m = spanner.Delete("player_login_challenges", key)
+			}
+
+			if err := txn.BufferWrite([]*spanner.Mutation{m}); err != nil {
+				return fmt.Errorf("could not buffer write: %s", err)
+			}
+
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err = txn.BufferWrite([]*spanner.Mutation{
+			spanner.Delete("player_login_challenges", key),
+			spanner.Update("players", []string{"playerUUID", "is_logged_in", "last_login", "last_seen"},
+				[]interface{}{playerUUID, true, now, now}),
+		})
+		if err != nil {
+			return fmt.Errorf("could not buffer write: %s", err)
+		}
+
+		token, refreshHash, err := newSession(c, playerUUID)
+		if err != nil {
+			return err
+		}
+		accessToken = token
+
+		return createSession(txn, c.Session, token, refreshHash, SessionInfo{Device: login.Device, User_agent: userAgent})
+	}, spanner.TransactionOptions{TransactionTag: "app=profile,action=complete_two_factor_login"})
+
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if codeErr != nil {
+		if keys != nil {
+			if lockErr := recordLoginFailure(ctx, client, l, keys); lockErr != nil {
+				fmt.Printf("could not record login failure: %s\n", lockErr)
+			}
+		}
+		return AccessToken{}, codeErr
+	}
+
+	// The account's failures are forgotten once both factors were correct. Failures from the ip are kept.
+	if keys != nil && loginAttempts[0].Failures > 0 {
+		if err := clearLoginFailures(ctx, client, keys[0]); err != nil {
+			fmt.Printf("could not clear login failures: %s\n", err)
+		}
+	}
+
+	return accessToken, nil
+}
@@ -0,0 +1,54 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoveryCode(t *testing.T) {
+	code, err := newRecoveryCode()
+	assert.Nil(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`), code)
+
+	other, err := newRecoveryCode()
+	assert.Nil(t, err)
+	assert.NotEqual(t, code, other)
+
+	// Codes match however the player types them
+	assert.Equal(t, hashToken(normalizeRecoveryCode(code)), hashToken(normalizeRecoveryCode(" "+code[:5]+code[6:])))
+	assert.Equal(t, normalizeRecoveryCode("abcde-fghij"), normalizeRecoveryCode("ABCDE FGHIJ"))
+}
+
+func TestIsTOTPCode(t *testing.T) {
+	assert.True(t, isTOTPCode("012345"))
+	assert.False(t, isTOTPCode("01234"))
+	assert.False(t, isTOTPCode("01234a"))
+	assert.False(t, isTOTPCode("abcde-fghij"))
+}
+
+func TestTwoFactorRequiredError(t *testing.T) {
+	var err error = &TwoFactorRequiredError{Challenge: LoginChallenge{PlayerUUID: "player", Challenge: "challenge"}}
+
+	var required *TwoFactorRequiredError
+	assert.True(t, errors.As(err, &required))
+	assert.Equal(t, "challenge", required.Challenge.Challenge)
+}
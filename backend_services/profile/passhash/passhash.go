@@ -0,0 +1,149 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package passhash hashes and verifies player passwords.
+//
+// New hashes use argon2id and are stored in the PHC string format, which records the
+// algorithm, its version and its parameters alongside the salt and key:
+//
+//	$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+//
+// Hashes created before argon2id was introduced use bcrypt, and start with '$2a$' or '$2b$'.
+// Both formats can be verified, and NeedsRehash reports the hashes that should be replaced.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var (
+	// ErrMismatch is returned when a password does not match the hash
+	ErrMismatch = errors.New("password does not match")
+
+	// ErrUnknownFormat is returned when a hash is not in a supported format
+	ErrUnknownFormat = errors.New("unknown password hash format")
+)
+
+// argon2idHash is a decoded argon2id hash
+type argon2idHash struct {
+	version     int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+// Hash returns the argon2id hash of the password using the configured parameters
+func Hash(c config.PasswordConfig, pwd string) ([]byte, error) {
+	salt := make([]byte, c.Salt_length)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %s", err)
+	}
+
+	key := argon2.IDKey([]byte(pwd), salt, c.Iterations, c.Memory, c.Parallelism, c.Key_length)
+
+	encoded := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		c.Memory, c.Iterations, c.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(encoded), nil
+}
+
+// Verify checks the password against an argon2id or legacy bcrypt hash.
+// Returns ErrMismatch if the password is wrong.
+func Verify(pwd string, hash []byte) error {
+	if isBcrypt(hash) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(pwd)); err != nil {
+			return ErrMismatch
+		}
+		return nil
+	}
+
+	h, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(pwd), h.salt, h.iterations, h.memory, h.parallelism, uint32(len(h.key)))
+	if subtle.ConstantTimeCompare(key, h.key) != 1 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+// NeedsRehash returns true if the hash is not an argon2id hash with the configured parameters
+func NeedsRehash(c config.PasswordConfig, hash []byte) bool {
+	h, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return h.version != argon2.Version ||
+		h.memory != c.Memory ||
+		h.iterations != c.Iterations ||
+		h.parallelism != c.Parallelism ||
+		uint32(len(h.salt)) != c.Salt_length ||
+		uint32(len(h.key)) != c.Key_length
+}
+
+// isBcrypt is a private helper to recognise legacy bcrypt hashes
+func isBcrypt(hash []byte) bool {
+	return strings.HasPrefix(string(hash), "$2a$") || strings.HasPrefix(string(hash), "$2b$") || strings.HasPrefix(string(hash), "$2y$")
+}
+
+// decodeArgon2id is a private helper to parse a hash in the PHC string format
+func decodeArgon2id(hash []byte) (argon2idHash, error) {
+	if !strings.HasPrefix(string(hash), argon2idPrefix) {
+		return argon2idHash{}, ErrUnknownFormat
+	}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 {
+		return argon2idHash{}, ErrUnknownFormat
+	}
+
+	var h argon2idHash
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &h.version); err != nil {
+		return argon2idHash{}, ErrUnknownFormat
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.parallelism); err != nil {
+		return argon2idHash{}, ErrUnknownFormat
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2idHash{}, ErrUnknownFormat
+	}
+
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return argon2idHash{}, ErrUnknownFormat
+	}
+
+	return h, nil
+}
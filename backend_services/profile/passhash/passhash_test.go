@@ -0,0 +1,73 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package passhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-profile-service/config"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Small parameters keep the tests fast
+var testConfig = config.PasswordConfig{Memory: 1024, Iterations: 1, Parallelism: 1, Salt_length: 16, Key_length: 32}
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := Hash(testConfig, "mypass")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(hash), "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	assert.Nil(t, Verify("mypass", hash))
+	assert.ErrorIs(t, Verify("otherpass", hash), ErrMismatch)
+
+	// The same password is salted differently each time
+	other, err := Hash(testConfig, "mypass")
+	assert.Nil(t, err)
+	assert.NotEqual(t, hash, other)
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("mypass"), bcrypt.MinCost)
+	assert.Nil(t, err)
+
+	assert.Nil(t, Verify("mypass", hash))
+	assert.ErrorIs(t, Verify("otherpass", hash), ErrMismatch)
+}
+
+func TestVerifyUnknownFormat(t *testing.T) {
+	var tests = []string{"", "plaintext", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA", "$argon2id$v=19$bad$c2FsdA$a2V5"}
+
+	for _, hash := range tests {
+		assert.ErrorIs(t, Verify("mypass", []byte(hash)), ErrUnknownFormat, hash)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, err := Hash(testConfig, "mypass")
+	assert.Nil(t, err)
+	assert.False(t, NeedsRehash(testConfig, hash))
+
+	stronger := testConfig
+	stronger.Iterations = 2
+	assert.True(t, NeedsRehash(stronger, hash))
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("mypass"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	assert.True(t, NeedsRehash(testConfig, legacy))
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package totp generates and validates time-based one-time passwords (RFC 6238)
+// for player two-factor authentication, and encrypts the shared secrets at rest.
+//
+// Codes are 6 digits, use HMAC-SHA1 and change every 30 seconds, which is what
+// common authenticator apps expect.
+package totp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Digits is the length of a code
+	Digits = 6
+
+	// Period is how long each code is valid for
+	Period = 30 * time.Second
+
+	// Skew is the number of periods either side of the current one that are also accepted,
+	// to allow for clock drift and slow typing
+	Skew = 1
+
+	secretLength = 20
+)
+
+var (
+	// ErrInvalidKey is returned when the encryption key is missing or not 32 bytes of base64
+	ErrInvalidKey = errors.New("two-factor encryption key must be 32 bytes encoded as base64")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret returns a new random shared secret
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, secretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("could not generate secret: %s", err)
+	}
+
+	return secret, nil
+}
+
+// EncodeSecret returns the secret in the base32 form players enter in their authenticator app
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI returns the otpauth:// uri for the secret, which authenticator apps can read from a QR code
+func URI(issuer string, account string, secret []byte) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	params := url.Values{}
+	params.Set("secret", EncodeSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// Step returns the time step containing t
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for the secret at a time step
+func Code(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
+
+// Validate checks the code against the secret at time t, allowing Skew periods of drift.
+// Returns the time step the code matched, so callers can refuse codes that were already used.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if hmac.Equal([]byte(Code(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// newCipher is a private helper to create the AES-GCM cipher from a base64 encoded key
+func newCipher(key string) (cipher.AEAD, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Encrypt seals the secret with AES-256-GCM. The random nonce is stored in front of the ciphertext.
+// The player's uuid is bound to the ciphertext so a secret can't be copied to another player.
+func Encrypt(key string, playerUUID string, secret []byte) ([]byte, error) {
+	aead, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("could not generate nonce: %s", err)
+	}
+
+	return aead.Seal(nonce, nonce, secret, []byte(playerUUID)), nil
+}
+
+// Decrypt opens a secret sealed by Encrypt for the same player
+func Decrypt(key string, playerUUID string, sealed []byte) ([]byte, error) {
+	aead, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted secret is too short")
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	secret, err := aead.Open(nil, nonce, ciphertext, []byte(playerUUID))
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt secret: %s", err)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,105 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package totp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The SHA1 test vectors from RFC 6238 appendix B, truncated to 6 digits
+func TestCodeRFCVectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	var tests = []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.code, Code(secret, Step(time.Unix(test.unix, 0))))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.Nil(t, err)
+
+	now := time.Unix(1700000000, 0)
+	code := Code(secret, Step(now))
+
+	step, ok := Validate(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// Codes from the neighbouring periods are accepted
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.True(t, ok)
+
+	// But not from further away
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	assert.False(t, ok)
+
+	_, ok = Validate(secret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Spanner Gaming", "good@gmail.com", []byte("12345678901234567890"))
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Spanner%20Gaming:good@gmail.com?"))
+	assert.Contains(t, uri, "secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ")
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := make([]byte, 32)
+	_, err := rand.Read(k)
+	assert.Nil(t, err)
+	key := base64.StdEncoding.EncodeToString(k)
+
+	secret := []byte("12345678901234567890")
+	sealed, err := Encrypt(key, "player-1", secret)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(sealed), string(secret))
+
+	opened, err := Decrypt(key, "player-1", sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, opened)
+
+	// The secret is bound to the player
+	_, err = Decrypt(key, "player-2", sealed)
+	assert.NotNil(t, err)
+}
+
+func TestInvalidKey(t *testing.T) {
+	_, err := Encrypt("", "player-1", []byte("secret"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = Encrypt(base64.StdEncoding.EncodeToString([]byte("short")), "player-1", []byte("secret"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
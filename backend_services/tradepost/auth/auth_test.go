@@ -0,0 +1,106 @@
+//go:build !integration
+
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-tradepost-service/config"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var testConfig = config.AuthConfig{Enabled: true, Secret: "test-secret"}
+
+var testPlayer = "ea32ff20-e10f-42c4-80d1-e0e1970eeb56"
+
+// signToken is a helper to create a token the same way the profile service does
+func signToken(t *testing.T, secret string, playerUUID string, ttl time.Duration) string {
+	claims := jwt.RegisteredClaims{
+		Issuer:    issuer,
+		Subject:   playerUUID,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	assert.Nil(t, err)
+
+	return token
+}
+
+func TestValidateToken(t *testing.T) {
+	playerUUID, err := ValidateToken(testConfig, signToken(t, testConfig.Secret, testPlayer, time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, testPlayer, playerUUID)
+}
+
+func TestValidateTokenWithoutSecret(t *testing.T) {
+	_, err := ValidateToken(config.AuthConfig{Enabled: true}, signToken(t, "", testPlayer, time.Hour))
+	assert.ErrorIs(t, err, ErrMissingSecret)
+}
+
+func TestExpiredToken(t *testing.T) {
+	_, err := ValidateToken(testConfig, signToken(t, testConfig.Secret, testPlayer, -time.Minute))
+	assert.NotNil(t, err)
+}
+
+func TestTokenWrongSecret(t *testing.T) {
+	_, err := ValidateToken(testConfig, signToken(t, "another-secret", testPlayer, time.Hour))
+	assert.NotNil(t, err)
+}
+
+// serve is a helper to run a single request through RequireToken and Authorize
+func serve(c config.AuthConfig, header string, playerUUID string) int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/players/:id", RequireToken(c), func(ctx *gin.Context) {
+		if !Authorize(ctx, ctx.Param("id")) {
+			return
+		}
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/players/"+playerUUID, nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	return w.Code
+}
+
+func TestRequireToken(t *testing.T) {
+	token := signToken(t, testConfig.Secret, testPlayer, time.Hour)
+
+	assert.Equal(t, http.StatusOK, serve(testConfig, "Bearer "+token, testPlayer))
+	assert.Equal(t, http.StatusUnauthorized, serve(testConfig, "", testPlayer))
+	assert.Equal(t, http.StatusUnauthorized, serve(testConfig, "Bearer not-a-token", testPlayer))
+	assert.Equal(t, http.StatusForbidden, serve(testConfig, "Bearer "+token, "3349f46a-215d-42e9-ab3a-759883cfeb2e"))
+}
+
+func TestRequireTokenDisabled(t *testing.T) {
+	disabled := testConfig
+	disabled.Enabled = false
+
+	assert.Equal(t, http.StatusOK, serve(disabled, "", testPlayer))
+}
@@ -29,6 +29,7 @@ import (
 type Config struct {
 	Server  ServerConfig
 	Spanner SpannerConfig
+	Auth    AuthConfig
 }
 
 // ServerConfig contains the information to expose the tradepost service as a server
@@ -45,6 +46,12 @@ type SpannerConfig struct {
 	CredentialsFile string `mapstructure:"CREDENTIALS_FILE" yaml:"credentials_file,omitempty"`
 }
 
+// AuthConfig contains the information to verify player access tokens issued by the profile service
+type AuthConfig struct {
+	Enabled bool
+	Secret  string
+}
+
 // NewConfig initializes the configuration with default values and binds
 // environment variables and reads from any supplied config.yml file
 func NewConfig() (Config, error) {
@@ -56,6 +63,9 @@ func NewConfig() (Config, error) {
 	viper.SetDefault("server.host", "localhost")
 	viper.SetDefault("server.port", 8083)
 
+	// Auth defaults
+	viper.SetDefault("auth.enabled", true)
+
 	// Bind environment variable override
 	if err := viper.BindEnv("server.host", "SERVICE_HOST"); err != nil {
 		return Config{}, fmt.Errorf("could not set environment variable 'server.host': %s", err)
@@ -73,6 +83,13 @@ func NewConfig() (Config, error) {
 		return Config{}, fmt.Errorf("could not set environment variable 'spanner.database_id': %s", err)
 	}
 
+	if err := viper.BindEnv("auth.enabled", "AUTH_ENABLED"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.enabled': %s", err)
+	}
+	if err := viper.BindEnv("auth.secret", "AUTH_SECRET"); err != nil {
+		return Config{}, fmt.Errorf("could not set environment variable 'auth.secret': %s", err)
+	}
+
 	if err := viper.ReadInConfig(); err != nil {
 		fmt.Printf("[WARNING] could not read config %s\n", err.Error())
 	}
@@ -22,6 +22,7 @@ import (
 	"net/http"
 
 	spanner "cloud.google.com/go/spanner"
+	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-tradepost-service/auth"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-tradepost-service/config"
 	"github.com/cloudspannerecosystem/spanner-gaming-sample/gaming-tradepost-service/models"
 	"github.com/gin-gonic/gin"
@@ -104,6 +105,7 @@ func getOpenOrder(c *gin.Context) {
 
 // createOrder responds to the POST /trades/sell endpoint
 // Creates a sell order and returns information about the created order
+// Requires an access token issued to the order's lister.
 func createOrder(c *gin.Context) {
 	var order models.TradeOrder
 
@@ -114,6 +116,10 @@ func createOrder(c *gin.Context) {
 		return
 	}
 
+	if !auth.Authorize(c, order.Lister) {
+		return
+	}
+
 	ctx, client := getSpannerConnection(c)
 	if err := order.Create(ctx, client); err != nil {
 		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
@@ -127,6 +133,7 @@ func createOrder(c *gin.Context) {
 
 // purchaseOrder responds to the PUT /trades/buy endpoint
 // Closes out a trade order as 'buy' and updates item and account balance information
+// Requires an access token issued to the order's buyer.
 func purchaseOrder(c *gin.Context) {
 	var order models.TradeOrder
 
@@ -137,6 +144,10 @@ func purchaseOrder(c *gin.Context) {
 		return
 	}
 
+	if !auth.Authorize(c, order.Buyer) {
+		return
+	}
+
 	ctx, client := getSpannerConnection(c)
 	if err := order.Buy(ctx, client); err != nil {
 		if err := c.AbortWithError(http.StatusBadRequest, err); err != nil {
@@ -161,10 +172,12 @@ func main() {
 
 	router.Use(setSpannerConnection(configuration))
 
+	requireToken := auth.RequireToken(configuration.Auth)
+
 	router.GET("/trades/player_items", getPlayerItem)
-	router.POST("/trades/sell", createOrder)
+	router.POST("/trades/sell", requireToken, createOrder)
 	router.GET("/trades/open", getOpenOrder)
-	router.PUT("/trades/buy", purchaseOrder)
+	router.PUT("/trades/buy", requireToken, purchaseOrder)
 
 	if err := router.Run(configuration.Server.URL()); err != nil {
 		fmt.Printf("could not run gin router: %s", err)
@@ -27,6 +27,7 @@ import (
 	instance "cloud.google.com/go/spanner/admin/instance/apiv1"
 	"embed"
 	"fmt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
@@ -47,6 +48,8 @@ var SCHEMAFILE embed.FS
 
 var TESTNETWORK = "game-sample-test"
 
+var TESTSECRET = "integration-test-secret"
+
 // These integration tests run against the Spanner emulator. The emulator
 // must be running and accessible prior to integration tests running.
 
@@ -259,6 +262,7 @@ func setupService(ctx context.Context, ec *Emulator) (*Service, error) {
 			"SERVICE_HOST":          "0.0.0.0",
 			"SERVICE_PORT":          "80",
 			"SPANNER_EMULATOR_HOST": ec.Endpoint,
+			"AUTH_SECRET":           TESTSECRET,
 		},
 		WaitingFor: wait.ForLog("Listening and serving HTTP on 0.0.0.0:80"),
 	}
@@ -314,14 +318,17 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func httpPUT(url string, data io.Reader) (*http.Response, error) {
+func httpRequest(method string, url string, data io.Reader, token string) (*http.Response, error) {
 	client := &http.Client{}
-	req, err := http.NewRequest(http.MethodPut, url, data)
+	req, err := http.NewRequest(method, url, data)
 	if err != nil {
 		return nil, err
 	}
 	// set the request header Content-Type for json
 	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -330,6 +337,23 @@ func httpPUT(url string, data io.Reader) (*http.Response, error) {
 	return response, nil
 }
 
+// testToken signs an access token for the player the same way the profile service does
+func testToken(t *testing.T, playerUUID string) string {
+	claims := jwt.RegisteredClaims{
+		Issuer:    "profile-service",
+		Subject:   playerUUID,
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(TESTSECRET))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return token
+}
+
 func TestCreateOrder(t *testing.T) {
 	// Test getting a player's item "/trades/player_items" endpoint
 	response, err := http.Get("http://localhost/trades/player_items")
@@ -364,10 +388,17 @@ func TestCreateOrder(t *testing.T) {
 		testSell := ItemSeller{Lister: piData.PlayerUUID, PlayerItemUUID: piData.PlayerItemUUID, List_price: piData.Price, expires: currentTime.Add(time.Hour * 24)}
 		sellJSON, _ := json.Marshal(testSell)
 
+		// Listing an item requires the lister's access token
 		response, err = http.Post("http://localhost/trades/sell", "application/json", bytes.NewBuffer(sellJSON))
 		if err != nil {
 			t.Fatal(err.Error())
 		}
+		assert.Equal(t, 401, response.StatusCode)
+
+		response, err = httpRequest(http.MethodPost, "http://localhost/trades/sell", bytes.NewBuffer(sellJSON), testToken(t, piData.PlayerUUID))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
 		assert.Equal(t, 201, response.StatusCode)
 
 		body, err = ioutil.ReadAll(response.Body)
@@ -415,7 +446,14 @@ func TestBuyOrder(t *testing.T) {
 		}
 		buyJSON, _ := json.Marshal(BuyRequest{OrderUUID: orderData.OrderUUID, Buyer: orderData.BuyerUUID})
 
-		response, err := httpPUT("http://localhost/trades/buy", bytes.NewBuffer(buyJSON))
+		// Buying an order on behalf of another player is rejected
+		response, err := httpRequest(http.MethodPut, "http://localhost/trades/buy", bytes.NewBuffer(buyJSON), testToken(t, uuid.NewString()))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		assert.Equal(t, 403, response.StatusCode)
+
+		response, err = httpRequest(http.MethodPut, "http://localhost/trades/buy", bytes.NewBuffer(buyJSON), testToken(t, orderData.BuyerUUID))
 		if err != nil {
 			t.Fatal(err.Error())
 		}
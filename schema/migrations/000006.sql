@@ -0,0 +1,28 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE player_email_verifications (
+  playerUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  email STRING(MAX) NOT NULL,
+  created TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, token_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerEmailVerificationToken ON player_email_verifications(token_hash) STORING (email, expires);
+
+DROP INDEX PlayerAuthentication;
+CREATE UNIQUE INDEX PlayerAuthentication ON players(email) STORING (password_hash, is_logged_in, valid_email);
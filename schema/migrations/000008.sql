@@ -0,0 +1,22 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE login_attempts (
+  attempt_key STRING(MAX) NOT NULL,
+  failures INT64 NOT NULL,
+  last_failure TIMESTAMP NOT NULL,
+  locked_until TIMESTAMP,
+) PRIMARY KEY (attempt_key),
+  ROW DELETION POLICY (OLDER_THAN(last_failure, INTERVAL 1 DAY));
@@ -0,0 +1,20 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+-- Widen password_hash to hold argon2id hashes. The index storing the column is
+-- recreated around the change.
+DROP INDEX PlayerAuthentication;
+ALTER TABLE players ALTER COLUMN password_hash BYTES(MAX) NOT NULL;
+CREATE UNIQUE INDEX PlayerAuthentication ON players(email) STORING (password_hash, is_logged_in, valid_email);
@@ -0,0 +1,24 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE player_identities (
+  playerUUID STRING(36) NOT NULL,
+  provider STRING(64) NOT NULL,
+  subject STRING(255) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, provider, subject),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerIdentity ON player_identities(provider, subject);
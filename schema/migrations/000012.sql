@@ -0,0 +1,42 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE player_two_factor (
+  playerUUID STRING(36) NOT NULL,
+  secret_encrypted BYTES(MAX) NOT NULL,
+  enabled BOOL NOT NULL,
+  last_used_step INT64,
+  created TIMESTAMP NOT NULL,
+  confirmed TIMESTAMP,
+) PRIMARY KEY (playerUUID),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE TABLE player_recovery_codes (
+  playerUUID STRING(36) NOT NULL,
+  code_hash BYTES(32) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, code_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE TABLE player_login_challenges (
+  playerUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  attempts INT64 NOT NULL,
+  created TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, token_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerLoginChallengeToken ON player_login_challenges(token_hash) STORING (attempts, expires);
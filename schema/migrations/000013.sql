@@ -0,0 +1,38 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE player_sessions (
+  playerUUID STRING(36) NOT NULL,
+  sessionUUID STRING(36) NOT NULL,
+  device STRING(255),
+  user_agent STRING(MAX),
+  created TIMESTAMP NOT NULL,
+  last_used TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+  revoked TIMESTAMP,
+) PRIMARY KEY (playerUUID, sessionUUID),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(expires, INTERVAL 7 DAY));
+
+CREATE TABLE player_refresh_tokens (
+  playerUUID STRING(36) NOT NULL,
+  sessionUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  created TIMESTAMP NOT NULL,
+  replaced TIMESTAMP,
+) PRIMARY KEY (playerUUID, sessionUUID, token_hash),
+  INTERLEAVE IN PARENT player_sessions ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerRefreshToken ON player_refresh_tokens(token_hash) STORING (replaced);
@@ -0,0 +1,22 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+ALTER TABLE players ADD COLUMN rating FLOAT64 NOT NULL DEFAULT (1500);
+
+ALTER TABLE players ADD COLUMN rating_deviation FLOAT64 NOT NULL DEFAULT (350);
+
+ALTER TABLE players ADD COLUMN idle_since TIMESTAMP;
+
+CREATE INDEX PlayerRating ON players(rating) STORING (rating_deviation, current_game, idle_since);
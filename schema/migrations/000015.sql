@@ -0,0 +1,29 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE matchmaking_tickets (
+  playerUUID STRING(36) NOT NULL,
+  ticketUUID STRING(36) NOT NULL,
+  status STRING(16) NOT NULL,
+  rating FLOAT64 NOT NULL,
+  gameUUID STRING(36),
+  created TIMESTAMP NOT NULL,
+  updated TIMESTAMP,
+  FOREIGN KEY (gameUUID) REFERENCES games (gameUUID),
+) PRIMARY KEY (playerUUID, ticketUUID),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(created, INTERVAL 7 DAY));
+
+CREATE INDEX TicketStatus ON matchmaking_tickets(status, rating) STORING (created);
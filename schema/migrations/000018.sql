@@ -0,0 +1,27 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE game_participants (
+  gameUUID STRING(36) NOT NULL,
+  playerUUID STRING(36) NOT NULL,
+  team INT64,
+  placement INT64,
+  score FLOAT64,
+  join_time TIMESTAMP NOT NULL,
+  leave_time TIMESTAMP,
+) PRIMARY KEY (gameUUID, playerUUID),
+  INTERLEAVE IN PARENT games ON DELETE CASCADE;
+
+CREATE INDEX GameParticipantPlayer ON game_participants(playerUUID, join_time DESC) STORING (team, placement, score, leave_time);
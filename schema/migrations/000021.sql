@@ -0,0 +1,44 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE parties (
+  partyUUID STRING(36) NOT NULL,
+  leaderUUID STRING(36) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (partyUUID);
+
+CREATE TABLE party_invites (
+  partyUUID STRING(36) NOT NULL,
+  playerUUID STRING(36) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (partyUUID, playerUUID),
+  INTERLEAVE IN PARENT parties ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(created, INTERVAL 1 DAY));
+
+ALTER TABLE players ADD COLUMN party STRING(36);
+
+DROP INDEX PlayerRating;
+
+CREATE INDEX PlayerRating ON players(rating) STORING (rating_deviation, current_game, idle_since, party);
+
+CREATE NULL_FILTERED INDEX PlayerParty ON players(party) STORING (rating, current_game);
+
+ALTER TABLE matchmaking_tickets ADD COLUMN party STRING(36);
+
+DROP INDEX TicketStatus;
+
+CREATE INDEX TicketStatus ON matchmaking_tickets(status, mode, rating) STORING (created, party);
+
+CREATE NULL_FILTERED INDEX TicketParty ON matchmaking_tickets(party, status);
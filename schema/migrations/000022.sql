@@ -0,0 +1,35 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+CREATE TABLE lobbies (
+  lobbyUUID STRING(36) NOT NULL,
+  code STRING(8) NOT NULL,
+  hostUUID STRING(36) NOT NULL,
+  mode STRING(64) NOT NULL,
+  gameUUID STRING(36),
+  created TIMESTAMP NOT NULL,
+  started TIMESTAMP,
+) PRIMARY KEY (lobbyUUID),
+  ROW DELETION POLICY (OLDER_THAN(created, INTERVAL 1 DAY));
+
+CREATE UNIQUE INDEX LobbyCode ON lobbies(code);
+
+CREATE TABLE lobby_members (
+  lobbyUUID STRING(36) NOT NULL,
+  playerUUID STRING(36) NOT NULL,
+  joined TIMESTAMP NOT NULL,
+  kicked TIMESTAMP,
+) PRIMARY KEY (lobbyUUID, playerUUID),
+  INTERLEAVE IN PARENT lobbies ON DELETE CASCADE;
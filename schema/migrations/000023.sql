@@ -0,0 +1,22 @@
+-- Copyright 2023 Google LLC
+--
+-- Licensed under the Apache License, Version 2.0 (the "License");
+-- you may not use this file except in compliance with the License.
+-- You may obtain a copy of the License at
+--
+--     https://www.apache.org/licenses/LICENSE-2.0
+--
+-- Unless required by applicable law or agreed to in writing, software
+-- distributed under the License is distributed on an "AS IS" BASIS,
+-- WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+-- See the License for the specific language governing permissions and
+-- limitations under the License.
+--
+
+ALTER TABLE games ADD COLUMN region STRING(64);
+
+ALTER TABLE matchmaking_tickets ADD COLUMN regions ARRAY<STRING(64)>;
+
+DROP INDEX TicketStatus;
+
+CREATE INDEX TicketStatus ON matchmaking_tickets(status, mode, rating) STORING (created, party, regions);
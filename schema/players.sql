@@ -19,26 +19,217 @@ CREATE TABLE games (
   winner STRING(36),
   created TIMESTAMP,
   finished TIMESTAMP,
+  mode STRING(64),
+  teams ARRAY<INT64>,
+  winning_team INT64,
+  placements ARRAY<INT64>,
+  scores ARRAY<FLOAT64>,
+  status STRING(16),
+  region STRING(64),
+  endpoint STRING(MAX),
+  game_server STRING(253),
 ) PRIMARY KEY(gameUUID);
 
+CREATE INDEX GameFinished ON games(finished, created);
+
+CREATE TABLE game_participants (
+  gameUUID STRING(36) NOT NULL,
+  playerUUID STRING(36) NOT NULL,
+  team INT64,
+  placement INT64,
+  score FLOAT64,
+  join_time TIMESTAMP NOT NULL,
+  leave_time TIMESTAMP,
+  participant_type STRING(16) NOT NULL DEFAULT ('player'),
+  CONSTRAINT ParticipantPlacement CHECK (placement >= 1),
+) PRIMARY KEY (gameUUID, playerUUID),
+  INTERLEAVE IN PARENT games ON DELETE CASCADE;
+
+CREATE INDEX GameParticipantPlayer ON game_participants(playerUUID, join_time DESC) STORING (team, placement, score, leave_time);
+
 CREATE TABLE players (
   playerUUID STRING(36) NOT NULL,
   player_name STRING(64) NOT NULL,
   email STRING(MAX) NOT NULL,
-  password_hash BYTES(60) NOT NULL,
+  password_hash BYTES(MAX) NOT NULL,
   created TIMESTAMP,
   updated TIMESTAMP,
   stats JSON,
   account_balance NUMERIC NOT NULL DEFAULT (0.00),
   is_logged_in BOOL NOT NULL DEFAULT (false),
   last_login TIMESTAMP,
+  last_seen TIMESTAMP,
   valid_email BOOL,
   current_game STRING(36),
+  rating FLOAT64 NOT NULL DEFAULT (1500),
+  rating_deviation FLOAT64 NOT NULL DEFAULT (350),
+  idle_since TIMESTAMP,
+  party STRING(36),
   FOREIGN KEY (current_game) REFERENCES games (gameUUID),
 ) PRIMARY KEY(playerUUID);
 
-CREATE UNIQUE INDEX PlayerAuthentication ON players(email) STORING (password_hash, is_logged_in);
+CREATE UNIQUE INDEX PlayerAuthentication ON players(email) STORING (password_hash, is_logged_in, valid_email);
 
 CREATE INDEX PlayerGame ON players(current_game);
 
-CREATE UNIQUE INDEX PlayerName ON players(player_name)
+CREATE INDEX PlayerRating ON players(rating) STORING (rating_deviation, current_game, idle_since, party);
+
+CREATE NULL_FILTERED INDEX PlayerParty ON players(party) STORING (rating, current_game);
+
+CREATE UNIQUE INDEX PlayerName ON players(player_name);
+
+CREATE TABLE player_email_verifications (
+  playerUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  email STRING(MAX) NOT NULL,
+  created TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, token_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(expires, INTERVAL 1 DAY));
+
+CREATE UNIQUE INDEX PlayerEmailVerificationToken ON player_email_verifications(token_hash) STORING (email, expires);
+
+CREATE TABLE player_password_resets (
+  playerUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  created TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, token_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(expires, INTERVAL 1 DAY));
+
+CREATE UNIQUE INDEX PlayerPasswordResetToken ON player_password_resets(token_hash) STORING (expires);
+
+CREATE TABLE player_identities (
+  playerUUID STRING(36) NOT NULL,
+  provider STRING(64) NOT NULL,
+  subject STRING(255) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, provider, subject),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerIdentity ON player_identities(provider, subject);
+
+CREATE TABLE player_two_factor (
+  playerUUID STRING(36) NOT NULL,
+  secret_encrypted BYTES(MAX) NOT NULL,
+  enabled BOOL NOT NULL,
+  last_used_step INT64,
+  created TIMESTAMP NOT NULL,
+  confirmed TIMESTAMP,
+) PRIMARY KEY (playerUUID),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE TABLE player_recovery_codes (
+  playerUUID STRING(36) NOT NULL,
+  code_hash BYTES(32) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, code_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE TABLE player_login_challenges (
+  playerUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  attempts INT64 NOT NULL,
+  created TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+) PRIMARY KEY (playerUUID, token_hash),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerLoginChallengeToken ON player_login_challenges(token_hash) STORING (attempts, expires);
+
+CREATE TABLE player_sessions (
+  playerUUID STRING(36) NOT NULL,
+  sessionUUID STRING(36) NOT NULL,
+  device STRING(255),
+  user_agent STRING(MAX),
+  created TIMESTAMP NOT NULL,
+  last_used TIMESTAMP NOT NULL,
+  expires TIMESTAMP NOT NULL,
+  revoked TIMESTAMP,
+) PRIMARY KEY (playerUUID, sessionUUID),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(expires, INTERVAL 7 DAY));
+
+CREATE TABLE player_refresh_tokens (
+  playerUUID STRING(36) NOT NULL,
+  sessionUUID STRING(36) NOT NULL,
+  token_hash BYTES(32) NOT NULL,
+  created TIMESTAMP NOT NULL,
+  replaced TIMESTAMP,
+) PRIMARY KEY (playerUUID, sessionUUID, token_hash),
+  INTERLEAVE IN PARENT player_sessions ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX PlayerRefreshToken ON player_refresh_tokens(token_hash) STORING (replaced);
+
+CREATE TABLE parties (
+  partyUUID STRING(36) NOT NULL,
+  leaderUUID STRING(36) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (partyUUID);
+
+CREATE TABLE party_invites (
+  partyUUID STRING(36) NOT NULL,
+  playerUUID STRING(36) NOT NULL,
+  created TIMESTAMP NOT NULL,
+) PRIMARY KEY (partyUUID, playerUUID),
+  INTERLEAVE IN PARENT parties ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(created, INTERVAL 1 DAY));
+
+CREATE INDEX PartyInvitePlayer ON party_invites(playerUUID);
+
+CREATE TABLE lobbies (
+  lobbyUUID STRING(36) NOT NULL,
+  code STRING(8) NOT NULL,
+  hostUUID STRING(36) NOT NULL,
+  mode STRING(64) NOT NULL,
+  gameUUID STRING(36),
+  created TIMESTAMP NOT NULL,
+  started TIMESTAMP,
+) PRIMARY KEY (lobbyUUID),
+  ROW DELETION POLICY (OLDER_THAN(created, INTERVAL 1 DAY));
+
+CREATE UNIQUE INDEX LobbyCode ON lobbies(code);
+
+CREATE NULL_FILTERED INDEX LobbyGame ON lobbies(gameUUID);
+
+CREATE INDEX LobbyHost ON lobbies(hostUUID) STORING (gameUUID);
+
+CREATE TABLE lobby_members (
+  lobbyUUID STRING(36) NOT NULL,
+  playerUUID STRING(36) NOT NULL,
+  joined TIMESTAMP NOT NULL,
+  kicked TIMESTAMP,
+) PRIMARY KEY (lobbyUUID, playerUUID),
+  INTERLEAVE IN PARENT lobbies ON DELETE CASCADE;
+
+CREATE INDEX LobbyMemberPlayer ON lobby_members(playerUUID);
+
+CREATE TABLE matchmaking_tickets (
+  playerUUID STRING(36) NOT NULL,
+  ticketUUID STRING(36) NOT NULL,
+  status STRING(16) NOT NULL,
+  rating FLOAT64 NOT NULL,
+  mode STRING(64),
+  gameUUID STRING(36),
+  party STRING(36),
+  regions ARRAY<STRING(64)>,
+  created TIMESTAMP NOT NULL,
+  updated TIMESTAMP,
+  FOREIGN KEY (gameUUID) REFERENCES games (gameUUID),
+) PRIMARY KEY (playerUUID, ticketUUID),
+  INTERLEAVE IN PARENT players ON DELETE CASCADE,
+  ROW DELETION POLICY (OLDER_THAN(created, INTERVAL 7 DAY));
+
+CREATE INDEX TicketStatus ON matchmaking_tickets(status, mode, rating) STORING (created, party, regions);
+
+CREATE NULL_FILTERED INDEX TicketParty ON matchmaking_tickets(party, status);
+
+CREATE TABLE login_attempts (
+  attempt_key STRING(MAX) NOT NULL,
+  failures INT64 NOT NULL,
+  last_failure TIMESTAMP NOT NULL,
+  locked_until TIMESTAMP,
+) PRIMARY KEY (attempt_key),
+  ROW DELETION POLICY (OLDER_THAN(last_failure, INTERVAL 1 DAY))
@@ -0,0 +1,53 @@
+#!/bin/bash
+#
+# Copyright 2023 Google LLC
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     https://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+# Backfills the game_participants table from the players, teams, placements and
+# scores arrays of games created before the table existed.
+#
+# Each batch is its own DML transaction for the games whose gameUUID starts with
+# one hex prefix, so a batch stays within Spanner's mutation limit. The default
+# prefix length of 2 runs 256 batches. Set PREFIX_LENGTH=3 to run 4096 smaller
+# batches. Participants that already exist are skipped, so the script can be
+# run again if it stops part way.
+
+if [ -z "${SPANNER_PROJECT_ID}" ] || \
+   [ -z "${SPANNER_INSTANCE_ID}" ] || \
+   [ -z "${SPANNER_DATABASE_ID}" ]
+then
+    echo "[ERROR] Environment variables must be set: " >&2
+    echo " SPANNER_PROJECT_ID, SPANNER_INSTANCE_ID, and SPANNER_DATABASE_ID" >&2
+    exit 1
+fi
+
+PREFIX_LENGTH=${PREFIX_LENGTH:-2}
+BATCHES=$((16 ** PREFIX_LENGTH))
+
+for ((i = 0; i < BATCHES; i++)); do
+    prefix=$(printf "%0${PREFIX_LENGTH}x" "${i}")
+    echo "backfilling games starting with '${prefix}'"
+
+    gcloud spanner databases execute-sql "${SPANNER_DATABASE_ID}" \
+        --project="${SPANNER_PROJECT_ID}" \
+        --instance="${SPANNER_INSTANCE_ID}" \
+        --sql="INSERT INTO game_participants (gameUUID, playerUUID, team, placement, score, join_time, leave_time)
+            SELECT g.gameUUID, p, g.teams[SAFE_OFFSET(o)], g.placements[SAFE_OFFSET(o)], g.scores[SAFE_OFFSET(o)],
+              COALESCE(g.created, g.finished, CURRENT_TIMESTAMP()), g.finished
+            FROM games g, UNNEST(g.players) AS p WITH OFFSET o
+            WHERE STARTS_WITH(g.gameUUID, '${prefix}')
+              AND p != '00000000-0000-0000-0000-000000000000'
+              AND NOT EXISTS (SELECT 1 FROM game_participants gp WHERE gp.gameUUID = g.gameUUID AND gp.playerUUID = p)" \
+        || exit 1
+done
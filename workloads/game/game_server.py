@@ -14,22 +14,71 @@
 
 """Emulate game server workload"""
 import json
+import os
 import random
+import time
 
 from locust import HttpUser, task
 
 import requests
 
+# The profile-service that load test players log in with
+PROFILE_HOST = os.environ.get("PROFILE_HOST", "http://profile")
+
+# Number of load test players that are given money and items
+PLAYER_POOL_SIZE = int(os.environ.get("PLAYER_POOL_SIZE", "50"))
+
+# Access tokens are valid for 15 minutes by default, so players log in again well before that
+TOKEN_MAX_AGE = 10 * 60
+
+def login_player(index):
+    """Sign up the load test player with the index if they don't exist yet, and log them in"""
+    name = f"loadtest{index:05d}"
+    headers = {"Content-Type": "application/json"}
+    data = {"player_name": name, "email": f"{name}@example.com", "password": name[::-1]}
+
+    # Signing up fails once the player exists, which is expected when the pool is reused
+    requests.post(f"{PROFILE_HOST}/players", data=json.dumps(data), headers=headers, timeout=10)
+
+    data = {"email": data["email"], "password": data["password"]}
+    req = requests.put(f"{PROFILE_HOST}/players/login", data=json.dumps(data), headers=headers,
+                       timeout=10)
+    req.raise_for_status()
+
+    return {"index": index, "player_uuid": req.json()["playerUUID"],
+            "access_token": req.json()["access_token"], "logged_in": time.time()}
+
 class GameLoad(HttpUser):
     """
     Leverage the item-service APIs to allow players to generate items and money
     at a 1:2 ratio to ensure they have enough money to buy items later.
+    Money and items are only given to a pool of load test players, which are logged in
+    so requests carry their access tokens.
     """
     item_uuids = {}
 
+    # Logged in load test players, shared by every user of this load generator
+    players = []
+
     def on_start(self):
-        """When starting load generator, initialize items"""
+        """When starting load generator, initialize items and log in the load test players"""
         self.get_items()
+        self.login_players()
+
+    def login_players(self):
+        """Log in the pool of load test players, unless another user already did"""
+        if len(GameLoad.players) >= PLAYER_POOL_SIZE:
+            return
+
+        GameLoad.players = [login_player(index) for index in range(PLAYER_POOL_SIZE)]
+
+    def auth_headers(self, player):
+        """Return the headers to act on behalf of the player, logging them in again when needed"""
+        if time.time() - player["logged_in"] > TOKEN_MAX_AGE:
+            player.update(login_player(player["index"]))
+
+        return {"Content-Type": "application/json",
+                "Authorization": f"Bearer {player['access_token']}"}
 
     def get_items(self):
         """Initialize list of items from endpoint"""
@@ -44,32 +93,21 @@ class GameLoad(HttpUser):
     @task(2)
     def acquire_money(self):
         """Task for random player to acquire money"""
-        headers = {"Content-Type": "application/json"}
 
-        # Get a random player that's part of a game, and update balance
-        with self.client.get("/players", headers=headers, catch_response=True) as response:
-            try:
-                data = {"playerUUID": response.json()["playerUUID"],
-                        "amount": self.generate_amount(), "source": "loot"}
-                self.client.put("/players/balance", data=json.dumps(data), headers=headers)
-            except json.JSONDecodeError:
-                response.failure("Response could not be decoded as JSON")
-            except KeyError:
-                response.failure("Response did not contain expected key 'playerUUID'")
+        # Get a random load test player, and update balance
+        player = random.choice(GameLoad.players)
+        data = {"playerUUID": player["player_uuid"],
+                "amount": self.generate_amount(), "source": "loot"}
+        self.client.put("/players/balance", data=json.dumps(data),
+                        headers=self.auth_headers(player))
 
     @task(1)
     def acquire_item(self):
         """Task for random player to acquire an item"""
-        headers = {"Content-Type": "application/json"}
 
-        # Get a random player that's part of a game, and add an item
-        with self.client.get("/players", headers=headers, catch_response=True) as response:
-            try:
-                item_uuid = self.item_uuids[random.randint(0, len(self.item_uuids)-1)]
-                data = {"playerUUID": response.json()["playerUUID"],
-                        "itemUUID": item_uuid, "source": "loot"}
-                self.client.post("/players/items", data=json.dumps(data), headers=headers)
-            except json.JSONDecodeError:
-                response.failure("Response could not be decoded as JSON")
-            except KeyError:
-                response.failure("Response did not contain expected key 'playerUUID'")
+        # Get a random load test player, and add an item
+        player = random.choice(GameLoad.players)
+        item_uuid = self.item_uuids[random.randint(0, len(self.item_uuids)-1)]
+        data = {"playerUUID": player["player_uuid"], "itemUUID": item_uuid, "source": "loot"}
+        self.client.post("/players/items", data=json.dumps(data),
+                         headers=self.auth_headers(player))
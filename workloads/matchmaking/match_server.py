@@ -15,10 +15,14 @@
 """Emulate matchmaking server workload"""
 
 import json
+import os
 
 from locust import HttpUser, task
 from locust.exception import RescheduleTask
 
+# Closing games requires the secret the matchmaking service shares with game servers
+GAME_SERVER_SECRET = os.environ.get("GAME_SERVER_SECRET", "")
+
 class GameMatch(HttpUser):
     """Create and close games to simulate players joining and finishing games
     leveraging the matchmaking-service
@@ -28,7 +32,7 @@ class GameMatch(HttpUser):
     def create_game(self):
         """Task to create a new game"""
 
-        headers = {"Content-Type": "application/json"}
+        headers = {"Content-Type": "application/json", "Authorization": f"Bearer {GAME_SERVER_SECRET}"}
 
         # Create the game, then store the response in memory of list of open games.
         self.client.post("/games/create", headers=headers)
@@ -47,7 +51,9 @@ class GameMatch(HttpUser):
                 if game_uuid == "":
                     raise RescheduleTask()
 
+                # The matchmaking service is configured to pick a random winner, since there is no game server
                 data = {"gameUUID": game_uuid}
+                headers["Authorization"] = f"Bearer {GAME_SERVER_SECRET}"
                 self.client.put("/games/close", data=json.dumps(data), headers=headers)
             except json.JSONDecodeError:
                 response.failure("Response could not be decoded as JSON")
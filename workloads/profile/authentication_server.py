@@ -24,7 +24,9 @@ from locust.exception import RescheduleTask
 class PlayerLoad(HttpUser):
     """
     Generate player load by adding new users and retrieving those uuids
-    to simulate 5:1 read and write traffic against the profile-service
+    to simulate 5:1 read and write traffic against the profile-service.
+    Logged in players send heartbeats, so the profile-service's sweeper doesn't log them out
+    in the middle of a run.
     """
 
     # Stores a list of players that were added during the run to
@@ -80,7 +82,17 @@ class PlayerLoad(HttpUser):
 
         data = { "email": player["email"], "password": player["password"]}
 
-        self.client.put("/players/login", data=json.dumps(data), headers=headers)
+        with self.client.put("/players/login", data=json.dumps(data), headers=headers,
+                                catch_response=True) as response:
+            try:
+                # Keep the access token to act on behalf of the player later
+                player["access_token"] = response.json()["access_token"]
+            except json.JSONDecodeError:
+                response.failure("Response could not be decoded as JSON")
+                return
+            except KeyError:
+                response.failure("Response did not contain expected key 'access_token'")
+                return
 
         # Append player to 'logged in player' to be used later
         self.logged_in_players.append(player)
@@ -97,13 +109,42 @@ class PlayerLoad(HttpUser):
         player = self.logged_in_players[0]
         del self.logged_in_players[0]
 
-        headers = {"Content-Type": "application/json"}
+        headers = {"Content-Type": "application/json",
+                   "Authorization": f"Bearer {player['access_token']}"}
         player_uuid = player["player_uuid"]
         self.client.get(f"/players/{player_uuid}", headers=headers, name="/players/[playerUUID]")
 
         # Add player to 'logged in player' to be re-used later
         self.logged_in_players.append(player)
 
+    @task(2)
+    def heartbeat(self):
+        """Task to send a heartbeat for a logged_in player, so the sweeper doesn't log them out"""
+
+        # No logged_in players are in memory, reschedule task to run again later.
+        if len(self.logged_in_players) == 0:
+            raise RescheduleTask()
+
+        # Get first player in our list, removing it to avoid contention from concurrent requests
+        player = self.logged_in_players[0]
+        del self.logged_in_players[0]
+
+        headers = {"Content-Type": "application/json",
+                   "Authorization": f"Bearer {player['access_token']}"}
+        player_uuid = player["player_uuid"]
+
+        with self.client.put(f"/players/{player_uuid}/heartbeat", headers=headers,
+                             name="/players/[playerUUID]/heartbeat",
+                             catch_response=True) as response:
+            # The sweeper already logged the player out, so they log in again
+            if response.status_code == 409:
+                response.success()
+                self.new_players.append(player)
+                return
+
+        # Add player to 'logged in player' to be re-used later
+        self.logged_in_players.append(player)
+
     @task
     def logout(self):
         """Task to logout players"""
@@ -116,7 +157,8 @@ class PlayerLoad(HttpUser):
         player = self.logged_in_players[0]
         del self.logged_in_players[0]
 
-        headers = {"Content-Type": "application/json"}
+        headers = {"Content-Type": "application/json",
+                   "Authorization": f"Bearer {player['access_token']}"}
 
         data = { "playerUUID": player["player_uuid"] }
 
@@ -14,12 +14,67 @@
 
 """Emulate tradingpost server workload"""
 import json
+import os
+import time
 
 from locust import HttpUser, task
 from locust.exception import RescheduleTask
 
+import requests
+
+# The profile-service that load test players log in with
+PROFILE_HOST = os.environ.get("PROFILE_HOST", "http://profile")
+
+# Number of load test players, which must match the game workload's pool
+PLAYER_POOL_SIZE = int(os.environ.get("PLAYER_POOL_SIZE", "50"))
+
+# Access tokens are valid for 15 minutes by default, so players log in again well before that
+TOKEN_MAX_AGE = 10 * 60
+
+def login_player(index):
+    """Sign up the load test player with the index if they don't exist yet, and log them in"""
+    name = f"loadtest{index:05d}"
+    headers = {"Content-Type": "application/json"}
+    data = {"player_name": name, "email": f"{name}@example.com", "password": name[::-1]}
+
+    # Signing up fails once the player exists, which is expected when the pool is reused
+    requests.post(f"{PROFILE_HOST}/players", data=json.dumps(data), headers=headers, timeout=10)
+
+    data = {"email": data["email"], "password": data["password"]}
+    req = requests.put(f"{PROFILE_HOST}/players/login", data=json.dumps(data), headers=headers,
+                       timeout=10)
+    req.raise_for_status()
+
+    return {"index": index, "player_uuid": req.json()["playerUUID"],
+            "access_token": req.json()["access_token"], "logged_in": time.time()}
+
 class TradeLoad(HttpUser):
-    """Players can sell and buy items leveraging the tradepost-service"""
+    """
+    Players can sell and buy items leveraging the tradepost-service.
+    Only the pool of load test players trades, since requests need the access token of the
+    player selling or buying. The game workload gives those players their money and items.
+    """
+
+    # Logged in load test players by uuid, shared by every user of this load generator
+    players = {}
+
+    def on_start(self):
+        """When starting load generator, log in the load test players"""
+        if len(TradeLoad.players) >= PLAYER_POOL_SIZE:
+            return
+
+        for index in range(PLAYER_POOL_SIZE):
+            player = login_player(index)
+            TradeLoad.players[player["player_uuid"]] = player
+
+    def auth_headers(self, player_uuid):
+        """Return the headers to act on behalf of the player, logging them in again when needed"""
+        player = TradeLoad.players[player_uuid]
+        if time.time() - player["logged_in"] > TOKEN_MAX_AGE:
+            player.update(login_player(player["index"]))
+
+        return {"Content-Type": "application/json",
+                "Authorization": f"Bearer {player['access_token']}"}
 
     def item_markup(self, value):
         """Return the 150% the value of the item"""
@@ -40,13 +95,14 @@ class TradeLoad(HttpUser):
                 player_item_uuid = response.json()["PlayerItemUUID"]
                 list_price = self.item_markup(response.json()["Price"])
 
-                # Currently don't have any items that can be sold, retry
-                if player_item_uuid == "":
+                # Currently don't have any items that the load test players can sell, retry
+                if player_item_uuid == "" or player_uuid not in TradeLoad.players:
                     raise RescheduleTask()
 
                 data = {"lister": player_uuid, "playerItemUUID": player_item_uuid,
                         "list_price": list_price}
-                self.client.post("/trades/sell", data=json.dumps(data), headers=headers)
+                self.client.post("/trades/sell", data=json.dumps(data),
+                                 headers=self.auth_headers(player_uuid))
             except json.JSONDecodeError:
                 response.failure("Response could not be decoded as JSON")
             except KeyError:
@@ -64,12 +120,13 @@ class TradeLoad(HttpUser):
                 order_uuid = response.json()["OrderUUID"]
                 buyer_uuid = response.json()["BuyerUUID"]
 
-                # Currently don't have any buyers that can fill the order, retry
-                if buyer_uuid == "":
+                # Currently don't have any load test players that can fill the order, retry
+                if buyer_uuid == "" or buyer_uuid not in TradeLoad.players:
                     raise RescheduleTask()
 
                 data = {"orderUUID": order_uuid, "buyer": buyer_uuid}
-                self.client.put("/trades/buy", data=json.dumps(data), headers=headers)
+                self.client.put("/trades/buy", data=json.dumps(data),
+                                headers=self.auth_headers(buyer_uuid))
             except json.JSONDecodeError:
                 response.failure("Response could not be decoded as JSON")
             except KeyError: